@@ -0,0 +1,914 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"go.mau.fi/libsignal/logger"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// Each rule acts at most once per chat within its cooldown, which stops
+	// two bots from replying to each other forever
+	defaultRuleCooldown = 5 * time.Minute
+	// Messages delivered late, e.g. after the bridge was offline, are not answered
+	autoReplyMaxAge = 10 * time.Minute
+	webhookTimeout  = 10 * time.Second
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// HoursWindow is a daily time window, e.g. business hours
+type HoursWindow struct {
+	Timezone string   `json:"timezone,omitempty"` // IANA name, defaults to UTC
+	Days     []string `json:"days,omitempty"`     // "mon" to "sun", empty for every day
+	Start    string   `json:"start"`              // "09:00"
+	End      string   `json:"end"`                // "17:30"; before start for windows past midnight
+	Outside  bool     `json:"outside,omitempty"`  // match outside the window instead
+}
+
+// RuleMatch lists the conditions a message must meet. Empty conditions match
+// everything.
+type RuleMatch struct {
+	Chats        []string     `json:"chats,omitempty"`         // chat JIDs or phone numbers
+	Senders      []string     `json:"senders,omitempty"`       // sender JIDs or phone numbers
+	Keywords     []string     `json:"keywords,omitempty"`      // whole words, case insensitive; any one matches
+	Regex        string       `json:"regex,omitempty"`         // Go regular expression
+	MessageTypes []string     `json:"message_types,omitempty"` // "text", "image", "document", ...
+	Hours        *HoursWindow `json:"hours,omitempty"`
+}
+
+// RuleAction is something done when a rule matches
+type RuleAction struct {
+	Type      string                 `json:"type"`                // "reply", "template", "react", "forward", "webhook"
+	Text      string                 `json:"text,omitempty"`      // reply text, may use the variables below
+	Template  string                 `json:"template,omitempty"`  // template name
+	Variables map[string]interface{} `json:"variables,omitempty"` // template variables besides the built-in ones
+	Quote     bool                   `json:"quote,omitempty"`     // quote the incoming message in the reply
+	Emoji     string                 `json:"emoji,omitempty"`     // reaction
+	To        string                 `json:"to,omitempty"`        // chat to forward to
+	URL       string                 `json:"url,omitempty"`       // webhook to POST the message to
+}
+
+// AutoReplyRule is a rule evaluated against every incoming message. Replies
+// and templates can use {{.sender}}, {{.sender_name}}, {{.chat}},
+// {{.message}} and {{.type}}.
+type AutoReplyRule struct {
+	ID              string       `json:"id"`
+	Name            string       `json:"name"`
+	Disabled        bool         `json:"disabled,omitempty"`
+	Priority        int          `json:"priority"`       // lower runs first
+	Stop            bool         `json:"stop,omitempty"` // skip lower priority rules after this one matches
+	Match           RuleMatch    `json:"match"`
+	Actions         []RuleAction `json:"actions"`
+	CooldownSeconds int          `json:"cooldown_seconds,omitempty"` // defaults to 5 minutes
+	CreatedAt       time.Time    `json:"created_at"`
+	UpdatedAt       time.Time    `json:"updated_at"`
+}
+
+// IncomingMessage is what rules see of an incoming message
+type IncomingMessage struct {
+	ID         string    `json:"message_id"`
+	ChatJID    string    `json:"chat_jid"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"sender_name"`
+	Type       string    `json:"type"`
+	Content    string    `json:"content"`
+	Time       time.Time `json:"timestamp"`
+	raw        *waE2E.Message
+	senderJIDs []string
+}
+
+// compiledRule is a rule with its matchers prepared
+type compiledRule struct {
+	AutoReplyRule
+	chats    map[string]bool
+	senders  map[string]bool
+	types    map[string]bool
+	regex    *regexp.Regexp
+	hours    *hoursMatcher
+	cooldown time.Duration
+}
+
+// hoursMatcher is a parsed HoursWindow
+type hoursMatcher struct {
+	loc        *time.Location
+	days       map[time.Weekday]bool
+	start, end int // minutes after midnight
+	outside    bool
+}
+
+// Parse the window
+func (h *HoursWindow) compile() (*hoursMatcher, error) {
+	tz := h.Timezone
+	if tz == "" {
+		tz = "UTC"
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone: %v", err)
+	}
+
+	m := &hoursMatcher{loc: loc, outside: h.Outside}
+	if m.start, err = parseClock(h.Start); err != nil {
+		return nil, err
+	}
+	if m.end, err = parseClock(h.End); err != nil {
+		return nil, err
+	}
+	if len(h.Days) > 0 {
+		m.days = make(map[time.Weekday]bool)
+		for _, day := range h.Days {
+			weekday, ok := weekdayNames[strings.ToLower(day)]
+			if !ok {
+				return nil, fmt.Errorf("invalid day %q", day)
+			}
+			m.days[weekday] = true
+		}
+	}
+	return m, nil
+}
+
+// Parse a wall clock time such as "09:30" into minutes after midnight.
+// "24:00" is accepted as the end of the day.
+func parseClock(value string) (int, error) {
+	if value == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Report whether t falls in the window. Windows that run past midnight
+// belong to the day they start on; equal start and end cover the whole day.
+func (m *hoursMatcher) contains(t time.Time) bool {
+	local := t.In(m.loc)
+	minute := local.Hour()*60 + local.Minute()
+	day := local.Weekday()
+	dayAllowed := func(d time.Weekday) bool { return m.days == nil || m.days[d] }
+
+	var in bool
+	switch {
+	case m.start == m.end:
+		in = dayAllowed(day)
+	case m.start < m.end:
+		in = dayAllowed(day) && minute >= m.start && minute < m.end
+	default:
+		in = (dayAllowed(day) && minute >= m.start) || (dayAllowed((day+6)%7) && minute < m.end)
+	}
+	return in != m.outside
+}
+
+// Report whether word appears in text on word boundaries, ignoring case
+func containsWord(text, word string) bool {
+	if word == "" {
+		return false
+	}
+	text, word = strings.ToLower(text), strings.ToLower(word)
+	isWordRune := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	for offset := 0; ; {
+		i := strings.Index(text[offset:], word)
+		if i < 0 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(word)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if (start == 0 || !isWordRune(before)) && (end == len(text) || !isWordRune(after)) {
+			return true
+		}
+		offset = start + 1
+	}
+}
+
+// Check a rule and prepare its matchers. Chats and senders are normalised
+// to JIDs.
+func compileRule(rule *AutoReplyRule, messageStore *MessageStore) (*compiledRule, error) {
+	if strings.TrimSpace(rule.Name) == "" {
+		return nil, fmt.Errorf("rule name is required")
+	}
+	if rule.CooldownSeconds < 0 {
+		return nil, fmt.Errorf("cooldown_seconds must not be negative")
+	}
+	if len(rule.Actions) == 0 {
+		return nil, fmt.Errorf("at least one action is required")
+	}
+
+	c := &compiledRule{AutoReplyRule: *rule, cooldown: defaultRuleCooldown}
+	if rule.CooldownSeconds > 0 {
+		c.cooldown = time.Duration(rule.CooldownSeconds) * time.Second
+	}
+
+	if len(rule.Match.Chats) > 0 {
+		c.chats = make(map[string]bool)
+		for i, chat := range rule.Match.Chats {
+			jid, err := parseChatJID(chat)
+			if err != nil {
+				return nil, fmt.Errorf("invalid chat %q: %v", chat, err)
+			}
+			rule.Match.Chats[i] = jid.String()
+			c.chats[jid.String()] = true
+		}
+	}
+	if len(rule.Match.Senders) > 0 {
+		c.senders = make(map[string]bool)
+		for i, sender := range rule.Match.Senders {
+			jid, err := parseUserJID(sender)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sender %q: %v", sender, err)
+			}
+			rule.Match.Senders[i] = jid.ToNonAD().String()
+			c.senders[jid.ToNonAD().String()] = true
+		}
+	}
+	for _, keyword := range rule.Match.Keywords {
+		if strings.TrimSpace(keyword) == "" {
+			return nil, fmt.Errorf("keywords must not be empty")
+		}
+	}
+	if len(rule.Match.MessageTypes) > 0 {
+		c.types = make(map[string]bool)
+		for _, t := range rule.Match.MessageTypes {
+			c.types[strings.ToLower(t)] = true
+		}
+	}
+	if rule.Match.Regex != "" {
+		re, err := regexp.Compile(rule.Match.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %v", err)
+		}
+		c.regex = re
+	}
+	if rule.Match.Hours != nil {
+		hours, err := rule.Match.Hours.compile()
+		if err != nil {
+			return nil, err
+		}
+		c.hours = hours
+	}
+
+	for i, action := range rule.Actions {
+		var err error
+		switch action.Type {
+		case "reply":
+			if action.Text == "" {
+				err = fmt.Errorf("text is required")
+			} else {
+				t := Template{Name: "reply", Body: action.Text}
+				err = t.validate()
+			}
+		case "template":
+			if action.Template == "" {
+				err = fmt.Errorf("template is required")
+			} else if _, lookupErr := messageStore.GetTemplate(action.Template); lookupErr == sql.ErrNoRows {
+				err = fmt.Errorf("template %q not found", action.Template)
+			}
+		case "react":
+			if action.Emoji == "" {
+				err = fmt.Errorf("emoji is required")
+			}
+		case "forward":
+			var jid types.JID
+			if jid, err = parseChatJID(action.To); err == nil {
+				rule.Actions[i].To = jid.String()
+			}
+		case "webhook":
+			if u, parseErr := url.Parse(action.URL); parseErr != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				err = fmt.Errorf("url must be an http or https URL")
+			}
+		default:
+			err = fmt.Errorf("unknown action type %q", action.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("action %d: %v", i+1, err)
+		}
+	}
+	c.AutoReplyRule = *rule
+	return c, nil
+}
+
+// Report whether the rule matches the message
+func (c *compiledRule) matches(msg *IncomingMessage, now time.Time) bool {
+	if c.chats != nil && !c.chats[msg.ChatJID] {
+		return false
+	}
+	if c.senders != nil {
+		found := false
+		for _, jid := range msg.senderJIDs {
+			found = found || c.senders[jid]
+		}
+		if !found {
+			return false
+		}
+	}
+	if c.types != nil && !c.types[msg.Type] {
+		return false
+	}
+	if len(c.Match.Keywords) > 0 {
+		found := false
+		for _, keyword := range c.Match.Keywords {
+			if containsWord(msg.Content, keyword) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if c.regex != nil && !c.regex.MatchString(msg.Content) {
+		return false
+	}
+	if c.hours != nil && !c.hours.contains(now) {
+		return false
+	}
+	return true
+}
+
+// Store a new rule
+func (store *MessageStore) CreateAutoReplyRule(rule AutoReplyRule) error {
+	match, actions, err := encodeRuleParts(rule)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(
+		`INSERT INTO auto_reply_rules (id, name, disabled, priority, stop, match, actions, cooldown_seconds, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rule.ID, rule.Name, rule.Disabled, rule.Priority, rule.Stop, match, actions, rule.CooldownSeconds,
+		rule.CreatedAt, rule.UpdatedAt,
+	)
+	return err
+}
+
+// Replace a rule. Returns sql.ErrNoRows if there is no such rule.
+func (store *MessageStore) UpdateAutoReplyRule(rule AutoReplyRule) error {
+	match, actions, err := encodeRuleParts(rule)
+	if err != nil {
+		return err
+	}
+	result, err := store.db.Exec(
+		`UPDATE auto_reply_rules SET name = ?, disabled = ?, priority = ?, stop = ?, match = ?, actions = ?,
+			cooldown_seconds = ?, updated_at = ?
+		WHERE id = ?`,
+		rule.Name, rule.Disabled, rule.Priority, rule.Stop, match, actions, rule.CooldownSeconds, rule.UpdatedAt, rule.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Delete a rule. Returns sql.ErrNoRows if there is no such rule.
+func (store *MessageStore) DeleteAutoReplyRule(id string) error {
+	result, err := store.db.Exec(`DELETE FROM auto_reply_rules WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Encode the match and actions of a rule for storage
+func encodeRuleParts(rule AutoReplyRule) (string, string, error) {
+	match, err := json.Marshal(rule.Match)
+	if err != nil {
+		return "", "", err
+	}
+	actions, err := json.Marshal(rule.Actions)
+	if err != nil {
+		return "", "", err
+	}
+	return string(match), string(actions), nil
+}
+
+// Scan a rule row
+func scanAutoReplyRule(row interface{ Scan(...interface{}) error }, rule *AutoReplyRule) error {
+	var match, actions string
+	err := row.Scan(&rule.ID, &rule.Name, &rule.Disabled, &rule.Priority, &rule.Stop, &match, &actions,
+		&rule.CooldownSeconds, &rule.CreatedAt, &rule.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(match), &rule.Match); err != nil {
+		return fmt.Errorf("invalid match of rule %s: %v", rule.ID, err)
+	}
+	if err := json.Unmarshal([]byte(actions), &rule.Actions); err != nil {
+		return fmt.Errorf("invalid actions of rule %s: %v", rule.ID, err)
+	}
+	return nil
+}
+
+const autoReplyRuleColumns = `id, name, disabled, priority, stop, match, actions, cooldown_seconds, created_at, updated_at`
+
+// Get a rule by ID. Returns sql.ErrNoRows if there is no such rule.
+func (store *MessageStore) GetAutoReplyRule(id string) (AutoReplyRule, error) {
+	var rule AutoReplyRule
+	row := store.db.QueryRow(`SELECT `+autoReplyRuleColumns+` FROM auto_reply_rules WHERE id = ?`, id)
+	err := scanAutoReplyRule(row, &rule)
+	return rule, err
+}
+
+// List all rules in the order they are evaluated
+func (store *MessageStore) ListAutoReplyRules() ([]AutoReplyRule, error) {
+	rows, err := store.db.Query(`SELECT ` + autoReplyRuleColumns + ` FROM auto_reply_rules ORDER BY priority, created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []AutoReplyRule{}
+	for rows.Next() {
+		var rule AutoReplyRule
+		if err := scanAutoReplyRule(rows, &rule); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// AutoReplyEngine evaluates the stored rules against incoming messages
+type AutoReplyEngine struct {
+	client       *whatsmeow.Client
+	messageStore *MessageStore
+	eventSink    EventSink
+	httpClient   *http.Client
+
+	mu    sync.RWMutex
+	rules []*compiledRule
+
+	cooldownMu sync.Mutex
+	lastFired  map[string]time.Time // rule ID and chat JID
+}
+
+func newAutoReplyEngine(client *whatsmeow.Client, messageStore *MessageStore, eventSink EventSink) *AutoReplyEngine {
+	return &AutoReplyEngine{
+		client:       client,
+		messageStore: messageStore,
+		eventSink:    eventSink,
+		httpClient:   newPublicOnlyClient(webhookTimeout), // rules may not reach internal hosts
+		lastFired:    make(map[string]time.Time),
+	}
+}
+
+// Load the rules from the database, replacing the ones in use. Rules that no
+// longer compile, e.g. because their template was deleted, are skipped.
+func (e *AutoReplyEngine) Reload() error {
+	stored, err := e.messageStore.ListAutoReplyRules()
+	if err != nil {
+		return err
+	}
+
+	rules := make([]*compiledRule, 0, len(stored))
+	for i := range stored {
+		if stored[i].Disabled {
+			continue
+		}
+		rule, err := compileRule(&stored[i], e.messageStore)
+		if err != nil {
+			logger.Error(fmt.Sprintf("⚠️ Skipping auto-reply rule %s: %v", stored[i].ID, err))
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Priority < rules[j].Priority })
+
+	e.mu.Lock()
+	e.rules = rules
+	e.mu.Unlock()
+	logger.Info(fmt.Sprintf("✅ Loaded %d auto-reply rules", len(rules)))
+	return nil
+}
+
+// Start the cooldown of a rule in a chat. Returns false if the rule already
+// acted there within its cooldown.
+func (e *AutoReplyEngine) claimCooldown(rule *compiledRule, chatJID string, now time.Time) bool {
+	e.cooldownMu.Lock()
+	defer e.cooldownMu.Unlock()
+
+	key := rule.ID + "|" + chatJID
+	if last, ok := e.lastFired[key]; ok && now.Sub(last) < rule.cooldown {
+		return false
+	}
+	e.lastFired[key] = now
+
+	// Forget cooldowns that have long expired
+	if len(e.lastFired) > 10000 {
+		for k, t := range e.lastFired {
+			if now.Sub(t) > 24*time.Hour {
+				delete(e.lastFired, k)
+			}
+		}
+	}
+	return true
+}
+
+// Build what rules see of an incoming message. Returns nil for messages that
+// are never answered: our own, old ones and those without content.
+func newIncomingMessage(msg *events.Message) *IncomingMessage {
+	if msg.Info.IsFromMe || time.Since(msg.Info.Timestamp) > autoReplyMaxAge {
+		return nil
+	}
+	content, media := extractMessageContent(msg.Message)
+	if content == "" && media.MediaType == "" {
+		return nil
+	}
+	msgType := media.MediaType
+	if msgType == "" {
+		msgType = "text"
+	}
+
+	in := &IncomingMessage{
+		ID:         msg.Info.ID,
+		ChatJID:    msg.Info.Chat.String(),
+		Sender:     msg.Info.Sender.User,
+		SenderName: msg.Info.PushName,
+		Type:       msgType,
+		Content:    content,
+		Time:       msg.Info.Timestamp,
+		raw:        msg.Message,
+		senderJIDs: []string{msg.Info.Sender.ToNonAD().String()},
+	}
+	if !msg.Info.SenderAlt.IsEmpty() {
+		in.senderJIDs = append(in.senderJIDs, msg.Info.SenderAlt.ToNonAD().String())
+	}
+	return in
+}
+
+// Evaluate the rules against an incoming message and run the actions of
+// those that match
+func (e *AutoReplyEngine) HandleMessage(msg *events.Message) {
+	// A faulty rule must not take the bridge down
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error(fmt.Sprintf("❌ Auto-reply panicked on message %s: %v", msg.Info.ID, r))
+		}
+	}()
+	in := newIncomingMessage(msg)
+	if in == nil {
+		return
+	}
+
+	e.mu.RLock()
+	rules := e.rules
+	e.mu.RUnlock()
+
+	now := time.Now()
+	for _, rule := range rules {
+		if !rule.matches(in, now) {
+			continue
+		}
+		if e.claimCooldown(rule, in.ChatJID, now) {
+			logger.Info(fmt.Sprintf("🤖 Auto-reply rule %q matched message %s", rule.Name, in.ID))
+			for _, action := range rule.Actions {
+				if err := e.runAction(rule, action, in); err != nil {
+					logger.Error(fmt.Sprintf("❌ Auto-reply rule %q %s action failed: %v", rule.Name, action.Type, err))
+				}
+			}
+		}
+		if rule.Stop {
+			return
+		}
+	}
+}
+
+// Variables available to reply texts and templates
+func (in *IncomingMessage) variables(extra map[string]interface{}) map[string]interface{} {
+	vars := map[string]interface{}{
+		"sender":      in.Sender,
+		"sender_name": in.SenderName,
+		"chat":        in.ChatJID,
+		"message":     in.Content,
+		"type":        in.Type,
+	}
+	for k, v := range extra {
+		vars[k] = v
+	}
+	return vars
+}
+
+// Run one action of a matched rule
+func (e *AutoReplyEngine) runAction(rule *compiledRule, action RuleAction, in *IncomingMessage) error {
+	switch action.Type {
+	case "reply", "template":
+		t := Template{Name: "reply", Body: action.Text}
+		if action.Type == "template" {
+			var err error
+			if t, err = e.messageStore.GetTemplate(action.Template); err != nil {
+				return fmt.Errorf("failed to load template %s: %v", action.Template, err)
+			}
+		}
+		message, err := t.Render(in.variables(action.Variables))
+		if err != nil {
+			return err
+		}
+
+		var contextInfo *waE2E.ContextInfo
+		if action.Quote {
+			if contextInfo, err = buildReplyContext(e.client, e.messageStore, in.ChatJID, in.ID, false); err != nil {
+				return err
+			}
+		}
+		queueMsg := WALogMessageForQueue{AutoSent: true, AutoReason: "auto_reply"}
+		if action.Type == "template" {
+			queueMsg.Template = t.Name
+			queueMsg.TemplateVariables = in.variables(action.Variables)
+		}
+		success, msg, _ := sendTextMessage(e.client, e.messageStore, e.eventSink,
+			SendMessageRequest{Recipient: in.ChatJID, Message: message}, contextInfo, queueMsg)
+		if !success {
+			return fmt.Errorf("%s", msg)
+		}
+		return nil
+
+	case "react":
+		chatJID, err := types.ParseJID(in.ChatJID)
+		if err != nil {
+			return err
+		}
+		senderJID, err := types.ParseJID(in.senderJIDs[0])
+		if err != nil {
+			return err
+		}
+		reaction := e.client.BuildReaction(chatJID, senderJID, types.MessageID(in.ID), action.Emoji)
+		resp, err := e.client.SendMessage(context.Background(), chatJID, reaction)
+		if err != nil {
+			return err
+		}
+		if err := e.messageStore.StoreReaction(in.ID, in.ChatJID, e.client.Store.ID.User, action.Emoji, resp.Timestamp); err != nil {
+			logger.Error("Failed to store reaction:", err)
+		}
+		return publishEvent(WALogMessageForQueue{
+			Type:            "reaction",
+			From:            e.client.Store.ID.User,
+			To:              in.ChatJID,
+			Message:         action.Emoji,
+			Time:            resp.Timestamp,
+			MessageID:       resp.ID,
+			ParentMessageID: in.ID,
+			Status:          "SENT",
+			AutoSent:        true,
+			AutoReason:      "auto_reply",
+		}, e.eventSink)
+
+	case "forward":
+		return e.forward(action.To, in)
+
+	case "webhook":
+		body, err := json.Marshal(struct {
+			Rule string `json:"rule"`
+			*IncomingMessage
+		}{rule.Name, in})
+		if err != nil {
+			return err
+		}
+		resp, err := e.httpClient.Post(action.URL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("webhook returned %s", resp.Status)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown action type %q", action.Type)
+}
+
+// Forward the incoming message to another chat. Media is forwarded by
+// reference, so nothing is downloaded or uploaded again.
+func (e *AutoReplyEngine) forward(to string, in *IncomingMessage) error {
+	targetJID, err := parseChatJID(to)
+	if err != nil {
+		return err
+	}
+
+	fwd := proto.Clone(in.raw).(*waE2E.Message)
+	if fwd.Conversation != nil {
+		fwd.ExtendedTextMessage = &waE2E.ExtendedTextMessage{Text: fwd.Conversation}
+		fwd.Conversation = nil
+	}
+	forwarded := &waE2E.ContextInfo{IsForwarded: proto.Bool(true), ForwardingScore: proto.Uint32(1)}
+	switch {
+	case fwd.ExtendedTextMessage != nil:
+		fwd.ExtendedTextMessage.ContextInfo = forwarded
+	case fwd.ImageMessage != nil:
+		fwd.ImageMessage.ContextInfo = forwarded
+	case fwd.VideoMessage != nil:
+		fwd.VideoMessage.ContextInfo = forwarded
+	case fwd.AudioMessage != nil:
+		fwd.AudioMessage.ContextInfo = forwarded
+	case fwd.DocumentMessage != nil:
+		fwd.DocumentMessage.ContextInfo = forwarded
+	case fwd.StickerMessage != nil:
+		fwd.StickerMessage.ContextInfo = forwarded
+	case fwd.LocationMessage != nil:
+		fwd.LocationMessage.ContextInfo = forwarded
+	case fwd.ContactMessage != nil:
+		fwd.ContactMessage.ContextInfo = forwarded
+	default:
+		return fmt.Errorf("%s messages cannot be forwarded", in.Type)
+	}
+
+	resp, err := e.client.SendMessage(context.Background(), targetJID, fwd)
+	if err != nil {
+		return err
+	}
+
+	senderPhone := e.client.Store.ID.User
+	content, media := extractMessageContent(fwd)
+	sentMsg := Message{
+		ID:        resp.ID,
+		Sender:    senderPhone,
+		Content:   content,
+		Time:      resp.Timestamp,
+		MediaInfo: media,
+	}
+	event := WALogMessageForQueue{
+		Type:       in.Type,
+		From:       senderPhone,
+		To:         targetJID.String(),
+		Message:    content,
+		Time:       resp.Timestamp,
+		MessageID:  resp.ID,
+		Status:     "SENT",
+		AutoSent:   true,
+		AutoReason: "auto_reply",
+	}
+
+	// Media is logged with its file, like media we receive
+	var ext string
+	switch {
+	case in.raw.ImageMessage != nil:
+		ext = ".jpg"
+	case in.raw.VideoMessage != nil:
+		ext = ".mp4"
+	case in.raw.AudioMessage != nil:
+		ext = ".mp3"
+	case in.raw.DocumentMessage != nil:
+		ext = ".pdf"
+	case in.raw.StickerMessage != nil:
+		ext = ".webp"
+	default:
+		return logSentMessage(e.messageStore, e.eventSink, targetJID.String(), sentMsg, nil, event)
+	}
+	key := fmt.Sprintf("whatsapp_failed_files/%s_%d%s", in.Type, time.Now().UnixNano(), ext)
+	upload, err := downloadReceivedMedia(e.client, in.raw, key, "")
+	if err != nil {
+		// The forward went out, so it is still logged, just without its file
+		if logErr := logSentMessage(e.messageStore, e.eventSink, targetJID.String(), sentMsg, nil, event); logErr != nil {
+			logger.Error("Failed to log forwarded message:", logErr)
+		}
+		return fmt.Errorf("failed to download forwarded media: %v", err)
+	}
+	return logSentMessage(e.messageStore, e.eventSink, targetJID.String(), sentMsg, &upload, event)
+}
+
+// Write a rule lookup error as JSON
+func writeRuleError(w http.ResponseWriter, err error) {
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Rule not found"})
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to access rule: %v", err)})
+}
+
+// Reload the engine after a rule changed
+func (e *AutoReplyEngine) reloadAfterChange() {
+	if err := e.Reload(); err != nil {
+		logger.Error("❌ Failed to reload auto-reply rules:", err)
+	}
+}
+
+// Handler for creating and listing auto-reply rules
+func autoRepliesHandler(messageStore *MessageStore, engine *AutoReplyEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			rules, err := messageStore.ListAutoReplyRules()
+			if err != nil {
+				writeRuleError(w, err)
+				return
+			}
+			json.NewEncoder(w).Encode(rules)
+
+		case http.MethodPost:
+			var rule AutoReplyRule
+			if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+				http.Error(w, "Error parsing request body", http.StatusBadRequest)
+				return
+			}
+			if _, err := compileRule(&rule, messageStore); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var err error
+			if rule.ID, err = newJobID(); err != nil {
+				http.Error(w, "Error creating rule", http.StatusInternalServerError)
+				return
+			}
+			rule.CreatedAt = time.Now()
+			rule.UpdatedAt = rule.CreatedAt
+
+			w.Header().Set("Content-Type", "application/json")
+			if err := messageStore.CreateAutoReplyRule(rule); err != nil {
+				writeRuleError(w, err)
+				return
+			}
+			engine.reloadAfterChange()
+
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(rule)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// Handler for reading, replacing and deleting one auto-reply rule
+func autoReplyHandler(messageStore *MessageStore, engine *AutoReplyEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			rule, err := messageStore.GetAutoReplyRule(id)
+			if err != nil {
+				writeRuleError(w, err)
+				return
+			}
+			json.NewEncoder(w).Encode(rule)
+
+		case http.MethodPut:
+			var rule AutoReplyRule
+			if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+				http.Error(w, "Error parsing request body", http.StatusBadRequest)
+				return
+			}
+			rule.ID = id
+			if _, err := compileRule(&rule, messageStore); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			rule.UpdatedAt = time.Now()
+
+			w.Header().Set("Content-Type", "application/json")
+			if err := messageStore.UpdateAutoReplyRule(rule); err != nil {
+				writeRuleError(w, err)
+				return
+			}
+			engine.reloadAfterChange()
+
+			rule, err := messageStore.GetAutoReplyRule(id)
+			if err != nil {
+				writeRuleError(w, err)
+				return
+			}
+			json.NewEncoder(w).Encode(rule)
+
+		case http.MethodDelete:
+			w.Header().Set("Content-Type", "application/json")
+			if err := messageStore.DeleteAutoReplyRule(id); err != nil {
+				writeRuleError(w, err)
+				return
+			}
+			engine.reloadAfterChange()
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: true, Message: "Rule deleted"})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
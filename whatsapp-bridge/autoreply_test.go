@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompileRuleWebhookURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"https://example.com/hook", false},
+		{"http://example.com:8080/hook?x=1", false},
+		{"ftp://example.com/hook", true},
+		{"file:///etc/passwd", true},
+		{"http://", true},
+		{"example.com/hook", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		rule := &AutoReplyRule{Name: "hook", Actions: []RuleAction{{Type: "webhook", URL: tt.url}}}
+		_, err := compileRule(rule, nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("compileRule with url %q: error = %v, want error %v", tt.url, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWebhookRefusesNonPublicAddress(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	engine := newAutoReplyEngine(nil, nil, nil)
+	rule, err := compileRule(&AutoReplyRule{Name: "hook", Actions: []RuleAction{{Type: "webhook", URL: server.URL}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.runAction(rule, rule.Actions[0], &IncomingMessage{ID: "1", Content: "secret"})
+	if err == nil || !strings.Contains(err.Error(), "non-public address") {
+		t.Errorf("webhook to %s: error = %v, want a non-public address error", server.URL, err)
+	}
+	if called {
+		t.Error("webhook reached the loopback server")
+	}
+}
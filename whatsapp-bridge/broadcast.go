@@ -0,0 +1,490 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	mathrand "math/rand/v2"
+	"net/http"
+	"time"
+
+	"go.mau.fi/libsignal/logger"
+	"go.mau.fi/whatsmeow"
+)
+
+const (
+	defaultBroadcastRate   = 20.0 // messages per minute
+	defaultBroadcastJitter = 5.0  // seconds
+	minBroadcastRate       = 0.1  // one message every ten minutes
+	maxBroadcastJitter     = 3600.0
+)
+
+// CreateBroadcastRequest represents the request body for the create broadcast API
+type CreateBroadcastRequest struct {
+	Recipients    []string `json:"recipients"`
+	Message       string   `json:"message"`
+	MediaType     string   `json:"media_type"` // empty for text, otherwise "image", "document", "audio", "video" or "sticker"
+	MediaURL      string   `json:"media_url"`
+	MediaBase64   string   `json:"media_base64"`
+	Filename      string   `json:"filename"`
+	Mimetype      string   `json:"mimetype"`
+	AdminPhone    string   `json:"admin_phone"`
+	RatePerMinute *float64 `json:"rate_per_minute"` // defaults when absent; at least 0.1
+	JitterSeconds *float64 `json:"jitter_seconds"`  // defaults when absent; 0 sends at an even pace, at most an hour
+}
+
+// CreateBroadcastResponse is returned once a broadcast has been queued
+type CreateBroadcastResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	ID      string `json:"id"`
+}
+
+// BroadcastRecipient is the progress of a broadcast for one recipient
+type BroadcastRecipient struct {
+	Recipient      string     `json:"recipient"`
+	ChatJID        string     `json:"chat_jid"`
+	Status         string     `json:"status"` // "pending", "sending", "sent", "failed"
+	MessageID      string     `json:"message_id,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	SentAt         *time.Time `json:"sent_at,omitempty"`
+	DeliveryStatus string     `json:"delivery_status,omitempty"` // from receipts, as on stored messages
+}
+
+// Broadcast is one message sent to many recipients in the background
+type Broadcast struct {
+	ID     string `json:"id"`
+	Status string `json:"status"` // "queued", "running", "completed"
+	OutgoingMessage
+	RatePerMinute float64              `json:"rate_per_minute"`
+	JitterSeconds float64              `json:"jitter_seconds"`
+	CreatedAt     time.Time            `json:"created_at"`
+	CompletedAt   *time.Time           `json:"completed_at,omitempty"`
+	Total         int                  `json:"total"`
+	Sent          int                  `json:"sent"`
+	Failed        int                  `json:"failed"`
+	Pending       int                  `json:"pending"`
+	Recipients    []BroadcastRecipient `json:"recipients,omitempty"`
+}
+
+// Generate a random ID for a background job
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Store a new broadcast and its recipients
+func (store *MessageStore) CreateBroadcast(b Broadcast) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`INSERT INTO broadcasts (id, status, message, media_type, mimetype, filename, media, admin_phone,
+			rate_per_minute, jitter_seconds, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		b.ID, b.Status, b.Message, b.MediaType, b.Mimetype, b.Filename, b.Media, b.AdminPhone,
+		b.RatePerMinute, b.JitterSeconds, b.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	for i, r := range b.Recipients {
+		_, err = tx.Exec(
+			`INSERT INTO broadcast_recipients (broadcast_id, chat_jid, recipient, position, status, message_id, error)
+			VALUES (?, ?, ?, ?, 'pending', '', '')`,
+			b.ID, r.ChatJID, r.Recipient, i,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Get a broadcast with per-recipient results. Returns sql.ErrNoRows if there
+// is no such broadcast.
+func (store *MessageStore) GetBroadcast(id string) (Broadcast, error) {
+	var b Broadcast
+	var completedAt sql.NullTime
+	err := store.db.QueryRow(
+		`SELECT id, status, message, media_type, mimetype, filename, admin_phone, rate_per_minute, jitter_seconds,
+			created_at, completed_at
+		FROM broadcasts WHERE id = ?`,
+		id,
+	).Scan(&b.ID, &b.Status, &b.Message, &b.MediaType, &b.Mimetype, &b.Filename, &b.AdminPhone,
+		&b.RatePerMinute, &b.JitterSeconds, &b.CreatedAt, &completedAt)
+	if err != nil {
+		return Broadcast{}, err
+	}
+	if completedAt.Valid {
+		b.CompletedAt = &completedAt.Time
+	}
+
+	rows, err := store.db.Query(
+		`SELECT r.recipient, r.chat_jid, r.status, r.message_id, r.error, r.sent_at, COALESCE(m.delivery_status, '')
+		FROM broadcast_recipients r
+		LEFT JOIN messages m ON m.id = r.message_id AND m.chat_jid = r.chat_jid
+		WHERE r.broadcast_id = ?
+		ORDER BY r.position`,
+		id,
+	)
+	if err != nil {
+		return Broadcast{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r BroadcastRecipient
+		var sentAt sql.NullTime
+		if err := rows.Scan(&r.Recipient, &r.ChatJID, &r.Status, &r.MessageID, &r.Error, &sentAt, &r.DeliveryStatus); err != nil {
+			return Broadcast{}, err
+		}
+		if sentAt.Valid {
+			r.SentAt = &sentAt.Time
+		}
+		switch r.Status {
+		case "sent":
+			b.Sent++
+		case "failed":
+			b.Failed++
+		default:
+			b.Pending++
+		}
+		b.Recipients = append(b.Recipients, r)
+	}
+	b.Total = len(b.Recipients)
+	return b, rows.Err()
+}
+
+// Get the next recipient to send to, oldest broadcast first. Returns
+// sql.ErrNoRows when every broadcast is complete.
+func (store *MessageStore) nextBroadcastRecipient() (Broadcast, BroadcastRecipient, error) {
+	var b Broadcast
+	var r BroadcastRecipient
+	err := store.db.QueryRow(
+		`SELECT b.id, b.message, b.media_type, b.mimetype, b.filename, b.media, b.admin_phone,
+			b.rate_per_minute, b.jitter_seconds, r.recipient, r.chat_jid
+		FROM broadcast_recipients r
+		JOIN broadcasts b ON b.id = r.broadcast_id
+		WHERE r.status = 'pending' AND b.status != 'completed'
+		ORDER BY b.created_at, r.position
+		LIMIT 1`,
+	).Scan(&b.ID, &b.Message, &b.MediaType, &b.Mimetype, &b.Filename, &b.Media, &b.AdminPhone,
+		&b.RatePerMinute, &b.JitterSeconds, &r.Recipient, &r.ChatJID)
+	return b, r, err
+}
+
+// Mark a recipient as being sent to, so a restart in the middle of a send
+// does not message them twice
+func (store *MessageStore) markBroadcastSending(id, chatJID string) error {
+	_, err := store.db.Exec(
+		`UPDATE broadcast_recipients SET status = 'sending' WHERE broadcast_id = ? AND chat_jid = ?`,
+		id, chatJID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(`UPDATE broadcasts SET status = 'running' WHERE id = ? AND status = 'queued'`, id)
+	return err
+}
+
+// Record the outcome for a recipient and complete the broadcast once nobody
+// is left
+func (store *MessageStore) finishBroadcastRecipient(id, chatJID, messageID string, sendErr error) error {
+	now := time.Now()
+	status, errMsg, sentAt := "sent", "", &now
+	if sendErr != nil {
+		status, errMsg, sentAt = "failed", sendErr.Error(), nil
+	}
+	_, err := store.db.Exec(
+		`UPDATE broadcast_recipients SET status = ?, message_id = ?, error = ?, sent_at = ?
+		WHERE broadcast_id = ? AND chat_jid = ?`,
+		status, messageID, errMsg, sentAt, id, chatJID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(
+		`UPDATE broadcasts SET status = 'completed', completed_at = ?
+		WHERE id = ? AND NOT EXISTS (
+			SELECT 1 FROM broadcast_recipients WHERE broadcast_id = ? AND status IN ('pending', 'sending')
+		)`,
+		now, id, id,
+	)
+	return err
+}
+
+// Fail sends that were in flight when the bridge stopped. Whether they went
+// out is unknown, and sending again could message someone twice.
+func (store *MessageStore) recoverBroadcasts() error {
+	rows, err := store.db.Query(`SELECT broadcast_id, chat_jid FROM broadcast_recipients WHERE status = 'sending'`)
+	if err != nil {
+		return err
+	}
+	var interrupted [][2]string
+	for rows.Next() {
+		var id, chatJID string
+		if err := rows.Scan(&id, &chatJID); err != nil {
+			rows.Close()
+			return err
+		}
+		interrupted = append(interrupted, [2]string{id, chatJID})
+	}
+	rows.Close()
+
+	for _, r := range interrupted {
+		err := store.finishBroadcastRecipient(r[0], r[1], "", fmt.Errorf("interrupted by a restart, delivery unknown"))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BroadcastWorker sends queued broadcasts one recipient at a time
+type BroadcastWorker struct {
+	client       *whatsmeow.Client
+	messageStore *MessageStore
+	eventSink    EventSink
+	wake         chan struct{}
+	stop         chan struct{}
+	done         chan struct{}
+	media        *sharedMedia // of the broadcast being sent
+	mediaID      string       // ID of that broadcast
+}
+
+func newBroadcastWorker(client *whatsmeow.Client, messageStore *MessageStore, eventSink EventSink) *BroadcastWorker {
+	return &BroadcastWorker{
+		client:       client,
+		messageStore: messageStore,
+		eventSink:    eventSink,
+		wake:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+// Wake the worker after a broadcast is created
+func (bw *BroadcastWorker) Notify() {
+	select {
+	case bw.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop the worker once the send in progress, if any, has finished
+func (bw *BroadcastWorker) Stop() {
+	close(bw.stop)
+	<-bw.done
+}
+
+// Wait for d unless the worker is stopped first. Reports whether to go on.
+func (bw *BroadcastWorker) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-bw.stop:
+		return false
+	}
+}
+
+// Send broadcasts until stopped, picking up where the last run left off
+func (bw *BroadcastWorker) Run() {
+	defer close(bw.done)
+	if err := bw.messageStore.recoverBroadcasts(); err != nil {
+		logger.Error("❌ Failed to recover broadcasts:", err)
+	}
+
+	for {
+		b, r, err := bw.messageStore.nextBroadcastRecipient()
+		if err == sql.ErrNoRows {
+			select {
+			case <-bw.wake:
+				continue
+			case <-bw.stop:
+				return
+			}
+		} else if err != nil {
+			logger.Error("❌ Failed to load broadcast:", err)
+			if !bw.sleep(30 * time.Second) {
+				return
+			}
+			continue
+		}
+
+		// Wait for the connection rather than failing everyone while it is down
+		if !bw.client.IsConnected() {
+			if !bw.sleep(5 * time.Second) {
+				return
+			}
+			continue
+		}
+
+		if err := bw.messageStore.markBroadcastSending(b.ID, r.ChatJID); err != nil {
+			logger.Error("❌ Failed to update broadcast:", err)
+			if !bw.sleep(30 * time.Second) {
+				return
+			}
+			continue
+		}
+
+		// Recipients are sent to one broadcast after the other, so only the
+		// media of the current one is kept
+		if b.MediaType != "" && bw.mediaID != b.ID {
+			bw.media = newSharedMedia(fmt.Sprintf("whatsapp_failed_files/broadcast_%s%s", b.ID, mediaExtension(b.MediaType, b.Mimetype)))
+			bw.mediaID = b.ID
+		}
+		msgID, sendErr := sendOutgoingMessage(bw.client, bw.messageStore, bw.eventSink, r.Recipient, b.OutgoingMessage, bw.media)
+		if sendErr != nil {
+			logger.Error("⚠️ Broadcast", b.ID, "to", r.Recipient, "failed:", sendErr)
+		}
+		if err := bw.messageStore.finishBroadcastRecipient(b.ID, r.ChatJID, msgID, sendErr); err != nil {
+			logger.Error("❌ Failed to record broadcast result:", err)
+		}
+
+		if !bw.sleep(broadcastDelay(b.RatePerMinute, b.JitterSeconds)) {
+			return
+		}
+	}
+}
+
+// Pause between two sends of a broadcast: the rate interval plus a random jitter
+func broadcastDelay(ratePerMinute, jitterSeconds float64) time.Duration {
+	delay := time.Duration(float64(time.Minute) / ratePerMinute)
+	if jitterSeconds > 0 {
+		delay += time.Duration(mathrand.Float64() * jitterSeconds * float64(time.Second))
+	}
+	return delay
+}
+
+// Handler for queueing a broadcast
+func createBroadcastHandler(messageStore *MessageStore, worker *BroadcastWorker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req CreateBroadcastRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Error parsing request body", http.StatusBadRequest)
+			return
+		}
+
+		if len(req.Recipients) == 0 {
+			http.Error(w, "At least one recipient is required", http.StatusBadRequest)
+			return
+		}
+		rate, jitter := defaultBroadcastRate, defaultBroadcastJitter
+		if req.RatePerMinute != nil {
+			rate = *req.RatePerMinute
+		}
+		if req.JitterSeconds != nil {
+			jitter = *req.JitterSeconds
+		}
+		// Outside these bounds the delay overflows a time.Duration
+		if !(rate >= minBroadcastRate) {
+			http.Error(w, fmt.Sprintf("rate_per_minute must be at least %g", minBroadcastRate), http.StatusBadRequest)
+			return
+		}
+		if !(jitter >= 0 && jitter <= maxBroadcastJitter) {
+			http.Error(w, fmt.Sprintf("jitter_seconds must be between 0 and %g", maxBroadcastJitter), http.StatusBadRequest)
+			return
+		}
+
+		out := OutgoingMessage{
+			Message:    req.Message,
+			MediaType:  req.MediaType,
+			Mimetype:   req.Mimetype,
+			Filename:   req.Filename,
+			AdminPhone: req.AdminPhone,
+		}
+		if err := out.prepare(r.Context(), req.MediaURL, req.MediaBase64); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Duplicate recipients only get the message once
+		seen := make(map[string]bool)
+		var recipients []BroadcastRecipient
+		for _, recipient := range req.Recipients {
+			jid, err := parseChatJID(recipient)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid recipient %s: %v", recipient, err), http.StatusBadRequest)
+				return
+			}
+			if seen[jid.String()] {
+				continue
+			}
+			seen[jid.String()] = true
+			recipients = append(recipients, BroadcastRecipient{Recipient: recipient, ChatJID: jid.String()})
+		}
+
+		id, err := newJobID()
+		if err != nil {
+			http.Error(w, "Error creating broadcast", http.StatusInternalServerError)
+			return
+		}
+
+		err = messageStore.CreateBroadcast(Broadcast{
+			ID:              id,
+			Status:          "queued",
+			OutgoingMessage: out,
+			RatePerMinute:   rate,
+			JitterSeconds:   jitter,
+			CreatedAt:       time.Now(),
+			Recipients:      recipients,
+		})
+		if err != nil {
+			logger.Error("Failed to store broadcast:", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(CreateBroadcastResponse{Success: false, Message: fmt.Sprintf("Failed to store broadcast: %v", err)})
+			return
+		}
+		worker.Notify()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(CreateBroadcastResponse{
+			Success: true,
+			Message: fmt.Sprintf("Broadcast queued for %d recipients", len(recipients)),
+			ID:      id,
+		})
+	}
+}
+
+// Handler for reporting the progress of a broadcast
+func getBroadcastHandler(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Method not allowed"})
+			return
+		}
+
+		b, err := messageStore.GetBroadcast(r.PathValue("id"))
+		if err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Broadcast not found"})
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to get broadcast: %v", err)})
+			return
+		}
+
+		json.NewEncoder(w).Encode(b)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateBroadcastRateAndJitter(t *testing.T) {
+	store := newTestStore(t)
+	handler := createBroadcastHandler(store, newBroadcastWorker(nil, store, newMemorySink(0)))
+
+	tests := []struct {
+		fields     string
+		wantStatus int
+		wantRate   float64
+		wantJitter float64
+	}{
+		{``, http.StatusAccepted, defaultBroadcastRate, defaultBroadcastJitter},
+		{`, "jitter_seconds": 0`, http.StatusAccepted, defaultBroadcastRate, 0},
+		{`, "rate_per_minute": 6, "jitter_seconds": 1.5`, http.StatusAccepted, 6, 1.5},
+		{`, "rate_per_minute": 0`, http.StatusBadRequest, 0, 0},
+		{`, "jitter_seconds": -1`, http.StatusBadRequest, 0, 0},
+		{`, "rate_per_minute": 1e-12`, http.StatusBadRequest, 0, 0},
+		{`, "rate_per_minute": 0.1, "jitter_seconds": 3600`, http.StatusAccepted, 0.1, 3600},
+		{`, "jitter_seconds": 1e300`, http.StatusBadRequest, 0, 0},
+	}
+	for _, tt := range tests {
+		body := `{"recipients": ["15550100"], "message": "hi"` + tt.fields + `}`
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/api/broadcasts", strings.NewReader(body)))
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d: %s", tt.fields, w.Code, tt.wantStatus, w.Body)
+			continue
+		}
+		if w.Code != http.StatusAccepted {
+			continue
+		}
+
+		var resp CreateBroadcastResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		b, err := store.GetBroadcast(resp.ID)
+		if err != nil {
+			t.Fatalf("%s: GetBroadcast: %v", tt.fields, err)
+		}
+		if b.RatePerMinute != tt.wantRate || b.JitterSeconds != tt.wantJitter {
+			t.Errorf("%s: stored rate %v and jitter %v, want %v and %v", tt.fields, b.RatePerMinute, b.JitterSeconds, tt.wantRate, tt.wantJitter)
+		}
+	}
+}
+
+func TestBroadcastWorkerStop(t *testing.T) {
+	store := newTestStore(t)
+	bw := newBroadcastWorker(nil, store, newMemorySink(0))
+	go bw.Run()
+
+	stopped := make(chan struct{})
+	go func() {
+		bw.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return while the worker was idle")
+	}
+
+	// A stopped worker does not wait out the delay between sends
+	start := time.Now()
+	if bw.sleep(time.Hour) {
+		t.Error("sleep reported true after Stop")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("sleep took %v after Stop", elapsed)
+	}
+}
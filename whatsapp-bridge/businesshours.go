@@ -0,0 +1,325 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/libsignal/logger"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// OpeningHours is one opening interval of a day
+type OpeningHours struct {
+	Start string `json:"start"` // "09:00"
+	End   string `json:"end"`   // "17:30", or "24:00" for midnight
+}
+
+// BusinessHours is the account's opening calendar. Outside it, a chat that
+// messages us gets the away message once per closed period.
+type BusinessHours struct {
+	Enabled     bool                      `json:"enabled"`
+	Timezone    string                    `json:"timezone"`           // IANA name, defaults to UTC
+	Weekly      map[string][]OpeningHours `json:"weekly"`             // "mon" to "sun"; days not listed are closed
+	Holidays    []string                  `json:"holidays,omitempty"` // "2026-12-25", closed all day
+	AwayMessage string                    `json:"away_message"`       // template, may use the auto-reply variables
+	UpdatedAt   time.Time                 `json:"updated_at"`
+}
+
+// BusinessHoursResponse is the business hours with whether they are open now
+type BusinessHoursResponse struct {
+	BusinessHours
+	OpenNow *bool `json:"open_now,omitempty"`
+}
+
+// businessCalendar is a parsed BusinessHours
+type businessCalendar struct {
+	loc      *time.Location
+	weekly   [7][][2]int // opening intervals per weekday, in minutes after midnight
+	holidays map[string]bool
+	away     Template
+}
+
+// Check the calendar and parse it
+func (b *BusinessHours) compile() (*businessCalendar, error) {
+	tz := b.Timezone
+	if tz == "" {
+		tz = "UTC"
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone: %v", err)
+	}
+
+	c := &businessCalendar{loc: loc, holidays: make(map[string]bool)}
+	for day, intervals := range b.Weekly {
+		weekday, ok := weekdayNames[strings.ToLower(day)]
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q", day)
+		}
+		for _, interval := range intervals {
+			start, err := parseClock(interval.Start)
+			if err != nil {
+				return nil, err
+			}
+			end, err := parseClock(interval.End)
+			if err != nil {
+				return nil, err
+			}
+			if start >= end {
+				return nil, fmt.Errorf("opening hours on %s must end after they start", day)
+			}
+			c.weekly[weekday] = append(c.weekly[weekday], [2]int{start, end})
+		}
+	}
+	for _, holiday := range b.Holidays {
+		date, err := time.Parse("2006-01-02", holiday)
+		if err != nil {
+			return nil, fmt.Errorf("invalid holiday %q, expected YYYY-MM-DD", holiday)
+		}
+		c.holidays[date.Format("2006-01-02")] = true
+	}
+
+	if b.Enabled && strings.TrimSpace(b.AwayMessage) == "" {
+		return nil, fmt.Errorf("away_message is required")
+	}
+	if b.AwayMessage != "" {
+		c.away = Template{Name: "away_message", Body: b.AwayMessage}
+		if err := c.away.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Report whether the business is open at t
+func (c *businessCalendar) isOpen(t time.Time) bool {
+	local := t.In(c.loc)
+	if c.holidays[local.Format("2006-01-02")] {
+		return false
+	}
+	minute := local.Hour()*60 + local.Minute()
+	for _, interval := range c.weekly[local.Weekday()] {
+		if minute >= interval[0] && minute < interval[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Find when the closed period containing t began: the latest closing time
+// before t. If there were no opening hours in the past year, each day counts
+// as its own period.
+func (c *businessCalendar) closedSince(t time.Time) time.Time {
+	local := t.In(c.loc)
+	for d := 0; d <= 366; d++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()-d, 0, 0, 0, 0, c.loc)
+		if c.holidays[day.Format("2006-01-02")] {
+			continue
+		}
+		var latest time.Time
+		for _, interval := range c.weekly[day.Weekday()] {
+			end := time.Date(day.Year(), day.Month(), day.Day(), 0, interval[1], 0, 0, c.loc)
+			if !end.After(t) && end.After(latest) {
+				latest = end
+			}
+		}
+		if !latest.IsZero() {
+			return latest
+		}
+	}
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, c.loc)
+}
+
+// Get the business hours. Returns sql.ErrNoRows if they were never set.
+func (store *MessageStore) GetBusinessHours() (BusinessHours, error) {
+	var b BusinessHours
+	var config string
+	err := store.db.QueryRow(`SELECT config, updated_at FROM business_hours WHERE id = 1`).Scan(&config, &b.UpdatedAt)
+	if err != nil {
+		return b, err
+	}
+	if err := json.Unmarshal([]byte(config), &b); err != nil {
+		return b, fmt.Errorf("invalid business hours: %v", err)
+	}
+	return b, nil
+}
+
+// Replace the business hours
+func (store *MessageStore) SaveBusinessHours(b BusinessHours) error {
+	config, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(
+		`INSERT INTO business_hours (id, config, updated_at) VALUES (1, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET config = excluded.config, updated_at = excluded.updated_at`,
+		string(config), b.UpdatedAt,
+	)
+	return err
+}
+
+// Record that a chat gets the away message for the closed period starting at
+// windowStart. Returns false if it already got one in that period.
+func (store *MessageStore) claimAwayReply(chatJID string, windowStart, now time.Time) (bool, error) {
+	result, err := store.db.Exec(
+		`INSERT INTO away_replies (chat_jid, window_start, sent_at) VALUES (?, ?, ?)
+		ON CONFLICT(chat_jid) DO UPDATE SET window_start = excluded.window_start, sent_at = excluded.sent_at
+		WHERE away_replies.window_start <> excluded.window_start`,
+		chatJID, windowStart.UTC(), now.UTC(),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// Forget a claimed away message that could not be sent, so the next message
+// from the chat tries again
+func (store *MessageStore) releaseAwayReply(chatJID string, windowStart time.Time) error {
+	_, err := store.db.Exec(
+		`DELETE FROM away_replies WHERE chat_jid = ? AND window_start = ?`,
+		chatJID, windowStart.UTC(),
+	)
+	return err
+}
+
+// AwayResponder answers direct chats outside business hours
+type AwayResponder struct {
+	client       *whatsmeow.Client
+	messageStore *MessageStore
+	eventSink    EventSink
+
+	mu       sync.RWMutex
+	calendar *businessCalendar // nil when disabled
+}
+
+func newAwayResponder(client *whatsmeow.Client, messageStore *MessageStore, eventSink EventSink) *AwayResponder {
+	return &AwayResponder{
+		client:       client,
+		messageStore: messageStore,
+		eventSink:    eventSink,
+	}
+}
+
+// Load the business hours from the database, replacing the ones in use
+func (a *AwayResponder) Reload() error {
+	var calendar *businessCalendar
+	b, err := a.messageStore.GetBusinessHours()
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil && b.Enabled {
+		if calendar, err = b.compile(); err != nil {
+			return err
+		}
+	}
+
+	a.mu.Lock()
+	a.calendar = calendar
+	a.mu.Unlock()
+	return nil
+}
+
+// Send the away message if the message arrived outside business hours and
+// the chat has not had one since we closed
+func (a *AwayResponder) HandleMessage(msg *events.Message) {
+	if msg.Info.Chat.Server != types.DefaultUserServer && msg.Info.Chat.Server != types.HiddenUserServer {
+		return
+	}
+	in := newIncomingMessage(msg)
+	if in == nil {
+		return
+	}
+
+	a.mu.RLock()
+	calendar := a.calendar
+	a.mu.RUnlock()
+	if calendar == nil || calendar.isOpen(in.Time) {
+		return
+	}
+
+	windowStart := calendar.closedSince(in.Time)
+	claimed, err := a.messageStore.claimAwayReply(in.ChatJID, windowStart, time.Now())
+	if err != nil {
+		logger.Error("❌ Failed to record away message:", err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	message, err := calendar.away.Render(in.variables(nil))
+	if err == nil {
+		success, msg, _ := sendTextMessage(a.client, a.messageStore, a.eventSink,
+			SendMessageRequest{Recipient: in.ChatJID, Message: message}, nil,
+			WALogMessageForQueue{AutoSent: true, AutoReason: "away_message"})
+		if !success {
+			err = fmt.Errorf("%s", msg)
+		}
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("❌ Failed to send away message to %s: %v", in.ChatJID, err))
+		if err := a.messageStore.releaseAwayReply(in.ChatJID, windowStart); err != nil {
+			logger.Error("❌ Failed to release away message:", err)
+		}
+	}
+}
+
+// Handler for reading and replacing the business hours
+func businessHoursHandler(messageStore *MessageStore, responder *AwayResponder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			b, err := messageStore.GetBusinessHours()
+			if err == sql.ErrNoRows {
+				json.NewEncoder(w).Encode(BusinessHoursResponse{BusinessHours: BusinessHours{Weekly: map[string][]OpeningHours{}}})
+				return
+			} else if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to load business hours: %v", err)})
+				return
+			}
+			resp := BusinessHoursResponse{BusinessHours: b}
+			if calendar, err := b.compile(); err == nil {
+				open := calendar.isOpen(time.Now())
+				resp.OpenNow = &open
+			}
+			json.NewEncoder(w).Encode(resp)
+
+		case http.MethodPut:
+			var b BusinessHours
+			if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+				http.Error(w, "Error parsing request body", http.StatusBadRequest)
+				return
+			}
+			if _, err := b.compile(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			b.UpdatedAt = time.Now()
+
+			w.Header().Set("Content-Type", "application/json")
+			if err := messageStore.SaveBusinessHours(b); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to save business hours: %v", err)})
+				return
+			}
+			if err := responder.Reload(); err != nil {
+				logger.Error("❌ Failed to reload business hours:", err)
+			}
+			json.NewEncoder(w).Encode(b)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
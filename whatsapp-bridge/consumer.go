@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	logfunction "whatsapp-client/log-function"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	defaultSQSWorkers           = 4
+	defaultSQSWaitTime          = 20 * time.Second // the longest SQS allows
+	defaultSQSVisibilityTimeout = time.Minute
+	sqsBatchSize                = 10 // the most messages SQS receives, changes or deletes in one call
+	sqsDeleteInterval           = time.Second
+	sqsReceiveRetryDelay        = 10 * time.Second
+)
+
+// queueClient is the part of the SQS API the consumer uses
+type queueClient interface {
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	ChangeMessageVisibilityBatch(ctx context.Context, params *sqs.ChangeMessageVisibilityBatchInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityBatchOutput, error)
+	DeleteMessageBatch(ctx context.Context, params *sqs.DeleteMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageBatchOutput, error)
+}
+
+// QueueConsumer delivers the entries queued in SQS to the log API with a pool
+// of workers. Messages stay invisible to other consumers for as long as they
+// are worked on, and finished ones are deleted in batches.
+type QueueConsumer struct {
+	client            queueClient
+	queueURL          string
+	logClient         *logfunction.LogClient
+	workers           int
+	waitTime          time.Duration
+	visibilityTimeout time.Duration
+
+	mu       sync.Mutex
+	inFlight map[string]bool // receipt handles of messages received and not finished
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Create a consumer configured by SQS_CONSUMER_WORKERS, SQS_WAIT_TIME (long
+// polling, at most 20s) and SQS_VISIBILITY_TIMEOUT
+func newQueueConsumer(client queueClient, queueURL string, logClient *logfunction.LogClient) *QueueConsumer {
+	qc := &QueueConsumer{
+		client:            client,
+		queueURL:          queueURL,
+		logClient:         logClient,
+		workers:           defaultSQSWorkers,
+		waitTime:          defaultSQSWaitTime,
+		visibilityTimeout: defaultSQSVisibilityTimeout,
+		inFlight:          make(map[string]bool),
+	}
+	if n, err := strconv.Atoi(os.Getenv("SQS_CONSUMER_WORKERS")); err == nil && n > 0 {
+		qc.workers = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("SQS_WAIT_TIME")); err == nil && d >= 0 {
+		qc.waitTime = min(d, defaultSQSWaitTime)
+	}
+	if d, err := time.ParseDuration(os.Getenv("SQS_VISIBILITY_TIMEOUT")); err == nil && d >= 2*time.Second {
+		qc.visibilityTimeout = d
+	}
+	return qc
+}
+
+// Start receiving and delivering messages in the background
+func (qc *QueueConsumer) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	qc.cancel = cancel
+	qc.done = make(chan struct{})
+
+	jobs := make(chan sqstypes.Message)
+	finished := make(chan string, sqsBatchSize)
+	var workers sync.WaitGroup
+	for i := 0; i < qc.workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for msg := range jobs {
+				if qc.process(msg) {
+					finished <- *msg.ReceiptHandle
+				}
+				qc.untrack(*msg.ReceiptHandle)
+			}
+		}()
+	}
+
+	stopExtending := make(chan struct{})
+	go qc.extendVisibility(stopExtending)
+	deleted := make(chan struct{})
+	go func() {
+		qc.deleteFinished(finished)
+		close(deleted)
+	}()
+
+	go func() {
+		qc.poll(ctx, jobs)
+		// Let the workers finish what was received, then delete what they finished
+		close(jobs)
+		workers.Wait()
+		close(finished)
+		<-deleted
+		close(stopExtending)
+		close(qc.done)
+	}()
+	fmt.Println("✅ Consuming SQS queue with", qc.workers, "workers")
+}
+
+// Stop receiving messages and wait until the ones received are finished
+func (qc *QueueConsumer) Stop() {
+	qc.cancel()
+	<-qc.done
+}
+
+// Receive messages with long polling until ctx is cancelled, handing each to
+// a worker
+func (qc *QueueConsumer) poll(ctx context.Context, jobs chan<- sqstypes.Message) {
+	for ctx.Err() == nil {
+		output, err := qc.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(qc.queueURL),
+			MaxNumberOfMessages: int32(min(qc.workers, sqsBatchSize)),
+			WaitTimeSeconds:     int32(qc.waitTime / time.Second),
+			VisibilityTimeout:   int32(qc.visibilityTimeout / time.Second),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Println("❌ Error receiving message from SQS:", err)
+			select {
+			case <-time.After(sqsReceiveRetryDelay):
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		if len(output.Messages) > 0 {
+			fmt.Println("Received", len(output.Messages), "messages from SQS queue")
+		}
+		for _, msg := range output.Messages {
+			qc.track(*msg.ReceiptHandle)
+			jobs <- msg
+		}
+	}
+}
+
+func (qc *QueueConsumer) track(receiptHandle string) {
+	qc.mu.Lock()
+	qc.inFlight[receiptHandle] = true
+	qc.mu.Unlock()
+}
+
+func (qc *QueueConsumer) untrack(receiptHandle string) {
+	qc.mu.Lock()
+	delete(qc.inFlight, receiptHandle)
+	qc.mu.Unlock()
+}
+
+// Deliver a queued entry to the log API. Returns whether the message is done
+// with and can be deleted: it was delivered, or it failed in a way that
+// receiving it again cannot fix. Messages that failed for now become visible
+// again after the visibility timeout.
+func (qc *QueueConsumer) process(msg sqstypes.Message) bool {
+	body := aws.ToString(msg.Body)
+	var message WALogMessageForQueue
+	if err := json.Unmarshal([]byte(body), &message); err != nil {
+		fmt.Println("❌ Dropping message that is not a log entry:", err, body)
+		return true
+	}
+
+	// Entries queued before statuses were tracked carry no status
+	status := message.Status
+	if status == "" {
+		status = "READ"
+	}
+
+	entry := logfunction.Entry{
+		From:            message.From,
+		To:              message.To,
+		Text:            message.Message,
+		Status:          status,
+		Time:            message.Time,
+		AdminPhone:      message.AdminPhone,
+		MessageID:       message.MessageID,
+		ParentMessageID: message.ParentMessageID,
+		FileURL:         message.File,
+		FileLength:      message.FileLength,
+	}
+	switch message.Type {
+	case "text", "location", "contact", "poll", "reaction", "edit", "revoke":
+		entry.Kind = logfunction.KindMessage
+	case "image", "sticker", "document", "audio", "video":
+		entry.Kind = logfunction.KindMedia
+		if message.File == "" {
+			fmt.Println("❌ Dropping media message without a file:", body)
+			return true
+		}
+	case "status":
+		entry.Kind = logfunction.KindStatus
+	default:
+		fmt.Println("❌ Dropping message of unknown type:", body)
+		return true
+	}
+
+	if err := qc.logClient.Log(context.Background(), entry); err != nil {
+		if !logfunction.Retryable(err) {
+			fmt.Println("❌ Dropping message the log API cannot take:", err, body)
+			return true
+		}
+		fmt.Println("❌ Error logging message, it will be received again:", err)
+		return false
+	}
+	fmt.Println("✅ Message delivered to log API:", message.MessageID)
+	return true
+}
+
+// Push back the visibility timeout of the messages in flight every half
+// timeout, so slow uploads are not received a second time
+func (qc *QueueConsumer) extendVisibility(stop <-chan struct{}) {
+	ticker := time.NewTicker(qc.visibilityTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		qc.mu.Lock()
+		handles := make([]string, 0, len(qc.inFlight))
+		for handle := range qc.inFlight {
+			handles = append(handles, handle)
+		}
+		qc.mu.Unlock()
+
+		for len(handles) > 0 {
+			n := min(len(handles), sqsBatchSize)
+			entries := make([]sqstypes.ChangeMessageVisibilityBatchRequestEntry, n)
+			for i, handle := range handles[:n] {
+				entries[i] = sqstypes.ChangeMessageVisibilityBatchRequestEntry{
+					Id:                aws.String(strconv.Itoa(i)),
+					ReceiptHandle:     aws.String(handle),
+					VisibilityTimeout: int32(qc.visibilityTimeout / time.Second),
+				}
+			}
+			handles = handles[n:]
+
+			output, err := qc.client.ChangeMessageVisibilityBatch(context.Background(), &sqs.ChangeMessageVisibilityBatchInput{
+				QueueUrl: aws.String(qc.queueURL),
+				Entries:  entries,
+			})
+			if err != nil {
+				fmt.Println("❌ Error extending visibility timeout:", err)
+				continue
+			}
+			for _, failed := range output.Failed {
+				fmt.Println("❌ Error extending visibility timeout:", aws.ToString(failed.Message))
+			}
+		}
+	}
+}
+
+// Delete finished messages in batches, sending a batch once it is full and
+// otherwise every second
+func (qc *QueueConsumer) deleteFinished(finished <-chan string) {
+	ticker := time.NewTicker(sqsDeleteInterval)
+	defer ticker.Stop()
+	var batch []string
+	for {
+		select {
+		case handle, ok := <-finished:
+			if !ok {
+				qc.deleteBatch(batch)
+				return
+			}
+			if batch = append(batch, handle); len(batch) < sqsBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		qc.deleteBatch(batch)
+		batch = nil
+	}
+}
+
+func (qc *QueueConsumer) deleteBatch(handles []string) {
+	if len(handles) == 0 {
+		return
+	}
+	entries := make([]sqstypes.DeleteMessageBatchRequestEntry, len(handles))
+	for i, handle := range handles {
+		entries[i] = sqstypes.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(strconv.Itoa(i)),
+			ReceiptHandle: aws.String(handle),
+		}
+	}
+	output, err := qc.client.DeleteMessageBatch(context.Background(), &sqs.DeleteMessageBatchInput{
+		QueueUrl: aws.String(qc.queueURL),
+		Entries:  entries,
+	})
+	if err != nil {
+		fmt.Println("❌ Error deleting messages from SQS:", err)
+		return
+	}
+	for _, failed := range output.Failed {
+		fmt.Println("❌ Error deleting message from SQS:", aws.ToString(failed.Message))
+	}
+	fmt.Println("✅ Deleted", len(output.Successful), "messages from SQS")
+}
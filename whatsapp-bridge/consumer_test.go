@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	logfunction "whatsapp-client/log-function"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// fakeQueue is an in-memory SQS queue
+type fakeQueue struct {
+	mu        sync.Mutex
+	pending   []sqstypes.Message
+	deleted   []string
+	batches   []int
+	extended  map[string]int
+	received  int
+	maxFetch  int32
+	waitTimes []int32
+}
+
+func newFakeQueue(bodies ...string) *fakeQueue {
+	q := &fakeQueue{extended: make(map[string]int)}
+	for i, body := range bodies {
+		q.pending = append(q.pending, sqstypes.Message{
+			Body:          aws.String(body),
+			ReceiptHandle: aws.String(fmt.Sprintf("handle-%d", i)),
+		})
+	}
+	return q
+}
+
+func (q *fakeQueue) ReceiveMessage(ctx context.Context, in *sqs.ReceiveMessageInput, _ ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	q.mu.Lock()
+	q.maxFetch = max(q.maxFetch, in.MaxNumberOfMessages)
+	q.waitTimes = append(q.waitTimes, in.WaitTimeSeconds)
+	n := min(int(in.MaxNumberOfMessages), len(q.pending))
+	messages := q.pending[:n]
+	q.pending = q.pending[n:]
+	q.received += n
+	q.mu.Unlock()
+
+	if n == 0 {
+		// Long polling on an empty queue
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+}
+
+func (q *fakeQueue) ChangeMessageVisibilityBatch(ctx context.Context, in *sqs.ChangeMessageVisibilityBatchInput, _ ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, entry := range in.Entries {
+		q.extended[*entry.ReceiptHandle]++
+	}
+	return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+}
+
+func (q *fakeQueue) DeleteMessageBatch(ctx context.Context, in *sqs.DeleteMessageBatchInput, _ ...func(*sqs.Options)) (*sqs.DeleteMessageBatchOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	output := &sqs.DeleteMessageBatchOutput{}
+	for _, entry := range in.Entries {
+		q.deleted = append(q.deleted, *entry.ReceiptHandle)
+		output.Successful = append(output.Successful, sqstypes.DeleteMessageBatchResultEntry{Id: entry.Id})
+	}
+	q.batches = append(q.batches, len(in.Entries))
+	return output, nil
+}
+
+func (q *fakeQueue) deletedHandles() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	handles := append([]string(nil), q.deleted...)
+	sort.Strings(handles)
+	return handles
+}
+
+// testLogAPI is a log API that answers by the message text: "bad" gets a
+// 400, "busy" a 503 and anything else a 200 after the delay
+type testLogAPI struct {
+	*httptest.Server
+	delay        time.Duration
+	calls        atomic.Int32
+	active, peak atomic.Int32
+}
+
+func newTestLogAPI(t *testing.T, delay time.Duration) *testLogAPI {
+	t.Helper()
+	api := &testLogAPI{delay: delay}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.calls.Add(1)
+		active := api.active.Add(1)
+		defer api.active.Add(-1)
+		for peak := api.peak.Load(); active > peak && !api.peak.CompareAndSwap(peak, active); peak = api.peak.Load() {
+		}
+		switch r.FormValue("message_text") {
+		case "bad":
+			w.WriteHeader(http.StatusBadRequest)
+		case "busy":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			time.Sleep(api.delay)
+		}
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func newTestConsumer(t *testing.T, queue *fakeQueue, api *testLogAPI, workers string) *QueueConsumer {
+	t.Helper()
+	logClient, err := logfunction.NewLogClient(logfunction.Config{
+		Endpoint: api.URL,
+		Token:    "token",
+		Retry:    logfunction.RetryPolicy{MaxAttempts: 1},
+		Fields:   logfunction.DefaultFieldMapping(),
+	})
+	if err != nil {
+		t.Fatalf("NewLogClient: %v", err)
+	}
+	t.Setenv("SQS_CONSUMER_WORKERS", workers)
+	t.Setenv("SQS_WAIT_TIME", "")
+	t.Setenv("SQS_VISIBILITY_TIMEOUT", "2s")
+	return newQueueConsumer(queue, "queue", logClient)
+}
+
+func queuedEntry(t *testing.T, messageType, text string) string {
+	t.Helper()
+	body, err := json.Marshal(WALogMessageForQueue{Type: messageType, Message: text, MessageID: text})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// Wait until cond holds or fail after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestQueueConsumerDeliversWithWorkerPool(t *testing.T) {
+	var bodies []string
+	for i := 0; i < 25; i++ {
+		bodies = append(bodies, queuedEntry(t, "text", fmt.Sprint("message ", i)))
+	}
+	queue := newFakeQueue(bodies...)
+	api := newTestLogAPI(t, 20*time.Millisecond)
+	consumer := newTestConsumer(t, queue, api, "3")
+
+	consumer.Start()
+	waitFor(t, "all messages to be deleted", func() bool { return len(queue.deletedHandles()) == 25 })
+	consumer.Stop()
+
+	if peak := api.peak.Load(); peak < 2 || peak > 3 {
+		t.Errorf("%d requests at once, want 2 to 3 with 3 workers", peak)
+	}
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	if queue.maxFetch > 3 {
+		t.Errorf("received up to %d messages at once, more than there are workers", queue.maxFetch)
+	}
+	for _, wait := range queue.waitTimes {
+		if wait != 20 {
+			t.Fatalf("WaitTimeSeconds = %d, want long polling of 20", wait)
+		}
+	}
+	for _, size := range queue.batches {
+		if size < 1 || size > sqsBatchSize {
+			t.Errorf("deleted a batch of %d", size)
+		}
+	}
+	if len(queue.batches) >= 25 {
+		t.Errorf("%d delete calls for 25 messages, want them batched", len(queue.batches))
+	}
+}
+
+func TestQueueConsumerDropsMessagesThatCannotSucceed(t *testing.T) {
+	queue := newFakeQueue(
+		"not json",
+		queuedEntry(t, "carrier-pigeon", "unknown type"),
+		queuedEntry(t, "image", "media without file"),
+		queuedEntry(t, "text", "bad"),
+		queuedEntry(t, "text", "busy"),
+		queuedEntry(t, "text", "fine"),
+	)
+	api := newTestLogAPI(t, 0)
+	consumer := newTestConsumer(t, queue, api, "2")
+
+	consumer.Start()
+	waitFor(t, "the API calls", func() bool { return api.calls.Load() == 3 })
+	consumer.Stop()
+
+	want := []string{"handle-0", "handle-1", "handle-2", "handle-3", "handle-5"}
+	got := queue.deletedHandles()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("deleted %v, want %v; only the transient failure should stay queued", got, want)
+	}
+}
+
+func TestQueueConsumerStopFinishesInFlight(t *testing.T) {
+	queue := newFakeQueue(queuedEntry(t, "text", "one"), queuedEntry(t, "text", "two"))
+	api := newTestLogAPI(t, 300*time.Millisecond)
+	consumer := newTestConsumer(t, queue, api, "2")
+
+	consumer.Start()
+	waitFor(t, "both messages to be in flight", func() bool { return api.active.Load() == 2 })
+	consumer.Stop()
+
+	if got := queue.deletedHandles(); len(got) != 2 {
+		t.Errorf("deleted %v after Stop, want both in-flight messages finished and deleted", got)
+	}
+	if active := api.active.Load(); active != 0 {
+		t.Errorf("%d requests still running after Stop", active)
+	}
+}
+
+func TestQueueConsumerExtendsVisibility(t *testing.T) {
+	queue := newFakeQueue(queuedEntry(t, "text", "slow"))
+	api := newTestLogAPI(t, 1500*time.Millisecond)
+	consumer := newTestConsumer(t, queue, api, "1")
+
+	consumer.Start()
+	waitFor(t, "the message to be deleted", func() bool { return len(queue.deletedHandles()) == 1 })
+	consumer.Stop()
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	if queue.extended["handle-0"] == 0 {
+		t.Error("visibility of a message worked on past half the timeout was not extended")
+	}
+}
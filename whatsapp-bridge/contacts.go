@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/libsignal/logger"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+// ContactCard is a single contact to share
+type ContactCard struct {
+	Name         string `json:"name"`
+	Phone        string `json:"phone"`
+	Organization string `json:"organization,omitempty"`
+	Email        string `json:"email,omitempty"`
+}
+
+// SendContactRequest represents the request body for the send contact API
+type SendContactRequest struct {
+	Recipient       string        `json:"recipient"`
+	Contacts        []ContactCard `json:"contacts"`
+	AdminPhone      string        `json:"admin_phone"`
+	ParentMessageID string        `json:"wa_parent_message_id"`
+	RequireParent   bool          `json:"require_parent"`
+}
+
+// Escape a vCard 3.0 text value (RFC 2426 section 4)
+var vCardEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// Reverse vCardEscaper when reading values back
+var vCardUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+// Build a vCard 3.0 for the contact. The waid parameter lets phones show
+// the "Message" button for numbers that are on WhatsApp.
+func buildVCard(card ContactCard) string {
+	name := vCardEscaper.Replace(card.Name)
+	digits := strings.TrimPrefix(strings.NewReplacer(" ", "", "-", "", "(", "", ")", "").Replace(card.Phone), "+")
+
+	lines := []string{
+		"BEGIN:VCARD",
+		"VERSION:3.0",
+		"N:;" + name + ";;;",
+		"FN:" + name,
+	}
+	if card.Organization != "" {
+		lines = append(lines, "ORG:"+vCardEscaper.Replace(card.Organization))
+	}
+	lines = append(lines, "TEL;type=CELL;type=VOICE;waid="+digits+":+"+digits)
+	if card.Email != "" {
+		lines = append(lines, "EMAIL;type=INTERNET:"+vCardEscaper.Replace(card.Email))
+	}
+	lines = append(lines, "END:VCARD")
+	return strings.Join(lines, "\r\n")
+}
+
+// Build the contact message proto, using a contacts array for more than one card
+func buildContactMessage(cards []ContactCard, contextInfo *waE2E.ContextInfo) *waE2E.Message {
+	contacts := make([]*waE2E.ContactMessage, 0, len(cards))
+	for _, card := range cards {
+		contacts = append(contacts, &waE2E.ContactMessage{
+			DisplayName: proto.String(card.Name),
+			Vcard:       proto.String(buildVCard(card)),
+		})
+	}
+
+	if len(contacts) == 1 {
+		contacts[0].ContextInfo = contextInfo
+		return &waE2E.Message{ContactMessage: contacts[0]}
+	}
+	return &waE2E.Message{ContactsArrayMessage: &waE2E.ContactsArrayMessage{
+		DisplayName: proto.String(fmt.Sprintf("%d contacts", len(contacts))),
+		Contacts:    contacts,
+		ContextInfo: contextInfo,
+	}}
+}
+
+// Function to send one or more contact cards
+func sendWhatsAppContactMessage(client *whatsmeow.Client, recipient string, cards []ContactCard, contextInfo *waE2E.ContextInfo) (bool, string, string, string) {
+	if !client.IsConnected() {
+		return false, "Not connected to WhatsApp", "", ""
+	}
+
+	recipientJID, err := parseChatJID(recipient)
+	if err != nil {
+		return false, fmt.Sprintf("Error parsing JID: %v", err), "", ""
+	}
+
+	resp, err := client.SendMessage(context.Background(), recipientJID, buildContactMessage(cards, contextInfo))
+	if err != nil {
+		return false, fmt.Sprintf("Error sending contact message: %v", err), "", ""
+	}
+
+	return true, fmt.Sprintf("Contact message sent to %s", recipient), resp.ID, contextInfo.GetStanzaID()
+}
+
+// Handler for sharing contact cards
+func sendContactHandler(client *whatsmeow.Client, messageStore *MessageStore, eventSink EventSink) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req SendContactRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Error parsing request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Recipient == "" || len(req.Contacts) == 0 {
+			http.Error(w, "Recipient and at least one contact are required", http.StatusBadRequest)
+			return
+		}
+		for i, card := range req.Contacts {
+			if card.Name == "" || card.Phone == "" {
+				http.Error(w, fmt.Sprintf("Contact %d needs a name and phone", i+1), http.StatusBadRequest)
+				return
+			}
+		}
+
+		contextInfo, err := buildReplyContext(client, messageStore, req.Recipient, req.ParentMessageID, req.RequireParent)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		success, msg, msgID, parentMsgID := sendWhatsAppContactMessage(client, req.Recipient, req.Contacts, contextInfo)
+		fmt.Println("Message sent", success, msg, msgID)
+
+		if success {
+			senderPhone := client.Store.ID.User
+			msgTime := time.Now()
+
+			content, media := extractMessageContent(buildContactMessage(req.Contacts, nil))
+
+			// One queue entry per card, the same as inbound contacts
+			events := make([]WALogMessageForQueue, 0, len(req.Contacts))
+			for _, card := range req.Contacts {
+				contactName, contactNumber := parseVCard(buildVCard(card))
+				events = append(events, WALogMessageForQueue{
+					Type:            "contact",
+					From:            senderPhone,
+					To:              req.Recipient,
+					AdminPhone:      req.AdminPhone,
+					Message:         contactName + " - " + contactNumber,
+					Time:            msgTime,
+					MessageID:       msgID,
+					ParentMessageID: parentMsgID,
+					Status:          "SENT",
+				})
+			}
+			err := logSentMessage(messageStore, eventSink, req.Recipient, Message{
+				ID:              msgID,
+				Sender:          senderPhone,
+				Content:         content,
+				Time:            msgTime,
+				MediaInfo:       media,
+				ParentMessageID: parentMsgID,
+				AdminPhone:      req.AdminPhone,
+			}, nil, events...)
+			if err != nil {
+				logger.Error("Failed to send message to event sinks:", err)
+			} else {
+				logger.Info("Message sent to event sinks successfully")
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !success {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(SendMessageResponseWithLog{
+			Success: success,
+			Message: msg,
+		})
+	}
+}
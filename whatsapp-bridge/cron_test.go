@@ -0,0 +1,186 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // the DST tests need zone data wherever they run
+)
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		value   string
+		field   cronField
+		want    []int
+		wantErr bool
+	}{
+		{"*", cronHour, rangeOf(0, 23), false},
+		{"?", cronDom, rangeOf(1, 31), false},
+		{"5", cronMinute, []int{5}, false},
+		{"1,15,30", cronMinute, []int{1, 15, 30}, false},
+		{"9-17", cronHour, rangeOf(9, 17), false},
+		{"*/15", cronMinute, []int{0, 15, 30, 45}, false},
+		{"10-20/5", cronMinute, []int{10, 15, 20}, false},
+		{"50/5", cronMinute, []int{50, 55}, false},
+		{"1-5,*/20", cronMinute, []int{0, 1, 2, 3, 4, 5, 20, 40}, false},
+		{"jan", cronMonth, []int{1}, false},
+		{"MAR-may", cronMonth, []int{3, 4, 5}, false},
+		{"mon-fri", cronDow, rangeOf(1, 5), false},
+		{"sun,sat", cronDow, []int{0, 6}, false},
+		{"7", cronDow, []int{7}, false},
+		{"60", cronMinute, nil, true},
+		{"0", cronDom, nil, true},
+		{"13", cronMonth, nil, true},
+		{"8", cronDow, nil, true},
+		{"5-1", cronHour, nil, true},
+		{"*/0", cronMinute, nil, true},
+		{"*/x", cronMinute, nil, true},
+		{"monday", cronDow, nil, true},
+		{"", cronMinute, nil, true},
+		{"1,", cronMinute, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCronField(tt.value, tt.field)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseCronField(%q, %s) = %b, want an error", tt.value, tt.field.name, got)
+			}
+			continue
+		}
+		if want := bitsOf(tt.want); err != nil || got != want {
+			t.Errorf("parseCronField(%q, %s) = %b, %v; want %b", tt.value, tt.field.name, got, err, want)
+		}
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	for _, expr := range []string{"* * * *", "* * * * * *", "@often", "61 * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", expr)
+		}
+	}
+
+	// Sunday may be written as 7
+	for _, expr := range []string{"0 0 * * 7", "0 0 * * 0", "0 0 * * sun", "0 0 * * 5-7", "@weekly"} {
+		s, err := parseCron(expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", expr, err)
+		}
+		if s.dow&1 == 0 || s.dow&(1<<7) != 0 {
+			t.Errorf("parseCron(%q): day of week = %b, want Sunday as 0", expr, s.dow)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	utc := func(s string) time.Time {
+		t.Helper()
+		parsed, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		expr string
+		from string
+		want []string
+	}{
+		{"*/15 * * * *", "2026-01-01 10:07", []string{"2026-01-01 10:15", "2026-01-01 10:30", "2026-01-01 10:45"}},
+		{"30 9 * * mon-fri", "2026-01-02 10:00", []string{"2026-01-05 09:30", "2026-01-06 09:30"}}, // Friday to Monday
+		{"0 0 * * 7", "2026-01-01 00:00", []string{"2026-01-04 00:00", "2026-01-11 00:00"}},
+		{"@monthly", "2026-01-31 23:59", []string{"2026-02-01 00:00", "2026-03-01 00:00"}},
+		{"0 12 31 * *", "2026-01-31 12:00", []string{"2026-03-31 12:00", "2026-05-31 12:00"}},
+		{"0 0 29 feb *", "2026-01-01 00:00", []string{"2028-02-29 00:00"}},
+		// Both day fields restricted: either one matching is enough
+		{"0 8 1 * mon", "2026-01-01 09:00", []string{"2026-01-05 08:00", "2026-01-12 08:00"}},
+		// Next is strictly after the given time, ignoring seconds
+		{"0 10 * * *", "2026-01-01 10:00", []string{"2026-01-02 10:00"}},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.expr, err)
+		}
+		next := utc(tt.from)
+		for _, want := range tt.want {
+			next = s.Next(next)
+			if !next.Equal(utc(want)) {
+				t.Errorf("%q: Next = %v, want %s", tt.expr, next, want)
+				break
+			}
+		}
+	}
+
+	s, _ := parseCron("0 0 30 2 *")
+	if next := s.Next(utc("2026-01-01 00:00")); !next.IsZero() {
+		t.Errorf("February 30th: Next = %v, want the zero time", next)
+	}
+}
+
+func TestCronNextAcrossDaylightSaving(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(s string) time.Time {
+		t.Helper()
+		parsed, err := time.Parse("2006-01-02 15:04 -0700", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.In(newYork)
+	}
+
+	// In 2026 clocks go forward from 02:00 to 03:00 on March 8th and back
+	// from 02:00 to 01:00 on November 1st
+	tests := []struct {
+		name string
+		expr string
+		from string
+		want []string
+	}{
+		{"skipped fixed time runs after the change", "30 2 * * *", "2026-03-07 12:00 -0500",
+			[]string{"2026-03-08 03:30 -0400", "2026-03-09 02:30 -0400"}},
+		{"skipped time from within the day", "30 1,2 * * *", "2026-03-08 01:45 -0500",
+			[]string{"2026-03-08 03:30 -0400", "2026-03-09 01:30 -0400"}},
+		{"hourly skips the missing hour", "0 * * * *", "2026-03-08 01:30 -0500",
+			[]string{"2026-03-08 03:00 -0400", "2026-03-08 04:00 -0400"}},
+		{"repeated fixed time runs once", "30 1 * * *", "2026-10-31 12:00 -0400",
+			[]string{"2026-11-01 01:30 -0400", "2026-11-02 01:30 -0500"}},
+		{"hourly runs in both passes", "0 * * * *", "2026-11-01 00:30 -0400",
+			[]string{"2026-11-01 01:00 -0400", "2026-11-01 01:00 -0500", "2026-11-01 02:00 -0500"}},
+		{"daily after the change", "0 9 * * *", "2026-03-07 10:00 -0500",
+			[]string{"2026-03-08 09:00 -0400", "2026-03-09 09:00 -0400"}},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.expr, err)
+		}
+		next := at(tt.from)
+		for _, want := range tt.want {
+			next = s.Next(next)
+			if !next.Equal(at(want)) || next.Location() != newYork {
+				t.Errorf("%s: Next = %v, want %s in New York", tt.name, next, want)
+				break
+			}
+		}
+	}
+}
+
+func rangeOf(lo, hi int) []int {
+	var values []int
+	for v := lo; v <= hi; v++ {
+		values = append(values, v)
+	}
+	return values
+}
+
+func bitsOf(values []int) uint64 {
+	var bits uint64
+	for _, v := range values {
+		bits |= 1 << uint(v)
+	}
+	return bits
+}
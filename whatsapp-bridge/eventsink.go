@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+const defaultEventFilePath = "store/events.jsonl"
+
+// EventSink receives the log entries of sent and received messages, receipts,
+// reactions and edits
+type EventSink interface {
+	Send(ctx context.Context, event WALogMessageForQueue) error
+	Close() error
+}
+
+// Publish a log entry to the configured sinks
+func publishEvent(message WALogMessageForQueue, eventSink EventSink) error {
+	if err := eventSink.Send(context.Background(), message); err != nil {
+		return fmt.Errorf("error publishing event: %w", err)
+	}
+	fmt.Println("✅ Event published successfully")
+	return nil
+}
+
+// SQSSink sends events to an SQS queue, from which they are delivered to the
+// log API
+type SQSSink struct {
+	client   *sqs.Client
+	queueURL string
+}
+
+// Look up the queue named by AWS_SQS_QUEUE_NAME
+func newSQSSink(ctx context.Context) (*SQSSink, error) {
+	cfg := getConfig()
+	if cfg == nil {
+		return nil, fmt.Errorf("sqs: AWS config could not be loaded")
+	}
+	client := sqs.NewFromConfig(*cfg)
+	result, err := client.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
+		QueueName: aws.String(os.Getenv("AWS_SQS_QUEUE_NAME")),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sqs: error getting queue URL: %w", err)
+	}
+	fmt.Println("SQS Queue URL:", *result.QueueUrl)
+	return &SQSSink{client: client, queueURL: *result.QueueUrl}, nil
+}
+
+func (s *SQSSink) Send(ctx context.Context, event WALogMessageForQueue) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("sqs: error marshalling event: %w", err)
+	}
+	_, err = s.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(s.queueURL),
+		MessageBody: aws.String(string(body)),
+	})
+	if err != nil {
+		return fmt.Errorf("sqs: %w", err)
+	}
+	return nil
+}
+
+func (s *SQSSink) Close() error { return nil }
+
+// WebhookSink POSTs each event as JSON to a URL
+type WebhookSink struct {
+	url        string
+	token      string
+	httpClient *http.Client
+}
+
+func newWebhookSink(url, token string) (*WebhookSink, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("webhook: EVENT_WEBHOOK_URL must be an http or https URL")
+	}
+	return &WebhookSink{url: url, token: token, httpClient: &http.Client{Timeout: webhookTimeout}}, nil
+}
+
+func (s *WebhookSink) Send(ctx context.Context, event WALogMessageForQueue) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("webhook: error marshalling event: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook: returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *WebhookSink) Close() error { return nil }
+
+// FileSink appends each event as a line of JSON to a file
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newFileSink(path string) (*FileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("file: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("file: %w", err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+func (s *FileSink) Send(ctx context.Context, event WALogMessageForQueue) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("file: error marshalling event: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("file: %w", err)
+	}
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// MemorySink keeps the most recent events in memory. It is meant for tests
+// and is not offered in EVENT_SINKS.
+type MemorySink struct {
+	mu     sync.Mutex
+	limit  int
+	events []WALogMessageForQueue
+}
+
+func newMemorySink(limit int) *MemorySink {
+	return &MemorySink{limit: limit}
+}
+
+func (s *MemorySink) Send(ctx context.Context, event WALogMessageForQueue) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	if s.limit > 0 && len(s.events) > s.limit {
+		s.events = s.events[len(s.events)-s.limit:]
+	}
+	return nil
+}
+
+// Events returns a copy of the events kept, oldest first
+func (s *MemorySink) Events() []WALogMessageForQueue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]WALogMessageForQueue(nil), s.events...)
+}
+
+func (s *MemorySink) Close() error { return nil }
+
+// MultiSink sends each event to all of its sinks. A failing sink does not
+// keep the others from receiving the event.
+type MultiSink []EventSink
+
+func (m MultiSink) Send(ctx context.Context, event WALogMessageForQueue) error {
+	var errs []error
+	for _, sink := range m {
+		if err := sink.Send(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m MultiSink) Close() error {
+	var errs []error
+	for _, sink := range m {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Set up the sinks listed in EVENT_SINKS, e.g. "sqs,file". Without it, SQS is
+// used when AWS_SQS_QUEUE_NAME is set and the JSONL file otherwise, so the
+// bridge runs without AWS.
+//
+//	sqs      AWS_SQS_QUEUE_NAME, AWS_REGION
+//	webhook  EVENT_WEBHOOK_URL, EVENT_WEBHOOK_TOKEN (optional bearer token)
+//	file     EVENT_FILE_PATH (defaults to store/events.jsonl)
+func newEventSinkFromEnv(ctx context.Context) (EventSink, error) {
+	names := os.Getenv("EVENT_SINKS")
+	if names == "" {
+		names = "file"
+		if os.Getenv("AWS_SQS_QUEUE_NAME") != "" {
+			names = "sqs"
+		}
+	}
+
+	var sinks MultiSink
+	for _, name := range strings.Split(names, ",") {
+		var sink EventSink
+		var err error
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "sqs":
+			sink, err = newSQSSink(ctx)
+		case "webhook":
+			sink, err = newWebhookSink(os.Getenv("EVENT_WEBHOOK_URL"), os.Getenv("EVENT_WEBHOOK_TOKEN"))
+		case "file":
+			path := os.Getenv("EVENT_FILE_PATH")
+			if path == "" {
+				path = defaultEventFilePath
+			}
+			sink, err = newFileSink(path)
+		case "":
+			continue
+		default:
+			err = fmt.Errorf("unknown event sink %q", name)
+		}
+		if err != nil {
+			sinks.Close()
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+		fmt.Println("✅ Publishing events to", strings.TrimSpace(name))
+	}
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("EVENT_SINKS lists no sinks")
+	}
+	if len(sinks) == 1 {
+		return sinks[0], nil
+	}
+	return sinks, nil
+}
+
+// Find the SQS sink among the configured ones, if any
+func findSQSSink(sink EventSink) *SQSSink {
+	switch s := sink.(type) {
+	case *SQSSink:
+		return s
+	case MultiSink:
+		for _, inner := range s {
+			if found := findSQSSink(inner); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// failingSink fails every send and close with its error
+type failingSink struct{ err error }
+
+func (s failingSink) Send(ctx context.Context, event WALogMessageForQueue) error { return s.err }
+func (s failingSink) Close() error                                               { return s.err }
+
+func TestMultiSinkFansOut(t *testing.T) {
+	first, second := newMemorySink(0), newMemorySink(0)
+	errA, errB := errors.New("sink a down"), errors.New("sink b down")
+	sinks := MultiSink{first, failingSink{errA}, second, failingSink{errB}}
+
+	err := sinks.Send(context.Background(), WALogMessageForQueue{MessageID: "m1"})
+	if !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Errorf("Send error = %v, want both sink errors joined", err)
+	}
+	for i, sink := range []*MemorySink{first, second} {
+		if events := sink.Events(); len(events) != 1 || events[0].MessageID != "m1" {
+			t.Errorf("sink %d got %v, want the event despite the failing sinks", i, events)
+		}
+	}
+
+	if err := sinks.Close(); !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Errorf("Close error = %v, want both sink errors joined", err)
+	}
+	if err := (MultiSink{first, second}).Send(context.Background(), WALogMessageForQueue{}); err != nil {
+		t.Errorf("Send with healthy sinks = %v, want nil", err)
+	}
+}
+
+func TestMemorySinkKeepsLatest(t *testing.T) {
+	sink := newMemorySink(2)
+	for _, id := range []string{"a", "b", "c"} {
+		sink.Send(context.Background(), WALogMessageForQueue{MessageID: id})
+	}
+	events := sink.Events()
+	if len(events) != 2 || events[0].MessageID != "b" || events[1].MessageID != "c" {
+		t.Errorf("Events() = %v, want b and c", events)
+	}
+}
+
+func TestFileSinkWritesJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "events.jsonl")
+	sink, err := newFileSink(path)
+	if err != nil {
+		t.Fatalf("newFileSink: %v", err)
+	}
+	for _, id := range []string{"m1", "m2"} {
+		if err := sink.Send(context.Background(), WALogMessageForQueue{MessageID: id, Type: "text"}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var ids []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event WALogMessageForQueue
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("line %q is not JSON: %v", scanner.Text(), err)
+		}
+		ids = append(ids, event.MessageID)
+	}
+	if len(ids) != 2 || ids[0] != "m1" || ids[1] != "m2" {
+		t.Errorf("file holds %v, want m1 and m2", ids)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var auth string
+	var got WALogMessageForQueue
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got)
+		if got.MessageID == "reject" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	sink, err := newWebhookSink(server.URL, "secret")
+	if err != nil {
+		t.Fatalf("newWebhookSink: %v", err)
+	}
+	if err := sink.Send(context.Background(), WALogMessageForQueue{MessageID: "m1"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if auth != "Bearer secret" || got.MessageID != "m1" {
+		t.Errorf("webhook got auth %q and event %q", auth, got.MessageID)
+	}
+	if err := sink.Send(context.Background(), WALogMessageForQueue{MessageID: "reject"}); err == nil {
+		t.Error("Send succeeded on a 502 response")
+	}
+	if _, err := newWebhookSink("ftp://example.com", ""); err == nil {
+		t.Error("newWebhookSink accepted a non-http URL")
+	}
+}
+
+func TestEventSinkFromEnv(t *testing.T) {
+	t.Setenv("AWS_SQS_QUEUE_NAME", "")
+	t.Setenv("EVENT_FILE_PATH", filepath.Join(t.TempDir(), "events.jsonl"))
+
+	t.Setenv("EVENT_SINKS", "")
+	sink, err := newEventSinkFromEnv(context.Background())
+	if err != nil {
+		t.Fatalf("default sinks: %v", err)
+	}
+	if _, ok := sink.(*FileSink); !ok {
+		t.Errorf("default sink is %T, want *FileSink without SQS", sink)
+	}
+	sink.Close()
+
+	for _, names := range []string{"memory", "file,bogus", " , "} {
+		t.Setenv("EVENT_SINKS", names)
+		if sink, err := newEventSinkFromEnv(context.Background()); err == nil {
+			sink.Close()
+			t.Errorf("EVENT_SINKS=%q was accepted", names)
+		}
+	}
+}
+
+func TestFindSQSSink(t *testing.T) {
+	sqsSink := &SQSSink{queueURL: "q"}
+	if found := findSQSSink(MultiSink{newMemorySink(0), MultiSink{sqsSink}}); found != sqsSink {
+		t.Errorf("findSQSSink(nested) = %v, want the SQS sink", found)
+	}
+	if found := findSQSSink(newMemorySink(0)); found != nil {
+		t.Errorf("findSQSSink(memory) = %v, want nil", found)
+	}
+}
@@ -12,6 +12,7 @@ require (
 	github.com/mdp/qrterminal v1.0.1
 	go.mau.fi/libsignal v0.2.0
 	go.mau.fi/whatsmeow v0.0.0-20250723174453-937d77661333
+	golang.org/x/image v0.25.0
 	google.golang.org/protobuf v1.36.6
 )
 
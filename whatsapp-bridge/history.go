@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// PageCursor marks the last row returned by a paginated listing. Rows are
+// ordered by timestamp and then by ID so that rows sharing a timestamp are
+// neither skipped nor repeated. The time keeps nanoseconds, as stored times
+// do, so rows within the same millisecond still compare correctly.
+type PageCursor struct {
+	Time time.Time
+	ID   string
+}
+
+// Encode the cursor into an opaque string for API clients
+func (c PageCursor) String() string {
+	raw := strconv.FormatInt(c.Time.UnixNano(), 10) + ":" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// Parse a cursor previously returned by PageCursor.String
+func parsePageCursor(s string) (*PageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	nanos, id, found := strings.Cut(string(raw), ":")
+	if !found {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	ns, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &PageCursor{Time: time.Unix(0, ns), ID: id}, nil
+}
+
+// ListChatsResponse is returned by GET /api/chats
+type ListChatsResponse struct {
+	Chats      []Chat `json:"chats"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ListMessagesResponse is returned by GET /api/chats/{jid}/messages
+type ListMessagesResponse struct {
+	Messages   []Message `json:"messages"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// Parse the limit query parameter, falling back to the default page size
+func parseLimit(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("limit must be a positive integer")
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return limit, nil
+}
+
+// Parse a time query parameter given either as RFC 3339 or as unix milliseconds
+func parseTimeParam(r *http.Request, name string) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be RFC 3339 or unix milliseconds", name)
+	}
+	return t, nil
+}
+
+// Normalise a chat identifier from the URL into a full JID string
+func parseChatJID(value string) (types.JID, error) {
+	if strings.Contains(value, "@") {
+		return types.ParseJID(value)
+	}
+	server := types.DefaultUserServer
+	if strings.Contains(value, "-") {
+		server = types.GroupServer
+	}
+	return types.NewJID(value, server), nil
+}
+
+// Parse the filters and cursor shared by the message listing endpoints
+func parseMessageQuery(r *http.Request) (MessageQuery, error) {
+	var err error
+	query := MessageQuery{Sender: r.URL.Query().Get("sender")}
+
+	if query.Limit, err = parseLimit(r); err != nil {
+		return query, err
+	}
+	if value := r.URL.Query().Get("is_from_me"); value != "" {
+		isFromMe, err := strconv.ParseBool(value)
+		if err != nil {
+			return query, fmt.Errorf("is_from_me must be true or false")
+		}
+		query.IsFromMe = &isFromMe
+	}
+	if query.Since, err = parseTimeParam(r, "since"); err != nil {
+		return query, err
+	}
+	if query.Until, err = parseTimeParam(r, "until"); err != nil {
+		return query, err
+	}
+	if value := r.URL.Query().Get("cursor"); value != "" {
+		if query.Cursor, err = parsePageCursor(value); err != nil {
+			return query, err
+		}
+	}
+	if value := r.URL.Query().Get("mentions"); value != "" {
+		for _, mention := range strings.Split(value, ",") {
+			jid, err := parseUserJID(strings.TrimSpace(mention))
+			if err != nil {
+				return query, fmt.Errorf("invalid mention '%s': %v", mention, err)
+			}
+			query.Mentions = append(query.Mentions, jid.String())
+		}
+	}
+	return query, nil
+}
+
+// Handler for listing stored chats
+func listChatsHandler(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Method not allowed"})
+			return
+		}
+
+		limit, err := parseLimit(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: err.Error()})
+			return
+		}
+
+		var cursor *PageCursor
+		if value := r.URL.Query().Get("cursor"); value != "" {
+			cursor, err = parsePageCursor(value)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: err.Error()})
+				return
+			}
+		}
+
+		chats, err := messageStore.GetChats(cursor, limit)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to get chats: %v", err)})
+			return
+		}
+
+		resp := ListChatsResponse{Chats: chats}
+		if len(chats) == limit {
+			last := chats[len(chats)-1]
+			resp.NextCursor = PageCursor{Time: last.LastMessageTime, ID: last.JID}.String()
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// Handler for listing the messages of a single chat
+func listMessagesHandler(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Method not allowed"})
+			return
+		}
+
+		chatJID, err := parseChatJID(r.PathValue("jid"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Invalid chat jid: %v", err)})
+			return
+		}
+
+		query, err := parseMessageQuery(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: err.Error()})
+			return
+		}
+
+		messages, err := messageStore.GetMessages(chatJID.String(), query)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to get messages: %v", err)})
+			return
+		}
+
+		resp := ListMessagesResponse{Messages: messages}
+		if len(messages) == query.Limit {
+			last := messages[len(messages)-1]
+			resp.NextCursor = PageCursor{Time: last.Time, ID: last.ID}.String()
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mau.fi/libsignal/logger"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+// SendLocationRequest represents the request body for the send location API
+type SendLocationRequest struct {
+	Recipient       string   `json:"recipient"`
+	Latitude        *float64 `json:"latitude"`
+	Longitude       *float64 `json:"longitude"`
+	Name            string   `json:"name"`
+	Address         string   `json:"address"`
+	AdminPhone      string   `json:"admin_phone"`
+	ParentMessageID string   `json:"wa_parent_message_id"`
+	RequireParent   bool     `json:"require_parent"`
+}
+
+// Build the location message proto. Name and address are optional.
+func buildLocationMessage(latitude, longitude float64, name, address string, contextInfo *waE2E.ContextInfo) *waE2E.Message {
+	location := &waE2E.LocationMessage{
+		DegreesLatitude:  proto.Float64(latitude),
+		DegreesLongitude: proto.Float64(longitude),
+		ContextInfo:      contextInfo,
+	}
+	if name != "" {
+		location.Name = proto.String(name)
+	}
+	if address != "" {
+		location.Address = proto.String(address)
+	}
+	return &waE2E.Message{LocationMessage: location}
+}
+
+// Function to send a location pin
+func sendWhatsAppLocationMessage(client *whatsmeow.Client, recipient string, latitude, longitude float64, name, address string, contextInfo *waE2E.ContextInfo) (bool, string, string, string) {
+	if !client.IsConnected() {
+		return false, "Not connected to WhatsApp", "", ""
+	}
+
+	recipientJID, err := parseChatJID(recipient)
+	if err != nil {
+		return false, fmt.Sprintf("Error parsing JID: %v", err), "", ""
+	}
+
+	resp, err := client.SendMessage(context.Background(), recipientJID, buildLocationMessage(latitude, longitude, name, address, contextInfo))
+	if err != nil {
+		return false, fmt.Sprintf("Error sending location message: %v", err), "", ""
+	}
+
+	return true, fmt.Sprintf("Location message sent to %s", recipient), resp.ID, contextInfo.GetStanzaID()
+}
+
+// Handler for sending a location pin
+func sendLocationHandler(client *whatsmeow.Client, messageStore *MessageStore, eventSink EventSink) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req SendLocationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Error parsing request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Recipient == "" || req.Latitude == nil || req.Longitude == nil {
+			http.Error(w, "Recipient, latitude and longitude are required", http.StatusBadRequest)
+			return
+		}
+		if *req.Latitude < -90 || *req.Latitude > 90 || *req.Longitude < -180 || *req.Longitude > 180 {
+			http.Error(w, "Latitude must be between -90 and 90 and longitude between -180 and 180", http.StatusBadRequest)
+			return
+		}
+
+		contextInfo, err := buildReplyContext(client, messageStore, req.Recipient, req.ParentMessageID, req.RequireParent)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		success, msg, msgID, parentMsgID := sendWhatsAppLocationMessage(client, req.Recipient, *req.Latitude, *req.Longitude, req.Name, req.Address, contextInfo)
+		fmt.Println("Message sent", success, msg, msgID)
+
+		if success {
+			senderPhone := client.Store.ID.User
+			msgTime := time.Now()
+
+			content, media := extractMessageContent(buildLocationMessage(*req.Latitude, *req.Longitude, req.Name, req.Address, nil))
+			sentMsg := Message{
+				ID:              msgID,
+				Sender:          senderPhone,
+				Content:         content,
+				Time:            msgTime,
+				MediaInfo:       media,
+				ParentMessageID: parentMsgID,
+				AdminPhone:      req.AdminPhone,
+			}
+
+			// Logged the same way as inbound locations
+			url := "https://maps.google.com/?q=" + fmt.Sprintf("%f", *req.Latitude) + "," + fmt.Sprintf("%f", *req.Longitude)
+			err := logSentMessage(messageStore, eventSink, req.Recipient, sentMsg, nil, WALogMessageForQueue{
+				Type:            "location",
+				From:            senderPhone,
+				To:              req.Recipient,
+				AdminPhone:      req.AdminPhone,
+				Message:         url,
+				Time:            msgTime,
+				MessageID:       msgID,
+				ParentMessageID: parentMsgID,
+				Status:          "SENT",
+			})
+			if err != nil {
+				logger.Error("Failed to send message to event sinks:", err)
+			} else {
+				logger.Info("Message sent to event sinks successfully")
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !success {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(SendMessageResponseWithLog{
+			Success: success,
+			Message: msg,
+		})
+	}
+}
@@ -0,0 +1,414 @@
+package logfunction
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultEndpoint    = "http://privatebackend.railse.com:8080/whatsapp/log-message"
+	DefaultTimeout     = 30 * time.Second
+	DefaultMaxAttempts = 3
+	DefaultBackoff     = time.Second
+	DefaultMaxBackoff  = 30 * time.Second
+)
+
+// ErrNoToken is returned when no bearer token is configured
+var ErrNoToken = errors.New("log API token is not set")
+
+// APIError is a non-200 response from the log API
+type APIError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("error response from log API: %s", e.Status)
+}
+
+// ErrSizeMismatch is wrapped by a FileError when a media file is not as long
+// as recorded
+var ErrSizeMismatch = errors.New("file size does not match")
+
+// FileError is a media file that could not be fetched for an entry
+type FileError struct {
+	URL        string
+	StatusCode int // set when storage answered with an error status
+	Err        error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("error fetching file %s: %v", e.URL, e.Err)
+}
+
+func (e *FileError) Unwrap() error { return e.Err }
+
+// RequestError is a request to the log API that failed before a response
+type RequestError struct {
+	Err error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("error sending log: %v", e.Err)
+}
+
+func (e *RequestError) Unwrap() error { return e.Err }
+
+// Retryable reports whether another attempt may succeed: network errors,
+// rate limiting and server errors may pass. Files that are missing or not
+// the recorded size will not change by fetching them again.
+func Retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return transientStatus(apiErr.StatusCode)
+	}
+	var fileErr *FileError
+	if errors.As(err, &fileErr) {
+		if errors.Is(err, ErrSizeMismatch) {
+			return false
+		}
+		return fileErr.StatusCode == 0 || transientStatus(fileErr.StatusCode)
+	}
+	var reqErr *RequestError
+	return errors.As(err, &reqErr)
+}
+
+func transientStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+// Kind is what an entry logs
+type Kind int
+
+const (
+	KindMessage Kind = iota // a text message, location, contact, reaction, ...
+	KindMedia               // a message with a file
+	KindStatus              // a status change of an earlier message
+)
+
+// Entry is one message or status update to log
+type Entry struct {
+	Kind            Kind
+	From            string
+	To              string
+	Text            string
+	Status          string // "SENT", "DELIVERED", "READ", "PLAYED"
+	Time            time.Time
+	AdminPhone      string
+	MessageID       string
+	ParentMessageID string
+	FileURL         string // for KindMedia
+	FileLength      uint64 // expected size of the file, 0 if unknown
+}
+
+// FieldMapping names the form fields the log API expects
+type FieldMapping struct {
+	From            string `json:"from"`
+	To              string `json:"to"`
+	Text            string `json:"text"`
+	Status          string `json:"status"`
+	Time            string `json:"time"`
+	AdminPhone      string `json:"admin_phone"`
+	MessageID       string `json:"message_id"`
+	ParentMessageID string `json:"parent_message_id"`
+	File            string `json:"file"`
+}
+
+// DefaultFieldMapping returns the field names of the railse log API
+func DefaultFieldMapping() FieldMapping {
+	return FieldMapping{
+		From:            "entity_phone_number_from",
+		To:              "entity_phone_number_to",
+		Text:            "message_text",
+		Status:          "message_status",
+		Time:            "message_time",
+		AdminPhone:      "admin_phone",
+		MessageID:       "wa_message_id",
+		ParentMessageID: "wa_parent_message_id",
+		File:            "files",
+	}
+}
+
+// RetryPolicy controls how failed requests are retried. The wait doubles
+// after every attempt, starting at Backoff and capped at MaxBackoff.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// Config configures a LogClient
+type Config struct {
+	Endpoint string
+	Token    string
+	Timeout  time.Duration // for connecting, response headers and each read of a file; not for a whole upload
+	Retry    RetryPolicy
+	Fields   FieldMapping
+}
+
+// ConfigFromEnv reads the configuration from the environment:
+//
+//	LOG_API_ENDPOINT       defaults to DefaultEndpoint
+//	BEARER_TOKEN           required
+//	LOG_API_TIMEOUT        e.g. "30s"
+//	LOG_API_MAX_ATTEMPTS   1 disables retries
+//	LOG_API_RETRY_BACKOFF  wait before the first retry, e.g. "1s"
+//	LOG_API_FIELDS         JSON object overriding field names, e.g. {"text":"body"}
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Endpoint: os.Getenv("LOG_API_ENDPOINT"),
+		Token:    os.Getenv("BEARER_TOKEN"),
+		Timeout:  DefaultTimeout,
+		Retry:    RetryPolicy{MaxAttempts: DefaultMaxAttempts, Backoff: DefaultBackoff, MaxBackoff: DefaultMaxBackoff},
+		Fields:   DefaultFieldMapping(),
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = DefaultEndpoint
+	}
+	if d, err := time.ParseDuration(os.Getenv("LOG_API_TIMEOUT")); err == nil && d > 0 {
+		cfg.Timeout = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("LOG_API_MAX_ATTEMPTS")); err == nil && n > 0 {
+		cfg.Retry.MaxAttempts = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("LOG_API_RETRY_BACKOFF")); err == nil && d > 0 {
+		cfg.Retry.Backoff = d
+	}
+	if fields := os.Getenv("LOG_API_FIELDS"); fields != "" {
+		if err := json.Unmarshal([]byte(fields), &cfg.Fields); err != nil {
+			return cfg, fmt.Errorf("invalid LOG_API_FIELDS: %v", err)
+		}
+	}
+	return cfg, nil
+}
+
+// LogClient sends messages and status updates to the log API
+type LogClient struct {
+	cfg        Config
+	httpClient *http.Client
+}
+
+// NewLogClient checks the configuration and creates a client
+func NewLogClient(cfg Config) (*LogClient, error) {
+	if cfg.Token == "" {
+		return nil, ErrNoToken
+	}
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("log API endpoint is not set")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.Retry.MaxAttempts < 1 {
+		cfg.Retry.MaxAttempts = 1
+	}
+	// No overall client timeout: a streamed upload of a large video may take
+	// far longer than any single step of it
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: cfg.Timeout}).DialContext,
+		TLSHandshakeTimeout:   cfg.Timeout,
+		ResponseHeaderTimeout: cfg.Timeout,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	return &LogClient{cfg: cfg, httpClient: &http.Client{Transport: transport}}, nil
+}
+
+// Log sends an entry, retrying failures the retry policy allows
+func (c *LogClient) Log(ctx context.Context, entry Entry) error {
+	backoff := c.cfg.Retry.Backoff
+	for attempt := 1; ; attempt++ {
+		err := c.post(ctx, entry)
+		if err == nil || attempt >= c.cfg.Retry.MaxAttempts || !Retryable(err) {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		if backoff *= 2; c.cfg.Retry.MaxBackoff > 0 && backoff > c.cfg.Retry.MaxBackoff {
+			backoff = c.cfg.Retry.MaxBackoff
+		}
+	}
+}
+
+// Start downloading the file of a media entry. Fails if storage does not
+// return it or announces a size other than the recorded one.
+func (c *LogClient) openFile(ctx context.Context, entry Entry) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, entry.FileURL, nil)
+	if err != nil {
+		return nil, &FileError{URL: entry.FileURL, Err: err}
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, &FileError{URL: entry.FileURL, Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &FileError{URL: entry.FileURL, StatusCode: resp.StatusCode, Err: fmt.Errorf("storage returned %s", resp.Status)}
+	}
+	if entry.FileLength > 0 && resp.ContentLength >= 0 && uint64(resp.ContentLength) != entry.FileLength {
+		resp.Body.Close()
+		return nil, &FileError{URL: entry.FileURL, Err: sizeMismatch(entry.FileLength, resp.ContentLength)}
+	}
+	return resp.Body, nil
+}
+
+func sizeMismatch(expected uint64, got int64) error {
+	return fmt.Errorf("%w: expected %d bytes, got %d", ErrSizeMismatch, expected, got)
+}
+
+// Post an entry once. The file of a media entry is streamed from storage
+// into the request as it downloads instead of being held in memory.
+func (c *LogClient) post(ctx context.Context, entry Entry) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var file io.ReadCloser
+	if entry.Kind == KindMedia && c.cfg.Fields.File != "" {
+		var err error
+		if file, err = c.openFile(ctx, entry); err != nil {
+			return err
+		}
+		file = newStallReader(file, c.cfg.Timeout, cancel)
+		defer file.Close()
+	}
+
+	body, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	written := make(chan error, 1)
+	go func() {
+		err := c.writeForm(writer, entry, file)
+		pw.CloseWithError(err)
+		written <- err
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.Endpoint, body)
+	if err != nil {
+		body.Close()
+		<-written
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+
+	resp, err := c.httpClient.Do(req)
+	// Unblock the writer if the request ended before the form was sent
+	body.Close()
+	writeErr := <-written
+	var fileErr *FileError
+	if errors.As(writeErr, &fileErr) {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return writeErr
+	}
+	if err != nil {
+		return &RequestError{Err: err}
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return &APIError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	if writeErr != nil {
+		return &RequestError{Err: fmt.Errorf("log API answered before the form was sent: %w", writeErr)}
+	}
+	return nil
+}
+
+// stallReader aborts a transfer once no data arrived for the timeout, which
+// bounds stalled downloads and uploads without limiting how long a large file
+// may take overall
+type stallReader struct {
+	io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	stalled atomic.Bool
+}
+
+func newStallReader(r io.ReadCloser, timeout time.Duration, abort func()) *stallReader {
+	s := &stallReader{ReadCloser: r, timeout: timeout}
+	s.timer = time.AfterFunc(timeout, func() {
+		s.stalled.Store(true)
+		abort()
+	})
+	return s
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	switch {
+	case err == io.EOF:
+		s.timer.Stop()
+	case err != nil && s.stalled.Load():
+		err = fmt.Errorf("no data received for %v", s.timeout)
+	default:
+		s.timer.Reset(s.timeout)
+	}
+	return n, err
+}
+
+func (s *stallReader) Close() error {
+	s.timer.Stop()
+	return s.ReadCloser.Close()
+}
+
+// Write the form of an entry, leaving out fields with no name mapped
+func (c *LogClient) writeForm(writer *multipart.Writer, entry Entry, file io.Reader) error {
+	f := c.cfg.Fields
+	// Status updates only refer to the message they are about
+	text, parentMessageID := f.Text, f.ParentMessageID
+	if entry.Kind == KindStatus {
+		text, parentMessageID = "", ""
+	}
+	fields := [][2]string{
+		{f.From, entry.From},
+		{f.To, entry.To},
+		{text, entry.Text},
+		{f.Status, entry.Status},
+		{f.Time, strconv.FormatInt(entry.Time.UnixMilli(), 10)},
+		{f.AdminPhone, entry.AdminPhone},
+		{f.MessageID, entry.MessageID},
+		{parentMessageID, entry.ParentMessageID},
+	}
+	for _, field := range fields {
+		if field[0] == "" {
+			continue
+		}
+		if err := writer.WriteField(field[0], field[1]); err != nil {
+			return err
+		}
+	}
+
+	if file != nil {
+		part, err := writer.CreateFormFile(f.File, path.Base(entry.FileURL))
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(part, file)
+		if errors.Is(err, io.ErrClosedPipe) {
+			return err
+		} else if err != nil {
+			return &FileError{URL: entry.FileURL, Err: err}
+		}
+		if entry.FileLength > 0 && uint64(n) != entry.FileLength {
+			return &FileError{URL: entry.FileURL, Err: sizeMismatch(entry.FileLength, n)}
+		}
+	}
+	return writer.Close()
+}
@@ -0,0 +1,168 @@
+package logfunction
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Start a log API that counts requests and records the size of uploaded files
+func newTestAPI(t *testing.T, status int) (*httptest.Server, *int32, *int64) {
+	t.Helper()
+	var calls int32
+	var fileSize int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if files := r.MultipartForm.File["files"]; len(files) == 1 {
+			atomic.StoreInt64(&fileSize, files[0].Size)
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls, &fileSize
+}
+
+func newTestClient(t *testing.T, endpoint string, timeout time.Duration) *LogClient {
+	t.Helper()
+	client, err := NewLogClient(Config{
+		Endpoint: endpoint,
+		Token:    "token",
+		Timeout:  timeout,
+		Retry:    RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond},
+		Fields:   DefaultFieldMapping(),
+	})
+	if err != nil {
+		t.Fatalf("NewLogClient: %v", err)
+	}
+	return client
+}
+
+func TestNewLogClientNeedsToken(t *testing.T) {
+	if _, err := NewLogClient(Config{Endpoint: "http://example.com"}); !errors.Is(err, ErrNoToken) {
+		t.Errorf("NewLogClient without token = %v, want ErrNoToken", err)
+	}
+}
+
+func TestLogRetriesServerErrorsOnly(t *testing.T) {
+	tests := []struct {
+		status    int
+		wantCalls int32
+	}{
+		{http.StatusOK, 1},
+		{http.StatusServiceUnavailable, 3},
+		{http.StatusBadRequest, 1},
+	}
+	for _, tt := range tests {
+		api, calls, _ := newTestAPI(t, tt.status)
+		err := newTestClient(t, api.URL, time.Second).Log(context.Background(), Entry{Kind: KindMessage, Text: "hi"})
+		if (err == nil) != (tt.status == http.StatusOK) {
+			t.Errorf("status %d: Log error = %v", tt.status, err)
+		}
+		if got := atomic.LoadInt32(calls); got != tt.wantCalls {
+			t.Errorf("status %d: %d calls, want %d", tt.status, got, tt.wantCalls)
+		}
+	}
+}
+
+func TestLogMediaFileErrors(t *testing.T) {
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing.jpg":
+			http.NotFound(w, r)
+		case "/busy.jpg":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("12345"))
+		}
+	}))
+	defer storage.Close()
+
+	tests := []struct {
+		name      string
+		path      string
+		length    uint64
+		wantErr   bool
+		retryable bool
+	}{
+		{"ok", "/a.jpg", 5, false, false},
+		{"unknown length", "/a.jpg", 0, false, false},
+		{"size mismatch", "/a.jpg", 9, true, false},
+		{"not found", "/missing.jpg", 0, true, false},
+		{"unavailable", "/busy.jpg", 0, true, true},
+	}
+	for _, tt := range tests {
+		api, calls, fileSize := newTestAPI(t, http.StatusOK)
+		err := newTestClient(t, api.URL, time.Second).Log(context.Background(),
+			Entry{Kind: KindMedia, FileURL: storage.URL + tt.path, FileLength: tt.length})
+		if tt.wantErr {
+			var fileErr *FileError
+			if !errors.As(err, &fileErr) {
+				t.Errorf("%s: Log error = %v, want a FileError", tt.name, err)
+			}
+			if Retryable(err) != tt.retryable {
+				t.Errorf("%s: retryable = %v, want %v", tt.name, !tt.retryable, tt.retryable)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Log error = %v", tt.name, err)
+		}
+		if atomic.LoadInt32(calls) != 1 || atomic.LoadInt64(fileSize) != 5 {
+			t.Errorf("%s: API got %d calls with a %d byte file", tt.name, *calls, *fileSize)
+		}
+	}
+}
+
+func TestLogStreamsSlowFilesPastTimeout(t *testing.T) {
+	// The file takes three times the timeout but never pauses for long
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 15; i++ {
+			w.Write([]byte(strings.Repeat("x", 1000)))
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+	}))
+	defer storage.Close()
+	api, _, fileSize := newTestAPI(t, http.StatusOK)
+
+	err := newTestClient(t, api.URL, 100*time.Millisecond).Log(context.Background(),
+		Entry{Kind: KindMedia, FileURL: storage.URL + "/video.mp4", FileLength: 15000})
+	if err != nil {
+		t.Fatalf("Log error = %v", err)
+	}
+	if got := atomic.LoadInt64(fileSize); got != 15000 {
+		t.Errorf("API got %d bytes, want 15000", got)
+	}
+}
+
+func TestLogAbortsStalledFiles(t *testing.T) {
+	release := make(chan struct{})
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer storage.Close()
+	defer close(release)
+	api, _, _ := newTestAPI(t, http.StatusOK)
+
+	client := newTestClient(t, api.URL, 100*time.Millisecond)
+	client.cfg.Retry.MaxAttempts = 1
+	err := client.Log(context.Background(), Entry{Kind: KindMedia, FileURL: storage.URL + "/stuck.mp4"})
+	var fileErr *FileError
+	if !errors.As(err, &fileErr) || !strings.Contains(err.Error(), "no data received") {
+		t.Errorf("Log error = %v, want a stalled FileError", err)
+	}
+}
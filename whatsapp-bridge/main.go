@@ -3,10 +3,11 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"os/signal"
@@ -22,7 +23,6 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/joho/godotenv"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/mdp/qrterminal"
@@ -40,15 +40,54 @@ var qrCodeStr string
 
 // Message represents a chat message for our client
 type Message struct {
-	Time     time.Time
-	Sender   string
-	Content  string
-	IsFromMe bool
+	ID       string    `json:"id"`
+	ChatJID  string    `json:"chat_jid"`
+	Time     time.Time `json:"timestamp"`
+	Sender   string    `json:"sender"`
+	Content  string    `json:"content"`
+	IsFromMe bool      `json:"is_from_me"`
+	MediaInfo
+
+	ParentMessageID string   `json:"parent_message_id,omitempty"`
+	AdminPhone      string   `json:"admin_phone,omitempty"`
+	DeliveryStatus  string   `json:"delivery_status,omitempty"` // "pending", "delivered", "read", "played"
+	IsEdited        bool     `json:"is_edited,omitempty"`
+	IsDeleted       bool     `json:"is_deleted,omitempty"`
+	Mentions        []string `json:"mentions,omitempty"` // JIDs mentioned in the message
+}
+
+// MediaInfo holds the metadata of non-text messages. MediaType is empty for
+// plain text messages.
+type MediaInfo struct {
+	MediaType  string  `json:"media_type,omitempty"` // "image", "video", "audio", "document", "sticker", "location", "contact", "poll"
+	Mimetype   string  `json:"mimetype,omitempty"`
+	Filename   string  `json:"filename,omitempty"`
+	Caption    string  `json:"caption,omitempty"`
+	MediaURL   string  `json:"media_url,omitempty"` // S3 URL of the uploaded copy
+	FileSHA256 string  `json:"file_sha256,omitempty"`
+	FileLength uint64  `json:"file_length,omitempty"`
+	Latitude   float64 `json:"latitude,omitempty"`
+	Longitude  float64 `json:"longitude,omitempty"`
+	VCard      string  `json:"vcard,omitempty"`
+}
+
+// Chat represents a stored chat with its latest activity
+type Chat struct {
+	JID             string    `json:"jid"`
+	Name            string    `json:"name"`
+	LastMessageTime time.Time `json:"last_message_time"`
 }
 
 // Database handler for storing message history
 type MessageStore struct {
-	db *sql.DB
+	db            *sql.DB
+	searchEnabled bool
+}
+
+// execer is the part of *sql.DB and *sql.Tx that the store's writes use, so
+// that several writes can share a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
 }
 
 type CreateGroupRequest struct {
@@ -123,13 +162,197 @@ func NewMessageStore() (*MessageStore, error) {
 			PRIMARY KEY (id, chat_jid),
 			FOREIGN KEY (chat_jid) REFERENCES chats(jid)
 		);
+
+		CREATE TABLE IF NOT EXISTS receipts (
+			message_id TEXT,
+			chat_jid TEXT,
+			participant TEXT,
+			status TEXT,
+			timestamp TIMESTAMP,
+			PRIMARY KEY (message_id, chat_jid, participant, status)
+		);
+
+		CREATE TABLE IF NOT EXISTS reactions (
+			message_id TEXT,
+			chat_jid TEXT,
+			sender TEXT,
+			emoji TEXT,
+			timestamp TIMESTAMP,
+			PRIMARY KEY (message_id, chat_jid, sender)
+		);
+
+		CREATE TABLE IF NOT EXISTS message_revisions (
+			message_id TEXT,
+			chat_jid TEXT,
+			revision INTEGER,
+			content TEXT,
+			timestamp TIMESTAMP,
+			PRIMARY KEY (message_id, chat_jid, revision)
+		);
+
+		CREATE TABLE IF NOT EXISTS polls (
+			message_id TEXT,
+			chat_jid TEXT,
+			question TEXT,
+			selectable_count INTEGER,
+			PRIMARY KEY (message_id, chat_jid)
+		);
+
+		CREATE TABLE IF NOT EXISTS poll_options (
+			message_id TEXT,
+			chat_jid TEXT,
+			option_index INTEGER,
+			name TEXT,
+			option_hash TEXT,
+			PRIMARY KEY (message_id, chat_jid, option_index)
+		);
+
+		CREATE TABLE IF NOT EXISTS poll_votes (
+			message_id TEXT,
+			chat_jid TEXT,
+			voter TEXT,
+			option_hashes TEXT,
+			timestamp TIMESTAMP,
+			PRIMARY KEY (message_id, chat_jid, voter)
+		);
+
+		CREATE TABLE IF NOT EXISTS broadcasts (
+			id TEXT PRIMARY KEY,
+			status TEXT,
+			message TEXT,
+			media_type TEXT,
+			mimetype TEXT,
+			filename TEXT,
+			media BLOB,
+			admin_phone TEXT,
+			rate_per_minute REAL,
+			jitter_seconds REAL,
+			created_at TIMESTAMP,
+			completed_at TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS broadcast_recipients (
+			broadcast_id TEXT,
+			chat_jid TEXT,
+			recipient TEXT,
+			position INTEGER,
+			status TEXT,
+			message_id TEXT,
+			error TEXT,
+			sent_at TIMESTAMP,
+			PRIMARY KEY (broadcast_id, chat_jid),
+			FOREIGN KEY (broadcast_id) REFERENCES broadcasts(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS scheduled_messages (
+			id TEXT PRIMARY KEY,
+			recipient TEXT,
+			message TEXT,
+			media_type TEXT,
+			mimetype TEXT,
+			filename TEXT,
+			media BLOB,
+			admin_phone TEXT,
+			send_at TIMESTAMP,
+			cron TEXT,
+			timezone TEXT,
+			status TEXT,
+			next_run TIMESTAMP,
+			created_at TIMESTAMP,
+			updated_at TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS scheduled_runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			schedule_id TEXT,
+			scheduled_for TIMESTAMP,
+			ran_at TIMESTAMP,
+			status TEXT,
+			message_id TEXT,
+			error TEXT,
+			FOREIGN KEY (schedule_id) REFERENCES scheduled_messages(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS templates (
+			name TEXT PRIMARY KEY,
+			body TEXT,
+			required_variables TEXT,
+			description TEXT,
+			created_at TIMESTAMP,
+			updated_at TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS auto_reply_rules (
+			id TEXT PRIMARY KEY,
+			name TEXT,
+			disabled BOOLEAN,
+			priority INTEGER,
+			stop BOOLEAN,
+			match TEXT,
+			actions TEXT,
+			cooldown_seconds INTEGER,
+			created_at TIMESTAMP,
+			updated_at TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS business_hours (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			config TEXT,
+			updated_at TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS away_replies (
+			chat_jid TEXT PRIMARY KEY,
+			window_start TIMESTAMP,
+			sent_at TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS outbox (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			event TEXT,
+			media_path TEXT,
+			media_key TEXT,
+			chat_jid TEXT,
+			media_source BLOB,
+			status TEXT,
+			attempts INTEGER,
+			last_error TEXT,
+			next_attempt_at TIMESTAMP,
+			created_at TIMESTAMP,
+			updated_at TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_outbox_status ON outbox (status, next_attempt_at);
 	`)
 	if err != nil {
 		db.Close()
 		return nil, fmt.Errorf("failed to create tables: %v", err)
 	}
 
-	return &MessageStore{db: db}, nil
+	store := &MessageStore{db: db}
+
+	// Bring databases created by older versions up to date
+	if err := store.addMissingColumns("messages", messageColumnMigrations); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate messages table: %v", err)
+	}
+	if err := store.addMissingColumns("outbox", outboxColumnMigrations); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate outbox table: %v", err)
+	}
+	if err := store.normalizeTimestamps(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate timestamps: %v", err)
+	}
+
+	// Full-text search needs SQLite built with FTS5 (go build -tags sqlite_fts5)
+	if err := store.initFullTextSearch(); err != nil {
+		fmt.Println("⚠️ Full-text search disabled:", err)
+	} else {
+		store.searchEnabled = true
+	}
+
+	return store, nil
 }
 
 // Close the database connection
@@ -141,88 +364,510 @@ func (store *MessageStore) Close() error {
 func (store *MessageStore) StoreChat(jid, name string, lastMessageTime time.Time) error {
 	_, err := store.db.Exec(
 		"INSERT OR REPLACE INTO chats (jid, name, last_message_time) VALUES (?, ?, ?)",
-		jid, name, lastMessageTime,
+		jid, name, lastMessageTime.UTC(),
+	)
+	return err
+}
+
+// Columns added to the messages table after its original schema, in order
+var messageColumnMigrations = []struct{ Name, Definition string }{
+	{"media_type", "TEXT NOT NULL DEFAULT ''"},
+	{"mimetype", "TEXT NOT NULL DEFAULT ''"},
+	{"filename", "TEXT NOT NULL DEFAULT ''"},
+	{"caption", "TEXT NOT NULL DEFAULT ''"},
+	{"media_url", "TEXT NOT NULL DEFAULT ''"},
+	{"file_sha256", "TEXT NOT NULL DEFAULT ''"},
+	{"file_length", "INTEGER NOT NULL DEFAULT 0"},
+	{"latitude", "REAL NOT NULL DEFAULT 0"},
+	{"longitude", "REAL NOT NULL DEFAULT 0"},
+	{"vcard", "TEXT NOT NULL DEFAULT ''"},
+	{"parent_message_id", "TEXT NOT NULL DEFAULT ''"},
+	{"admin_phone", "TEXT NOT NULL DEFAULT ''"},
+	{"delivery_status", "TEXT NOT NULL DEFAULT ''"},
+	{"is_edited", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"is_deleted", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"mentions", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// Columns added to the outbox table after its original schema, in order
+var outboxColumnMigrations = []struct{ Name, Definition string }{
+	{"media_source", "BLOB"},
+}
+
+// Add any of the given columns that the table does not have yet
+func (store *MessageStore) addMissingColumns(table string, columns []struct{ Name, Definition string }) error {
+	rows, err := store.db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		if existing[column.Name] {
+			continue
+		}
+		_, err := store.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column.Name, column.Definition))
+		if err != nil {
+			return fmt.Errorf("failed to add column %s: %v", column.Name, err)
+		}
+	}
+	return nil
+}
+
+// Times are stored in UTC so that SQLite, which compares them as text, orders
+// and filters them correctly. Rewrite the ones older versions stored with the
+// local offset.
+func (store *MessageStore) normalizeTimestamps() error {
+	for _, table := range []struct{ Name, Column string }{
+		{"messages", "timestamp"},
+		{"chats", "last_message_time"},
+		{"poll_votes", "timestamp"},
+	} {
+		rows, err := store.db.Query(fmt.Sprintf(
+			"SELECT rowid, %s FROM %s WHERE %s NOT LIKE '%%+00:00'",
+			table.Column, table.Name, table.Column,
+		))
+		if err != nil {
+			return err
+		}
+		times := make(map[int64]time.Time)
+		for rows.Next() {
+			var key int64
+			var value interface{}
+			if err := rows.Scan(&key, &value); err != nil {
+				rows.Close()
+				return err
+			}
+			// Leave values the driver cannot read as a time alone
+			if t, ok := value.(time.Time); ok {
+				times[key] = t
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(times) == 0 {
+			continue
+		}
+
+		tx, err := store.db.Begin()
+		if err != nil {
+			return err
+		}
+		for key, t := range times {
+			_, err := tx.Exec(fmt.Sprintf("UPDATE %s SET %s = ? WHERE rowid = ?", table.Name, table.Column), t.UTC(), key)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		fmt.Printf("Converted %d %s times to UTC\n", len(times), table.Name)
+	}
+	return nil
+}
+
+// Columns selected for every Message, in the order scanMessage expects
+var messageColumns = []string{
+	"id", "chat_jid", "sender", "content", "timestamp", "is_from_me",
+	"media_type", "mimetype", "filename", "caption", "media_url",
+	"file_sha256", "file_length", "latitude", "longitude", "vcard",
+	"parent_message_id", "admin_phone", "delivery_status", "is_edited", "is_deleted", "mentions",
+}
+
+// Build the select list for messageColumns, optionally qualified with a table alias
+func messageSelectList(alias string) string {
+	if alias == "" {
+		return strings.Join(messageColumns, ", ")
+	}
+	return alias + "." + strings.Join(messageColumns, ", "+alias+".")
+}
+
+// Scan a row selected with messageSelectList into msg, followed by any extra columns
+func scanMessage(row interface{ Scan(...interface{}) error }, msg *Message, extra ...interface{}) error {
+	var mentions string
+	dest := []interface{}{
+		&msg.ID, &msg.ChatJID, &msg.Sender, &msg.Content, &msg.Time, &msg.IsFromMe,
+		&msg.MediaType, &msg.Mimetype, &msg.Filename, &msg.Caption, &msg.MediaURL,
+		&msg.FileSHA256, &msg.FileLength, &msg.Latitude, &msg.Longitude, &msg.VCard,
+		&msg.ParentMessageID, &msg.AdminPhone, &msg.DeliveryStatus, &msg.IsEdited, &msg.IsDeleted, &mentions,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	if mentions != "" {
+		msg.Mentions = strings.Split(mentions, ",")
+	}
+	return nil
+}
+
+// Make sure a chat exists and bump its last message time without touching
+// its name, so GetChatName can still resolve a proper name later
+func (store *MessageStore) TouchChat(jid string, lastMessageTime time.Time) error {
+	return touchChat(store.db, jid, lastMessageTime)
+}
+
+// TouchChat on the database or a transaction
+func touchChat(db execer, jid string, lastMessageTime time.Time) error {
+	_, err := db.Exec(
+		`INSERT INTO chats (jid, name, last_message_time) VALUES (?, '', ?)
+		ON CONFLICT (jid) DO UPDATE SET last_message_time = excluded.last_message_time`,
+		jid, lastMessageTime.UTC(),
 	)
 	return err
 }
 
 // Store a message in the database
-func (store *MessageStore) StoreMessage(id, chatJID, sender, content string, timestamp time.Time, isFromMe bool) error {
+func (store *MessageStore) StoreMessage(msg Message) error {
+	return storeMessage(store.db, msg)
+}
+
+// StoreMessage on the database or a transaction
+func storeMessage(db execer, msg Message) error {
 	// Only store if there's actual content
-	if content == "" {
+	if msg.Content == "" && msg.MediaType == "" {
 		return nil
 	}
 
-	_, err := store.db.Exec(
-		"INSERT OR REPLACE INTO messages (id, chat_jid, sender, content, timestamp, is_from_me) VALUES (?, ?, ?, ?, ?, ?)",
-		id, chatJID, sender, content, timestamp, isFromMe,
+	// Upsert instead of REPLACE so the search index triggers see an update.
+	// Fields that a later copy of the message may not carry (such as the admin
+	// phone of a REST send or its delivery status) are only overwritten when set.
+	_, err := db.Exec(
+		`INSERT INTO messages (id, chat_jid, sender, content, timestamp, is_from_me,
+			media_type, mimetype, filename, caption, file_sha256, file_length, latitude, longitude, vcard,
+			parent_message_id, admin_phone, delivery_status, mentions)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id, chat_jid) DO UPDATE SET sender = excluded.sender, content = excluded.content,
+			timestamp = excluded.timestamp, is_from_me = excluded.is_from_me,
+			media_type = excluded.media_type, mimetype = excluded.mimetype, filename = excluded.filename,
+			caption = excluded.caption, file_sha256 = excluded.file_sha256, file_length = excluded.file_length,
+			latitude = excluded.latitude, longitude = excluded.longitude, vcard = excluded.vcard,
+			parent_message_id = COALESCE(NULLIF(excluded.parent_message_id, ''), messages.parent_message_id),
+			admin_phone = COALESCE(NULLIF(excluded.admin_phone, ''), messages.admin_phone),
+			delivery_status = COALESCE(NULLIF(messages.delivery_status, ''), excluded.delivery_status),
+			mentions = COALESCE(NULLIF(excluded.mentions, ''), messages.mentions)`,
+		msg.ID, msg.ChatJID, msg.Sender, msg.Content, msg.Time.UTC(), msg.IsFromMe,
+		msg.MediaType, msg.Mimetype, msg.Filename, msg.Caption, msg.FileSHA256, msg.FileLength,
+		msg.Latitude, msg.Longitude, msg.VCard,
+		msg.ParentMessageID, msg.AdminPhone, msg.DeliveryStatus, strings.Join(msg.Mentions, ","),
+	)
+	return err
+}
+
+// Order of delivery statuses; a receipt never moves a message backwards
+var deliveryStatusRank = map[string]int{
+	"":          0,
+	"pending":   1,
+	"delivered": 2,
+	"read":      3,
+	"played":    4,
+}
+
+// Advance the delivery status of our own messages. Receipts for direct chats
+// may address the chat by LID rather than phone number, so rows are matched
+// by message ID only.
+func (store *MessageStore) UpdateDeliveryStatus(ids []string, status string) error {
+	for _, id := range ids {
+		_, err := store.db.Exec(
+			`UPDATE messages SET delivery_status = ? WHERE id = ? AND is_from_me = 1 AND
+			CASE delivery_status WHEN 'pending' THEN 1 WHEN 'delivered' THEN 2 WHEN 'read' THEN 3 WHEN 'played' THEN 4 ELSE 0 END < ?`,
+			status, id, deliveryStatusRank[status],
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Record that a participant reached the given status for a message. Reports
+// whether this is a new transition rather than a repeated receipt.
+func (store *MessageStore) StoreReceipt(messageID, chatJID, participant, status string, timestamp time.Time) (bool, error) {
+	return storeReceipt(store.db, messageID, chatJID, participant, status, timestamp)
+}
+
+// StoreReceipt on the database or a transaction
+func storeReceipt(db execer, messageID, chatJID, participant, status string, timestamp time.Time) (bool, error) {
+	result, err := db.Exec(
+		"INSERT OR IGNORE INTO receipts (message_id, chat_jid, participant, status, timestamp) VALUES (?, ?, ?, ?, ?)",
+		messageID, chatJID, participant, status, timestamp.UTC(),
 	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// Record the S3 URL of a message's media once it has been uploaded
+func (store *MessageStore) SetMediaURL(id, chatJID, url string) error {
+	return setMediaURL(store.db, id, chatJID, url)
+}
+
+// SetMediaURL on the database or a transaction
+func setMediaURL(db execer, id, chatJID, url string) error {
+	_, err := db.Exec("UPDATE messages SET media_url = ? WHERE id = ? AND chat_jid = ?", url, id, chatJID)
 	return err
 }
 
-// Get messages from a chat
-func (store *MessageStore) GetMessages(chatJID string, limit int) ([]Message, error) {
+// MessageQuery holds the filters and cursor used when listing messages
+type MessageQuery struct {
+	Sender   string
+	IsFromMe *bool
+	Since    time.Time
+	Until    time.Time
+	Mentions []string // match messages mentioning any of these JIDs
+	Cursor   *PageCursor
+	Limit    int
+}
+
+// Get messages from a chat, or from all chats if chatJID is empty, newest first
+func (store *MessageStore) GetMessages(chatJID string, query MessageQuery) ([]Message, error) {
+	where := []string{"1 = 1"}
+	var args []interface{}
+
+	if chatJID != "" {
+		where = append(where, "chat_jid = ?")
+		args = append(args, chatJID)
+	}
+
+	if query.Sender != "" {
+		where = append(where, "sender = ?")
+		args = append(args, query.Sender)
+	}
+	if query.IsFromMe != nil {
+		where = append(where, "is_from_me = ?")
+		args = append(args, *query.IsFromMe)
+	}
+	if !query.Since.IsZero() {
+		where = append(where, "timestamp >= ?")
+		args = append(args, query.Since.UTC())
+	}
+	if !query.Until.IsZero() {
+		where = append(where, "timestamp <= ?")
+		args = append(args, query.Until.UTC())
+	}
+	if len(query.Mentions) > 0 {
+		var any []string
+		for _, jid := range query.Mentions {
+			any = append(any, "(',' || mentions || ',') LIKE ?")
+			args = append(args, "%,"+jid+",%")
+		}
+		where = append(where, "("+strings.Join(any, " OR ")+")")
+	}
+	if query.Cursor != nil {
+		where = append(where, "(timestamp < ? OR (timestamp = ? AND id < ?))")
+		args = append(args, query.Cursor.Time.UTC(), query.Cursor.Time.UTC(), query.Cursor.ID)
+	}
+	args = append(args, query.Limit)
+
 	rows, err := store.db.Query(
-		"SELECT sender, content, timestamp, is_from_me FROM messages WHERE chat_jid = ? ORDER BY timestamp DESC LIMIT ?",
-		chatJID, limit,
+		"SELECT "+messageSelectList("")+" FROM messages WHERE "+strings.Join(where, " AND ")+" ORDER BY timestamp DESC, id DESC LIMIT ?",
+		args...,
 	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var messages []Message
+	messages := []Message{}
 	for rows.Next() {
 		var msg Message
-		var timestamp time.Time
-		err := rows.Scan(&msg.Sender, &msg.Content, &timestamp, &msg.IsFromMe)
-		if err != nil {
+		if err := scanMessage(rows, &msg); err != nil {
 			return nil, err
 		}
-		msg.Time = timestamp
 		messages = append(messages, msg)
 	}
 
-	return messages, nil
+	return messages, rows.Err()
+}
+
+// Get a single message by ID. Returns sql.ErrNoRows if it is not stored.
+func (store *MessageStore) GetMessage(id, chatJID string) (Message, error) {
+	var msg Message
+	row := store.db.QueryRow("SELECT "+messageSelectList("")+" FROM messages WHERE id = ? AND chat_jid = ?", id, chatJID)
+	err := scanMessage(row, &msg)
+	return msg, err
 }
 
-// Get all chats
-func (store *MessageStore) GetChats() (map[string]time.Time, error) {
-	rows, err := store.db.Query("SELECT jid, last_message_time FROM chats ORDER BY last_message_time DESC")
+// Get chats ordered by latest activity, starting after the given cursor
+func (store *MessageStore) GetChats(cursor *PageCursor, limit int) ([]Chat, error) {
+	query := "SELECT jid, COALESCE(name, ''), last_message_time FROM chats"
+	var args []interface{}
+	if cursor != nil {
+		query += " WHERE (last_message_time < ? OR (last_message_time = ? AND jid < ?))"
+		args = append(args, cursor.Time.UTC(), cursor.Time.UTC(), cursor.ID)
+	}
+	query += " ORDER BY last_message_time DESC, jid DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := store.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	chats := make(map[string]time.Time)
+	chats := []Chat{}
 	for rows.Next() {
-		var jid string
-		var lastMessageTime time.Time
-		err := rows.Scan(&jid, &lastMessageTime)
+		var chat Chat
+		err := rows.Scan(&chat.JID, &chat.Name, &chat.LastMessageTime)
 		if err != nil {
 			return nil, err
 		}
-		chats[jid] = lastMessageTime
+		chats = append(chats, chat)
 	}
 
-	return chats, nil
+	return chats, rows.Err()
 }
 
-// Extract text content from a message
-func extractTextContent(msg *waProto.Message) string {
+// Extract the text content and media metadata from a message. For media
+// messages the content is the caption (or a description for locations and
+// contacts) so that it stays searchable.
+func extractMessageContent(msg *waProto.Message) (string, MediaInfo) {
 	if msg == nil {
-		return ""
+		return "", MediaInfo{}
 	}
 
-	// Try to get text content
 	if text := msg.GetConversation(); text != "" {
-		return text
+		return text, MediaInfo{}
 	} else if extendedText := msg.GetExtendedTextMessage(); extendedText != nil {
-		return extendedText.GetText()
+		return extendedText.GetText(), MediaInfo{}
+	}
+
+	if image := msg.GetImageMessage(); image != nil {
+		return image.GetCaption(), MediaInfo{
+			MediaType:  "image",
+			Mimetype:   image.GetMimetype(),
+			Caption:    image.GetCaption(),
+			FileSHA256: hex.EncodeToString(image.GetFileSHA256()),
+			FileLength: image.GetFileLength(),
+		}
+	}
+
+	if video := msg.GetVideoMessage(); video != nil {
+		return video.GetCaption(), MediaInfo{
+			MediaType:  "video",
+			Mimetype:   video.GetMimetype(),
+			Caption:    video.GetCaption(),
+			FileSHA256: hex.EncodeToString(video.GetFileSHA256()),
+			FileLength: video.GetFileLength(),
+		}
+	}
+
+	if audio := msg.GetAudioMessage(); audio != nil {
+		return "", MediaInfo{
+			MediaType:  "audio",
+			Mimetype:   audio.GetMimetype(),
+			FileSHA256: hex.EncodeToString(audio.GetFileSHA256()),
+			FileLength: audio.GetFileLength(),
+		}
 	}
 
-	// For now, we're ignoring non-text messages
-	return ""
+	if sticker := msg.GetStickerMessage(); sticker != nil {
+		return "", MediaInfo{
+			MediaType:  "sticker",
+			Mimetype:   sticker.GetMimetype(),
+			FileSHA256: hex.EncodeToString(sticker.GetFileSHA256()),
+			FileLength: sticker.GetFileLength(),
+		}
+	}
+
+	if document := msg.GetDocumentMessage(); document != nil {
+		return document.GetCaption(), MediaInfo{
+			MediaType:  "document",
+			Mimetype:   document.GetMimetype(),
+			Filename:   document.GetFileName(),
+			Caption:    document.GetCaption(),
+			FileSHA256: hex.EncodeToString(document.GetFileSHA256()),
+			FileLength: document.GetFileLength(),
+		}
+	}
+
+	if location := msg.GetLocationMessage(); location != nil {
+		content := strings.TrimSpace(location.GetName() + " " + location.GetAddress())
+		if content == "" {
+			content = fmt.Sprintf("%f,%f", location.GetDegreesLatitude(), location.GetDegreesLongitude())
+		}
+		return content, MediaInfo{
+			MediaType: "location",
+			Latitude:  location.GetDegreesLatitude(),
+			Longitude: location.GetDegreesLongitude(),
+		}
+	}
+
+	if contact := msg.GetContactMessage(); contact != nil {
+		name, number := parseVCard(contact.GetVcard())
+		return strings.TrimSpace(name + " " + number), MediaInfo{
+			MediaType: "contact",
+			VCard:     contact.GetVcard(),
+		}
+	}
+
+	if contacts := msg.GetContactsArrayMessage(); contacts != nil {
+		var names, vcards []string
+		for _, contact := range contacts.GetContacts() {
+			name, number := parseVCard(contact.GetVcard())
+			names = append(names, strings.TrimSpace(name+" "+number))
+			vcards = append(vcards, contact.GetVcard())
+		}
+		return strings.Join(names, ", "), MediaInfo{
+			MediaType: "contact",
+			VCard:     strings.Join(vcards, "\n"),
+		}
+	}
+
+	if poll := getPollCreation(msg); poll != nil {
+		var options []string
+		for _, option := range poll.GetOptions() {
+			options = append(options, option.GetOptionName())
+		}
+		return poll.GetName() + ": " + strings.Join(options, ", "), MediaInfo{
+			MediaType: "poll",
+		}
+	}
+
+	return "", MediaInfo{}
+}
+
+// Get the context info (reply and mention metadata) of whichever message type is set
+func getContextInfo(msg *waE2E.Message) *waE2E.ContextInfo {
+	if m := msg.GetExtendedTextMessage(); m != nil {
+		return m.GetContextInfo()
+	} else if m := msg.GetImageMessage(); m != nil {
+		return m.GetContextInfo()
+	} else if m := msg.GetDocumentMessage(); m != nil {
+		return m.GetContextInfo()
+	} else if m := msg.GetContactMessage(); m != nil {
+		return m.GetContextInfo()
+	} else if m := msg.GetLocationMessage(); m != nil {
+		return m.GetContextInfo()
+	} else if m := msg.GetVideoMessage(); m != nil {
+		return m.GetContextInfo()
+	} else if m := msg.GetAudioMessage(); m != nil {
+		return m.GetContextInfo()
+	} else if m := msg.GetContactsArrayMessage(); m != nil {
+		return m.GetContextInfo()
+	} else if m := msg.GetStickerMessage(); m != nil {
+		return m.GetContextInfo()
+	} else if m := getPollCreation(msg); m != nil {
+		return m.GetContextInfo()
+	}
+	return nil
 }
 
 // SendMessageResponse represents the response for the send message API
@@ -233,15 +878,18 @@ type SendMessageResponse struct {
 
 // SendMessageRequest represents the request body for the send message API
 type SendMessageRequest struct {
-	Recipient       string `json:"recipient"`
-	Message         string `json:"message"`
-	AdminPhone      string `json:"admin_phone"`
-	ParentMessageID string `json:"wa_parent_message_id"`
+	Recipient       string   `json:"recipient"`
+	Message         string   `json:"message"`
+	AdminPhone      string   `json:"admin_phone"`
+	ParentMessageID string   `json:"wa_parent_message_id"`
+	RequireParent   bool     `json:"require_parent"` // fail instead of sending a bare reply when the parent is unknown
+	Mentions        []string `json:"mentions"`       // phone numbers or JIDs of group participants to mention
 }
 
 type SendMessageResponseWithLog struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
+	MessageID string `json:"message_id,omitempty"`
 }
 
 // RevokeMessageRequest defines the structure for the delete request
@@ -309,46 +957,26 @@ func revokeMessageHandler(client *whatsmeow.Client) http.HandlerFunc {
 	}
 }
 
-// Function to send a WhatsApp message
-func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message string, parentMessageID string) (bool, string, string, string) {
+// Function to send a WhatsApp message. contextInfo carries reply and mention
+// metadata and may be nil.
+func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message string, contextInfo *waE2E.ContextInfo) (bool, string, string, string) {
 	if !client.IsConnected() {
 		return false, "Not connected to WhatsApp", "", ""
 	}
 
-	// Create JID for recipient
-	var recipientJID types.JID
-	var err error
-
-	// Check if recipient is a JID
-	isJID := strings.Contains(recipient, "@")
-
-	if isJID {
-		// Parse the JID string
-		recipientJID, err = types.ParseJID(recipient)
-		if err != nil {
-			return false, fmt.Sprintf("Error parsing JID: %v", err), "", ""
-		}
-	} else {
-		server := "s.whatsapp.net" // Default server for personal chats
-		if strings.Contains(recipient, "-") {
-			server = "g.us" // Group chats use g.us
-		}
-
-		recipientJID = types.JID{
-			User:   recipient,
-			Server: server,
-		}
+	// Create JID for recipient (full JID, phone number or group ID)
+	recipientJID, err := parseChatJID(recipient)
+	if err != nil {
+		return false, fmt.Sprintf("Error parsing JID: %v", err), "", ""
 	}
 
 	// Create the message to send
 	msgToSend := &waProto.Message{}
-	if parentMessageID != "" {
+	if contextInfo != nil {
 		msgToSend = &waProto.Message{
 			ExtendedTextMessage: &waProto.ExtendedTextMessage{
-				Text: proto.String(message),
-				ContextInfo: &waProto.ContextInfo{
-					StanzaID: &parentMessageID,
-				},
+				Text:        proto.String(message),
+				ContextInfo: contextInfo,
 			},
 		}
 	} else {
@@ -357,46 +985,23 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 		}
 	}
 
-	if err != nil {
-		return false, fmt.Sprintf("Error sending message: %v", err), "", ""
-	}
-
 	resp, err := client.SendMessage(context.Background(), recipientJID, msgToSend)
 	if err != nil {
 		return false, fmt.Sprintf("Error sending message: %v", err), "", ""
 	}
 
-	return true, fmt.Sprintf("Message sent to %s", recipient), resp.ID, parentMessageID
+	return true, fmt.Sprintf("Message sent to %s", recipient), resp.ID, contextInfo.GetStanzaID()
 }
 
-func sendWhatsAppImageMessage(client *whatsmeow.Client, recipient string, message string, image []byte, parentMessageID string) (bool, string, string, string) {
+func sendWhatsAppImageMessage(client *whatsmeow.Client, recipient string, message string, image []byte, mimeType string, contextInfo *waE2E.ContextInfo) (bool, string, string, string) {
 	if !client.IsConnected() {
 		return false, "Not connected to WhatsApp", "", ""
 	}
 
-	// Create JID for recipient
-	var recipientJID types.JID
-	var err error
-
-	// Check if recipient is a JID
-	isJID := strings.Contains(recipient, "@")
-
-	if isJID {
-		// Parse the JID string
-		recipientJID, err = types.ParseJID(recipient)
-		if err != nil {
-			return false, fmt.Sprintf("Error parsing JID: %v", err), "", ""
-		}
-	} else {
-		server := "s.whatsapp.net" // Default server for personal chats
-		if strings.Contains(recipient, "-") {
-			server = "g.us" // Group chats use g.us
-		}
-		// Create JID from phone number
-		recipientJID = types.JID{
-			User:   recipient,
-			Server: server,
-		}
+	// Create JID for recipient (full JID, phone number or group ID)
+	recipientJID, err := parseChatJID(recipient)
+	if err != nil {
+		return false, fmt.Sprintf("Error parsing JID: %v", err), "", ""
 	}
 
 	resp, err := client.Upload(context.Background(), image, whatsmeow.MediaImage)
@@ -405,11 +1010,21 @@ func sendWhatsAppImageMessage(client *whatsmeow.Client, recipient string, messag
 		return false, fmt.Sprintf("Error uploading image: %v", err), "", ""
 	}
 
+	sendResp, err := client.SendMessage(context.Background(), recipientJID, imageMessage(resp, message, mimeType, contextInfo))
+
+	if err != nil {
+		return false, fmt.Sprintf("Error sending image message: %v", err), "", ""
+	}
+
+	return true, fmt.Sprintf("Image message sent to %s", recipient), sendResp.ID, contextInfo.GetStanzaID()
+}
+
+// Build an image message for an image already uploaded to WhatsApp
+func imageMessage(resp whatsmeow.UploadResponse, message string, mimeType string, contextInfo *waE2E.ContextInfo) *waE2E.Message {
 	imageMsg := &waE2E.ImageMessage{
 		Caption: proto.String(message),
-		// Mimetype: proto.String("image/png"), // replace this with the actual mime type
 		// you can also optionally add other fields like ContextInfo and JpegThumbnail here
-		Mimetype:      proto.String(http.DetectContentType(image)),
+		Mimetype:      proto.String(mimeType),
 		URL:           &resp.URL,
 		DirectPath:    &resp.DirectPath,
 		MediaKey:      resp.MediaKey,
@@ -418,51 +1033,20 @@ func sendWhatsAppImageMessage(client *whatsmeow.Client, recipient string, messag
 		FileLength:    &resp.FileLength,
 	}
 
-	if parentMessageID != "" {
-		imageMsg.ContextInfo = &waE2E.ContextInfo{
-			StanzaID: &parentMessageID,
-		}
-	}
-
-	sendResp, err := client.SendMessage(context.Background(), recipientJID, &waE2E.Message{
-		ImageMessage: imageMsg,
-	})
-
-	if err != nil {
-		return false, fmt.Sprintf("Error sending image message: %v", err), "", ""
-	}
+	imageMsg.ContextInfo = contextInfo
 
-	return true, fmt.Sprintf("Image message sent to %s", recipient), sendResp.ID, parentMessageID
+	return &waE2E.Message{ImageMessage: imageMsg}
 }
 
-func sendWhatsAppDocumentMessage(client *whatsmeow.Client, recipient string, message string, document []byte, fileName string, mimeType string, parentMessageID string) (bool, string, string, string) {
+func sendWhatsAppDocumentMessage(client *whatsmeow.Client, recipient string, message string, document []byte, fileName string, mimeType string, contextInfo *waE2E.ContextInfo) (bool, string, string, string) {
 	if !client.IsConnected() {
 		return false, "Not connected to WhatsApp", "", ""
 	}
 
-	// Create JID for recipient
-	var recipientJID types.JID
-	var err error
-
-	// Check if recipient is a JID
-	isJID := strings.Contains(recipient, "@")
-
-	if isJID {
-		// Parse the JID string
-		recipientJID, err = types.ParseJID(recipient)
-		if err != nil {
-			return false, fmt.Sprintf("Error parsing JID: %v", err), "", ""
-		}
-	} else {
-		server := "s.whatsapp.net" // Default server for personal chats
-		if strings.Contains(recipient, "-") {
-			server = "g.us" // Group chats use g.us
-		}
-		// Create JID from phone number
-		recipientJID = types.JID{
-			User:   recipient,
-			Server: server,
-		}
+	// Create JID for recipient (full JID, phone number or group ID)
+	recipientJID, err := parseChatJID(recipient)
+	if err != nil {
+		return false, fmt.Sprintf("Error parsing JID: %v", err), "", ""
 	}
 
 	resp, err := client.Upload(context.Background(), document, whatsmeow.MediaDocument)
@@ -470,6 +1054,18 @@ func sendWhatsAppDocumentMessage(client *whatsmeow.Client, recipient string, mes
 		return false, fmt.Sprintf("Error uploading document: %v", err), "", ""
 	}
 
+	// Send the document message
+	sendResp, err := client.SendMessage(context.Background(), recipientJID, documentMessage(resp, message, fileName, mimeType, contextInfo))
+
+	if err != nil {
+		return false, fmt.Sprintf("Error sending document message: %v", err), "", ""
+	}
+
+	return true, fmt.Sprintf("Document message sent to %s", recipient), sendResp.ID, contextInfo.GetStanzaID()
+}
+
+// Build a document message for a document already uploaded to WhatsApp
+func documentMessage(resp whatsmeow.UploadResponse, message string, fileName string, mimeType string, contextInfo *waE2E.ContextInfo) *waE2E.Message {
 	docMsg := &waE2E.DocumentMessage{
 		Title:         proto.String(fileName),
 		FileName:      proto.String(fileName),
@@ -483,22 +1079,71 @@ func sendWhatsAppDocumentMessage(client *whatsmeow.Client, recipient string, mes
 		FileLength:    &resp.FileLength,
 	}
 
-	if parentMessageID != "" {
-		docMsg.ContextInfo = &waE2E.ContextInfo{
-			StanzaID: &parentMessageID,
-		}
-	}
+	docMsg.ContextInfo = contextInfo
 
-	// Send the document message
-	sendResp, err := client.SendMessage(context.Background(), recipientJID, &waE2E.Message{
-		DocumentMessage: docMsg,
-	})
+	return &waE2E.Message{DocumentMessage: docMsg}
+}
 
+// Persist a message we just sent through the REST API so local history
+// includes our outbound traffic. The row starts as "pending" and is advanced
+// by later receipts. db is the database or a transaction.
+func storeSentMessage(db execer, recipient string, msg Message) error {
+	chatJID, err := parseChatJID(recipient)
 	if err != nil {
-		return false, fmt.Sprintf("Error sending document message: %v", err), "", ""
+		return err
 	}
+	msg.ChatJID = chatJID.String()
+	msg.IsFromMe = true
+	msg.DeliveryStatus = "pending"
 
-	return true, fmt.Sprintf("Document message sent to %s", recipient), sendResp.ID, parentMessageID
+	if err := touchChat(db, msg.ChatJID, msg.Time); err != nil {
+		return err
+	}
+	if err := storeMessage(db, msg); err != nil {
+		return err
+	}
+	_, err = storeReceipt(db, msg.ID, msg.ChatJID, "", "sent", msg.Time)
+	return err
+}
+
+// Send a text message and log it: the message is stored locally and queued
+// for the log API. queueMsg may carry extra fields for the queue entry, such
+// as the template a message was rendered from.
+func sendTextMessage(client *whatsmeow.Client, messageStore *MessageStore, eventSink EventSink,
+	req SendMessageRequest, contextInfo *waE2E.ContextInfo, queueMsg WALogMessageForQueue) (bool, string, string) {
+	success, msg, msgID, parentMsgID := sendWhatsAppMessage(client, req.Recipient, req.Message, contextInfo)
+	fmt.Println("Message sent", success, msg, msgID)
+	if !success {
+		return false, msg, ""
+	}
+
+	senderPhone := client.Store.ID.User
+	msgTime := time.Now()
+
+	queueMsg.Type = "text"
+	queueMsg.From = senderPhone
+	queueMsg.To = req.Recipient
+	queueMsg.AdminPhone = req.AdminPhone
+	queueMsg.Message = req.Message
+	queueMsg.Time = msgTime
+	queueMsg.MessageID = msgID
+	queueMsg.ParentMessageID = parentMsgID
+	queueMsg.Status = "SENT"
+	err := logSentMessage(messageStore, eventSink, req.Recipient, Message{
+		ID:              msgID,
+		Sender:          senderPhone,
+		Content:         req.Message,
+		Time:            msgTime,
+		ParentMessageID: parentMsgID,
+		AdminPhone:      req.AdminPhone,
+		Mentions:        contextInfo.GetMentionedJID(),
+	}, nil, queueMsg)
+	if err != nil {
+		logger.Error("Failed to send message to event sinks:", err)
+	} else {
+		logger.Info("Message sent to event sinks successfully")
+	}
+	return true, msg, msgID
 }
 
 func createWhatsAppGroup(client *whatsmeow.Client, req CreateGroupRequest) (CreateGroupResponse, error) {
@@ -569,7 +1214,7 @@ func createWhatsAppGroup(client *whatsmeow.Client, req CreateGroupRequest) (Crea
 }
 
 // Start a REST API server to expose the WhatsApp client functionality
-func startRESTServer(client *whatsmeow.Client, sqsClient *sqs.Client, queueURL string, port int) {
+func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, eventSink EventSink, autoReplies *AutoReplyEngine, awayResponder *AwayResponder, broadcastWorker *BroadcastWorker, port int) {
 	// Handler for getting login status
 	http.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -650,42 +1295,20 @@ func startRESTServer(client *whatsmeow.Client, sqsClient *sqs.Client, queueURL s
 			return
 		}
 
-		// Send the message
-		success, msg, msgID, parentMsgID := sendWhatsAppMessage(client, req.Recipient, req.Message, req.ParentMessageID)
-		fmt.Println("Message sent", success, msg, msgID)
-
-		// Log the message
-		if success {
-			senderPhone := client.Store.ID.User
-			recipientPhone := req.Recipient
-			msgTime := time.Now()
-			adminPhone := req.AdminPhone
-			// err := logfunction.LogMessage(senderPhone, req.Message, recipientPhone, msgTime)
-			// if err != nil {
-			// 	fmt.Println("⚠️ Failed to log message:", err)
-			// 	messageLogged = "Failed to log message"
-			// } else {
-			// 	fmt.Println("✅ Message logged successfully")
-			// 	messageLogged = "Message logged successfully"
-			// }
-			err := sendMessageToQueue(WALogMessageForQueue{
-				Type:            "text",
-				From:            senderPhone,
-				To:              recipientPhone,
-				AdminPhone:      adminPhone,
-				Message:         req.Message,
-				File:            "",
-				Time:            msgTime,
-				MessageID:       msgID,
-				ParentMessageID: parentMsgID,
-			}, sqsClient, queueURL)
-			if err != nil {
-				logger.Error("Failed to send message to SQS:", err)
-			} else {
-				logger.Info("Message sent to SQS successfully")
-			}
-
+		// Quote the parent message when replying
+		contextInfo, err := buildReplyContext(client, messageStore, req.Recipient, req.ParentMessageID, req.RequireParent)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		contextInfo, err = addMentions(client, contextInfo, req.Recipient, req.Mentions)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Send the message
+		success, msg, _ := sendTextMessage(client, messageStore, eventSink, req, contextInfo, WALogMessageForQueue{})
 
 		// Set response headers
 		w.Header().Set("Content-Type", "application/json")
@@ -703,6 +1326,8 @@ func startRESTServer(client *whatsmeow.Client, sqsClient *sqs.Client, queueURL s
 	})
 
 	http.HandleFunc("/api/delete-message", revokeMessageHandler(client))
+	http.HandleFunc("/api/react", reactMessageHandler(client, messageStore, eventSink))
+	http.HandleFunc("/api/edit-message", editMessageHandler(client, messageStore, eventSink))
 
 	http.HandleFunc("/api/send-image", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("Received request to send message")
@@ -711,35 +1336,29 @@ func startRESTServer(client *whatsmeow.Client, sqsClient *sqs.Client, queueURL s
 			return
 		}
 
-		file, _, err := r.FormFile("file")
-		// fileName := header.Filename
-		// mimeType := header.Header.Get("Content-Type")
+		// Read the file from multipart, a URL or base64
+		req, err := parseMediaRequest(r, "image")
 		if err != nil {
 			fmt.Println("Error retrieving file:", err)
-			http.Error(w, "Error retrieving file", http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		defer file.Close()
-
-		// Get additional form fields
-		recipient := r.FormValue("recipient")
-		message := r.FormValue("message")
-		adminPhone := r.FormValue("admin_phone")
-		parentMessageID := r.FormValue("wa_parent_message_id")
 
-		// Read the file into a byte array
-		fileBytes, err := io.ReadAll(file)
-		if err != nil {
-			fmt.Println("Error reading file:", err)
-			http.Error(w, "Error reading file", http.StatusInternalServerError)
-			return
-		}
+		recipient := req.Recipient
+		message := req.Message
+		adminPhone := req.AdminPhone
+		fileBytes := req.data
+		mimeType := req.Mimetype
 
 		// Validate request
 		if recipient == "" || message == "" {
 			http.Error(w, "Recipient and message are required", http.StatusBadRequest)
 			return
 		}
+		if !strings.HasPrefix(mimeType, "image/") {
+			http.Error(w, "File must be an image", http.StatusBadRequest)
+			return
+		}
 
 		// Save the file temporarily
 		// tmpFile := fmt.Sprintf("whatsapp_failed_files/image_%d.jpg", time.Now().UnixNano())
@@ -751,8 +1370,15 @@ func startRESTServer(client *whatsmeow.Client, sqsClient *sqs.Client, queueURL s
 		// }
 		// defer os.Remove(tmpFile)
 
+		// Quote the parent message when replying
+		contextInfo, err := mediaContextInfo(client, messageStore, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// Send the message
-		success, msg, msgID, parentMsgID := sendWhatsAppImageMessage(client, recipient, message, fileBytes, parentMessageID)
+		success, msg, msgID, parentMsgID := sendWhatsAppImageMessage(client, recipient, message, fileBytes, mimeType, contextInfo)
 		fmt.Println("Message sent", success, msg)
 
 		// Log the message
@@ -761,6 +1387,25 @@ func startRESTServer(client *whatsmeow.Client, sqsClient *sqs.Client, queueURL s
 			recipientPhone := recipient
 			msgTime := time.Now()
 			admPhone := adminPhone
+
+			sha := sha256.Sum256(fileBytes)
+			sentMsg := Message{
+				ID:      msgID,
+				Sender:  senderPhone,
+				Content: message,
+				Time:    msgTime,
+				MediaInfo: MediaInfo{
+					MediaType:  "image",
+					Mimetype:   mimeType,
+					Caption:    message,
+					FileSHA256: hex.EncodeToString(sha[:]),
+					FileLength: uint64(len(fileBytes)),
+				},
+				ParentMessageID: parentMsgID,
+				AdminPhone:      admPhone,
+				Mentions:        contextInfo.GetMentionedJID(),
+			}
+
 			// err := logfunction.LogImageMessage(senderPhone, message, recipientPhone, tmpFile, msgTime)
 			// if err != nil {
 			// 	fmt.Println("⚠️ Failed to log message:", err)
@@ -771,28 +1416,22 @@ func startRESTServer(client *whatsmeow.Client, sqsClient *sqs.Client, queueURL s
 			// }
 
 			tmpFile := fmt.Sprintf("whatsapp_failed_files/image_%d.jpg", time.Now().UnixNano())
-			url, err := uploadToS3(os.Getenv("AWS_S3_BUCKET_NAME"), tmpFile, fileBytes)
+			upload := MediaUpload{Key: tmpFile, Data: fileBytes}
+			err := logSentMessage(messageStore, eventSink, recipient, sentMsg, &upload, WALogMessageForQueue{
+				Type:            "image",
+				From:            senderPhone,
+				To:              recipientPhone,
+				AdminPhone:      admPhone,
+				Message:         message,
+				Time:            msgTime,
+				MessageID:       msgID,
+				ParentMessageID: parentMsgID,
+				Status:          "SENT",
+			})
 			if err != nil {
-				fmt.Println("Error uploading file to S3:", err)
-				http.Error(w, "Error uploading file to S3", http.StatusInternalServerError)
-				return
+				logger.Error("⚠️ Failed to send message to event sinks:", err)
 			} else {
-				err = sendMessageToQueue(WALogMessageForQueue{
-					Type:            "image",
-					From:            senderPhone,
-					To:              recipientPhone,
-					AdminPhone:      admPhone,
-					Message:         message,
-					Time:            msgTime,
-					File:            url,
-					MessageID:       msgID,
-					ParentMessageID: parentMsgID,
-				}, sqsClient, queueURL)
-				if err != nil {
-					logger.Error("⚠️ Failed to send message to SQS:", err)
-				} else {
-					logger.Info("✅ Message sent to SQS successfully")
-				}
+				logger.Info("✅ Message sent to event sinks successfully")
 			}
 		}
 
@@ -817,29 +1456,21 @@ func startRESTServer(client *whatsmeow.Client, sqsClient *sqs.Client, queueURL s
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		file, header, err := r.FormFile("file")
-		fileName := header.Filename
-		mimeType := header.Header.Get("Content-Type")
+
+		// Read the file from multipart, a URL or base64
+		req, err := parseMediaRequest(r, "document")
 		if err != nil {
 			fmt.Println("Error retrieving file:", err)
-			http.Error(w, "Error retrieving file", http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		defer file.Close()
 
-		// Get additional form fields
-		recipient := r.FormValue("recipient")
-		message := r.FormValue("message")
-		adminPhone := r.FormValue("admin_phone")
-		parentMessageID := r.FormValue("wa_parent_message_id")
-
-		// Read the file into a byte array
-		fileBytes, err := io.ReadAll(file)
-		if err != nil {
-			fmt.Println("Error reading file:", err)
-			http.Error(w, "Error reading file", http.StatusInternalServerError)
-			return
-		}
+		recipient := req.Recipient
+		message := req.Message
+		adminPhone := req.AdminPhone
+		fileBytes := req.data
+		fileName := req.Filename
+		mimeType := req.Mimetype
 
 		// Validate request
 		if recipient == "" || message == "" {
@@ -857,8 +1488,15 @@ func startRESTServer(client *whatsmeow.Client, sqsClient *sqs.Client, queueURL s
 		// }
 		// defer os.Remove(tmpFile)
 
+		// Quote the parent message when replying
+		contextInfo, err := mediaContextInfo(client, messageStore, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// Send the message
-		success, msg, msgID, parentMsgID := sendWhatsAppDocumentMessage(client, recipient, message, fileBytes, fileName, mimeType, parentMessageID)
+		success, msg, msgID, parentMsgID := sendWhatsAppDocumentMessage(client, recipient, message, fileBytes, fileName, mimeType, contextInfo)
 		fmt.Println("Message sent", success, msg)
 
 		// Log the message
@@ -868,29 +1506,42 @@ func startRESTServer(client *whatsmeow.Client, sqsClient *sqs.Client, queueURL s
 			msgTime := time.Now()
 			admPhone := adminPhone
 
+			sha := sha256.Sum256(fileBytes)
+			sentMsg := Message{
+				ID:      msgID,
+				Sender:  senderPhone,
+				Content: message,
+				Time:    msgTime,
+				MediaInfo: MediaInfo{
+					MediaType:  "document",
+					Mimetype:   mimeType,
+					Filename:   fileName,
+					Caption:    message,
+					FileSHA256: hex.EncodeToString(sha[:]),
+					FileLength: uint64(len(fileBytes)),
+				},
+				ParentMessageID: parentMsgID,
+				AdminPhone:      admPhone,
+				Mentions:        contextInfo.GetMentionedJID(),
+			}
+
 			tmpFile := fmt.Sprintf("whatsapp_failed_files/document_%d.pdf", time.Now().UnixNano())
-			url, err := uploadToS3(os.Getenv("AWS_S3_BUCKET_NAME"), tmpFile, fileBytes)
+			upload := MediaUpload{Key: tmpFile, Data: fileBytes}
+			err := logSentMessage(messageStore, eventSink, recipient, sentMsg, &upload, WALogMessageForQueue{
+				Type:            "document",
+				From:            senderPhone,
+				To:              recipientPhone,
+				AdminPhone:      admPhone,
+				Message:         message,
+				Time:            msgTime,
+				MessageID:       msgID,
+				ParentMessageID: parentMsgID,
+				Status:          "SENT",
+			})
 			if err != nil {
-				fmt.Println("Error uploading file to S3:", err)
-				http.Error(w, "Error uploading file to S3", http.StatusInternalServerError)
-				return
+				logger.Error("⚠️ Failed to send message to event sinks:", err)
 			} else {
-				err = sendMessageToQueue(WALogMessageForQueue{
-					Type:            "document",
-					From:            senderPhone,
-					To:              recipientPhone,
-					AdminPhone:      admPhone,
-					Message:         message,
-					File:            url,
-					Time:            msgTime,
-					MessageID:       msgID,
-					ParentMessageID: parentMsgID,
-				}, sqsClient, queueURL)
-				if err != nil {
-					logger.Error("⚠️ Failed to send message to SQS:", err)
-				} else {
-					logger.Info("✅ Message sent to SQS successfully")
-				}
+				logger.Info("✅ Message sent to event sinks successfully")
 			}
 			// err = logfunction.LogDocumentMessage(senderPhone, message, recipientPhone, url, msgTime)
 			// if err != nil {
@@ -913,6 +1564,49 @@ func startRESTServer(client *whatsmeow.Client, sqsClient *sqs.Client, queueURL s
 		})
 	})
 
+	http.HandleFunc("/api/send-audio", sendAudioHandler(client, messageStore, eventSink))
+	http.HandleFunc("/api/send-video", sendVideoHandler(client, messageStore, eventSink))
+	http.HandleFunc("/api/send-sticker", sendStickerHandler(client, messageStore, eventSink))
+	http.HandleFunc("/api/send-location", sendLocationHandler(client, messageStore, eventSink))
+	http.HandleFunc("/api/send-contact", sendContactHandler(client, messageStore, eventSink))
+	http.HandleFunc("/api/send-poll", sendPollHandler(client, messageStore, eventSink))
+	http.HandleFunc("/api/send-template", sendTemplateHandler(client, messageStore, eventSink))
+	http.HandleFunc("/api/polls/{id}", pollResultsHandler(messageStore))
+
+	http.HandleFunc("/api/broadcasts", createBroadcastHandler(messageStore, broadcastWorker))
+	http.HandleFunc("/api/broadcasts/{id}", getBroadcastHandler(messageStore))
+
+	// Scheduled messages are sent by a background scheduler
+	scheduler := newScheduler(client, messageStore, eventSink)
+	go scheduler.Run()
+	http.HandleFunc("/api/schedules", schedulesHandler(messageStore, scheduler))
+	http.HandleFunc("/api/schedules/{id}", scheduleHandler(messageStore, scheduler))
+
+	// Message templates
+	http.HandleFunc("/api/templates", templatesHandler(messageStore))
+	http.HandleFunc("/api/templates/{name}", templateHandler(messageStore))
+
+	// Auto-reply rules take effect as soon as they are saved
+	http.HandleFunc("/api/auto-replies", autoRepliesHandler(messageStore, autoReplies))
+	http.HandleFunc("/api/auto-replies/{id}", autoReplyHandler(messageStore, autoReplies))
+
+	// Business hours and the away message sent outside them
+	http.HandleFunc("/api/business-hours", businessHoursHandler(messageStore, awayResponder))
+
+	// Events waiting for delivery to the event sinks
+	if outbox, ok := eventSink.(*Outbox); ok {
+		http.HandleFunc("/api/outbox", outboxHandler(messageStore))
+		http.HandleFunc("/api/outbox/{id}", outboxHandler(messageStore))
+		http.HandleFunc("/api/outbox/retry", retryOutboxHandler(messageStore, outbox))
+		http.HandleFunc("/api/outbox/{id}/retry", retryOutboxHandler(messageStore, outbox))
+	}
+
+	// Handlers for reading stored chat history
+	http.HandleFunc("/api/chats", listChatsHandler(messageStore))
+	http.HandleFunc("/api/chats/{jid}/messages", listMessagesHandler(messageStore))
+	http.HandleFunc("/api/search", searchMessagesHandler(messageStore))
+	http.HandleFunc("/api/mentions", listMentionsHandler(client, messageStore))
+
 	http.HandleFunc("/api/groups", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("Received request for group info")
 		if r.Method != http.MethodGet {
@@ -962,86 +1656,23 @@ const LogAPIEndpoint = "http://privatebackend.railse.com:8080/whatsapp/log-messa
 type WALogMessageForQueue struct {
 	MessageID       string    `json:"wa_message_id"`
 	ParentMessageID string    `json:"wa_parent_message_id"`
-	Type            string    `json:"type"` // "text", "image", "document"
+	Type            string    `json:"type"` // "text", "image", "document", ..., "reaction", "edit", "revoke", "poll", "status"
 	From            string    `json:"from"`
 	To              string    `json:"to"`
 	AdminPhone      string    `json:"admin_phone"`
 	Message         string    `json:"message"`
 	File            string    `json:"file"`
+	FileLength      uint64    `json:"file_length,omitempty"`
 	Time            time.Time `json:"time"`
-}
-
-func sendMessageToQueue(message WALogMessageForQueue, sqsClient *sqs.Client, queueUrl string) error {
-	messageBytes, err := json.Marshal(message)
-	if err != nil {
-		return fmt.Errorf("error marshalling message: %w", err)
-	}
-
-	_, err = sqsClient.SendMessage(context.Background(), &sqs.SendMessageInput{
-		QueueUrl:    aws.String(queueUrl),
-		MessageBody: aws.String(string(messageBytes)),
-	})
-	if err != nil {
-		return fmt.Errorf("error sending message to SQS: %w", err)
-	}
-	fmt.Println("✅ Message sent to SQS queue successfully")
-	return nil
-}
-
-func recieveMessagesFromQueue(sqsClient *sqs.Client, queueUrl string) error {
-	output, err := sqsClient.ReceiveMessage(context.Background(), &sqs.ReceiveMessageInput{
-		QueueUrl:            aws.String(queueUrl),
-		MaxNumberOfMessages: 10,
-		WaitTimeSeconds:     5,
-	})
-	if err != nil {
-		return fmt.Errorf("error receiving message from SQS: %w", err)
-	}
-
-	if len(output.Messages) == 0 {
-		fmt.Println("No messages in the queue")
-		return nil
-	}
-	fmt.Println("Received", len(output.Messages), "messages from SQS queue")
-
-	for _, msg := range output.Messages {
-		var message WALogMessageForQueue
-		err := json.Unmarshal([]byte(*msg.Body), &message)
-		if err != nil {
-			fmt.Println("❌ Error unmarshalling message:", err)
-			continue
-		}
-
-		var logErr error
-		switch message.Type {
-		case "text", "location", "contact":
-			logErr = logfunction.LogMessage(message.From, message.Message, message.To, message.Time, message.AdminPhone, message.MessageID, message.ParentMessageID)
-		case "image":
-			logErr = logfunction.LogImageMessageSQS(message.From, message.Message, message.To, message.File, message.Time, message.AdminPhone, message.MessageID, message.ParentMessageID)
-		case "document", "audio", "video":
-			logErr = logfunction.LogDocumentMessageSQS(message.From, message.Message, message.To, message.File, message.Time, message.AdminPhone, message.MessageID, message.ParentMessageID)
-		default:
-			fmt.Println("❌ Unknown message type:", message.Type)
-			continue
-		}
+	Status          string    `json:"status,omitempty"` // "SENT", "DELIVERED", "READ", "PLAYED"
 
-		if logErr != nil {
-			fmt.Println("❌ Error logging message:", logErr)
-			continue
-		}
-
-		// Delete from queue
-		_, err = sqsClient.DeleteMessage(context.Background(), &sqs.DeleteMessageInput{
-			QueueUrl:      aws.String(queueUrl),
-			ReceiptHandle: msg.ReceiptHandle,
-		})
-		if err != nil {
-			return fmt.Errorf("error deleting message from SQS: %w", err)
-		}
-		fmt.Println("✅ Message processed and deleted from SQS:", message.Message)
-	}
+	// Set when the message was rendered from a template
+	Template          string                 `json:"template,omitempty"`
+	TemplateVariables map[string]interface{} `json:"template_variables,omitempty"`
 
-	return nil
+	// Set when the bridge sent the message on its own rather than a person
+	AutoSent   bool   `json:"auto_sent,omitempty"`
+	AutoReason string `json:"auto_reason,omitempty"` // "away_message", "auto_reply"
 }
 
 var awsConfig *aws.Config
@@ -1063,37 +1694,35 @@ func main() {
 
 	err := godotenv.Load()
 	if err != nil {
-		fmt.Println("Error loading .env file")
-		return
+		fmt.Println("⚠️ No .env file loaded:", err)
 	}
 
-	ctx := context.Background()
-	sqsClient := sqs.NewFromConfig(*getConfig())
-
-	// Get Queue URL
-	result, err := sqsClient.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
-		QueueName: aws.String(os.Getenv("AWS_SQS_QUEUE_NAME")),
-	})
+	eventSink, err := newEventSinkFromEnv(context.Background())
 	if err != nil {
-		fmt.Println("Error getting SQS queue URL:", err)
+		fmt.Println("Error setting up event sinks:", err)
 		return
 	}
-	fmt.Println("SQS Queue URL:", *result.QueueUrl)
+	defer eventSink.Close()
 
-	// Crone job
-	// Start SQS polling in a separate goroutine
-	go func() {
-		for {
-			err = recieveMessagesFromQueue(sqsClient, *result.QueueUrl)
-			if err != nil {
-				fmt.Println("❌ Error receiving message from SQS:", err)
-			} else {
-				fmt.Println("✅ Successfully received message from SQS")
-			}
-			fmt.Println("-------------Cron job executed-------------")
-			time.Sleep(10 * time.Second) // Sleep for 10 seconds before next iteration
+	// Deliver queued entries to the log API when publishing through SQS
+	var consumer *QueueConsumer
+	if sqsSink := findSQSSink(eventSink); sqsSink != nil {
+		logConfig, err := logfunction.ConfigFromEnv()
+		if err != nil {
+			fmt.Println("Error configuring log API client:", err)
+			return
 		}
-	}()
+		logClient, err := logfunction.NewLogClient(logConfig)
+		if err != nil {
+			fmt.Println("Error configuring log API client:", err)
+			return
+		}
+		if os.Getenv("AWS_S3_BUCKET_NAME") == "" {
+			fmt.Println("⚠️ AWS_S3_BUCKET_NAME is not set; media events cannot be delivered through SQS")
+		}
+		consumer = newQueueConsumer(sqsSink.client, sqsSink.queueURL, logClient)
+		consumer.Start()
+	}
 
 	// Set up logger
 	logger := waLog.Stdout("Client", "DEBUG", true)
@@ -1134,8 +1763,6 @@ func main() {
 		return
 	}
 
-	startRESTServer(client, sqsClient, *result.QueueUrl, 6000)
-
 	// Initialize message store
 	messageStore, err := NewMessageStore()
 	if err != nil {
@@ -1144,6 +1771,30 @@ func main() {
 	}
 	defer messageStore.Close()
 
+	// Events go through the outbox, so they survive sink outages and restarts
+	outbox := newOutbox(messageStore, eventSink)
+	outbox.download = client.Download
+	go outbox.Run()
+	eventSink = outbox
+
+	// Auto-reply rules are evaluated on every incoming message
+	autoReplies := newAutoReplyEngine(client, messageStore, eventSink)
+	if err := autoReplies.Reload(); err != nil {
+		logger.Errorf("Failed to load auto-reply rules: %v", err)
+	}
+
+	// Outside business hours direct chats get an away message
+	awayResponder := newAwayResponder(client, messageStore, eventSink)
+	if err := awayResponder.Reload(); err != nil {
+		logger.Errorf("Failed to load business hours: %v", err)
+	}
+
+	// Broadcasts are sent in the background and resume after a restart
+	broadcastWorker := newBroadcastWorker(client, messageStore, eventSink)
+	go broadcastWorker.Run()
+
+	startRESTServer(client, messageStore, eventSink, autoReplies, awayResponder, broadcastWorker, 6000)
+
 	// Setup event handling for messages and history sync
 	client.AddEventHandler(func(evt interface{}) {
 		switch v := evt.(type) {
@@ -1182,30 +1833,21 @@ func main() {
 			audio := v.Message.AudioMessage
 			video := v.Message.VideoMessage
 			contacts := v.Message.ContactsArrayMessage
+			sticker := v.Message.StickerMessage
 			messageId := v.Info.ID
 			parentMessageId := ""
 			adminPhone := ""
 
+			// Messages from our other devices have only just been sent; incoming
+			// ones have been delivered to us
+			status := "DELIVERED"
+			if v.Info.IsFromMe {
+				status = "SENT"
+			}
+
 			fmt.Println("Received message:", text, "from", sender, "to", recipient)
 
-			var contextInfo *waE2E.ContextInfo
-			if m := v.Message.GetExtendedTextMessage(); m != nil {
-				contextInfo = m.GetContextInfo()
-			} else if m := v.Message.GetImageMessage(); m != nil {
-				contextInfo = m.GetContextInfo()
-			} else if m := v.Message.GetDocumentMessage(); m != nil {
-				contextInfo = m.GetContextInfo()
-			} else if m := v.Message.GetContactMessage(); m != nil {
-				contextInfo = m.GetContextInfo()
-			} else if m := v.Message.GetLocationMessage(); m != nil {
-				contextInfo = m.GetContextInfo()
-			} else if m := v.Message.GetVideoMessage(); m != nil {
-				contextInfo = m.GetContextInfo()
-			} else if m := v.Message.GetAudioMessage(); m != nil {
-				contextInfo = m.GetContextInfo()
-			} else if m := v.Message.GetContactsArrayMessage(); m != nil {
-				contextInfo = m.GetContextInfo()
-			}
+			contextInfo := getContextInfo(v.Message)
 
 			// println("Message ID:", messageId)
 
@@ -1219,100 +1861,103 @@ func main() {
 				return
 			}
 
-			// Check if the message is a document
-			if document != nil {
-				data, err := client.Download(context.Background(), v.Message.DocumentMessage)
-				if err != nil {
-					logger.Errorf("❌ Failed to download document: %v", err)
-					return
+			// Poll votes are tallied locally and not logged individually
+			if v.Message.GetPollUpdateMessage() != nil {
+				handlePollUpdate(client, messageStore, v, logger)
+				return
+			}
+
+			// Reactions, edits and revokes refer to an earlier message
+			if v.Message.GetReactionMessage() != nil || v.Message.GetProtocolMessage() != nil {
+				queueMsg := WALogMessageForQueue{
+					From:       sender,
+					To:         recipient,
+					Time:       timestamp,
+					AdminPhone: adminPhone,
+					MessageID:  messageId,
+					Status:     status,
 				}
+				if v.Message.GetReactionMessage() != nil {
+					handleReaction(messageStore, v, queueMsg, logger, eventSink)
+				} else {
+					handleProtocolMessage(messageStore, v, queueMsg, logger, eventSink)
+				}
+				return
+			}
+
+			go autoReplies.HandleMessage(v)
+			go awayResponder.HandleMessage(v)
 
+			// Check if the message is a document
+			if document != nil {
 				// Save document temporarily
 				tmpFile := fmt.Sprintf("whatsapp_failed_files/document_%d.pdf", time.Now().UnixNano())
-
-				// upload to s3
-				url, err := uploadToS3(os.Getenv("AWS_S3_BUCKET_NAME"), tmpFile, data)
+				upload, err := downloadReceivedMedia(client, v.Message, tmpFile, v.Info.Chat.String())
 				if err != nil {
-					logger.Errorf("❌ Failed to upload document to S3: %v", err)
+					logger.Errorf("❌ Failed to download document: %v", err)
 					return
 				}
+
 				timestamp := v.Info.Timestamp
 				caption := ""
 				if v.Message.DocumentMessage.Caption != nil {
 					caption = *v.Message.DocumentMessage.Caption
 				}
 
-				err = sendMessageToQueue(WALogMessageForQueue{
+				err = publishMediaEvent(WALogMessageForQueue{
 					Type:            "document",
 					From:            sender,
 					To:              recipient,
 					Message:         caption,
 					Time:            timestamp,
-					File:            url,
 					AdminPhone:      adminPhone,
 					MessageID:       messageId,
 					ParentMessageID: parentMessageId,
-				}, sqsClient, *result.QueueUrl)
+					Status:          status,
+				}, eventSink, messageStore, upload)
 				if err != nil {
-					logger.Errorf("❌ Failed to send document message to SQS: %v", err)
-					return
+					logger.Errorf("❌ Failed to send document message to event sinks: %v", err)
 				} else {
-					logger.Infof("✅ Document message sent to SQS queue successfully")
+					logger.Infof("✅ Document message sent to event sinks successfully")
 				}
 			}
 
 			// Check if message is an audio message
 			if audio != nil {
-				data, err := client.Download(context.Background(), v.Message.AudioMessage)
-				if err != nil {
-					logger.Errorf("❌ Failed to download audio: %v", err)
-					return
-				}
-
 				// Save audio temporarily
 				tmpFile := fmt.Sprintf("whatsapp_failed_files/audio_%d.mp3", time.Now().UnixNano())
-
-				// upload to s3
-				url, err := uploadToS3(os.Getenv("AWS_S3_BUCKET_NAME"), tmpFile, data)
+				upload, err := downloadReceivedMedia(client, v.Message, tmpFile, v.Info.Chat.String())
 				if err != nil {
-					logger.Errorf("❌ Failed to upload audio to S3: %v", err)
+					logger.Errorf("❌ Failed to download audio: %v", err)
 					return
 				}
 
 				timestamp := v.Info.Timestamp
 
-				err = sendMessageToQueue(WALogMessageForQueue{
+				err = publishMediaEvent(WALogMessageForQueue{
 					Type:            "audio",
 					From:            sender,
 					To:              recipient,
 					Message:         "",
 					Time:            timestamp,
-					File:            url,
 					AdminPhone:      adminPhone,
 					MessageID:       messageId,
 					ParentMessageID: parentMessageId,
-				}, sqsClient, *result.QueueUrl)
+					Status:          status,
+				}, eventSink, messageStore, upload)
 				if err != nil {
-					logger.Errorf("❌ Failed to send audio message to SQS: %v", err)
+					logger.Errorf("❌ Failed to send audio message to event sinks: %v", err)
 				} else {
-					logger.Infof("✅ Audio message sent to SQS queue successfully")
+					logger.Infof("✅ Audio message sent to event sinks successfully")
 				}
 			}
 
 			if video != nil {
-				data, err := client.Download(context.Background(), v.Message.VideoMessage)
-				if err != nil {
-					logger.Errorf("❌ Failed to download video: %v", err)
-					return
-				}
-
 				// Save video temporarily
 				tmpFile := fmt.Sprintf("whatsapp_failed_files/video_%d.mp4", time.Now().UnixNano())
-
-				// upload to s3
-				url, err := uploadToS3(os.Getenv("AWS_S3_BUCKET_NAME"), tmpFile, data)
+				upload, err := downloadReceivedMedia(client, v.Message, tmpFile, v.Info.Chat.String())
 				if err != nil {
-					logger.Errorf("❌ Failed to upload video to S3: %v", err)
+					logger.Errorf("❌ Failed to download video: %v", err)
 					return
 				}
 
@@ -1322,39 +1967,30 @@ func main() {
 					caption = *v.Message.VideoMessage.Caption
 				}
 
-				err = sendMessageToQueue(WALogMessageForQueue{
+				err = publishMediaEvent(WALogMessageForQueue{
 					Type:            "video",
 					From:            sender,
 					To:              recipient,
 					Message:         caption,
 					Time:            timestamp,
-					File:            url,
 					AdminPhone:      adminPhone,
 					MessageID:       messageId,
 					ParentMessageID: parentMessageId,
-				}, sqsClient, *result.QueueUrl)
+					Status:          status,
+				}, eventSink, messageStore, upload)
 				if err != nil {
-					logger.Errorf("❌ Failed to send video message to SQS: %v", err)
+					logger.Errorf("❌ Failed to send video message to event sinks: %v", err)
 				} else {
-					logger.Infof("✅ Video message sent to SQS queue successfully")
+					logger.Infof("✅ Video message sent to event sinks successfully")
 				}
 			}
 
 			if image != nil {
-				data, err := client.Download(context.Background(), v.Message.ImageMessage)
-				if err != nil {
-					logger.Errorf("❌ Failed to download image: %v", err)
-					return
-				}
-
 				// Save image temporarily
 				tmpFile := fmt.Sprintf("whatsapp_failed_files/image_%d.jpg", time.Now().UnixNano())
-
-				// upload to s3
-				url, err := uploadToS3(os.Getenv("AWS_S3_BUCKET_NAME"), tmpFile, data)
-				// log.Println("URL = ", url)
+				upload, err := downloadReceivedMedia(client, v.Message, tmpFile, v.Info.Chat.String())
 				if err != nil {
-					logger.Errorf("❌ Failed to upload image to S3: %v", err)
+					logger.Errorf("❌ Failed to download image: %v", err)
 					return
 				}
 
@@ -1364,29 +2000,55 @@ func main() {
 					caption = *v.Message.ImageMessage.Caption
 				}
 
-				err = sendMessageToQueue(WALogMessageForQueue{
+				err = publishMediaEvent(WALogMessageForQueue{
 					Type:            "image",
 					From:            sender,
 					To:              recipient,
 					Message:         caption,
 					Time:            timestamp,
-					File:            url,
 					AdminPhone:      adminPhone,
 					MessageID:       messageId,
 					ParentMessageID: parentMessageId,
-				}, sqsClient, *result.QueueUrl)
+					Status:          status,
+				}, eventSink, messageStore, upload)
 				if err != nil {
-					logger.Errorf("❌ Failed to send image message to SQS: %v", err)
+					logger.Errorf("❌ Failed to send image message to event sinks: %v", err)
 				} else {
-					logger.Infof("✅ Image message sent to SQS queue successfully")
+					logger.Infof("✅ Image message sent to event sinks successfully")
+				}
+			}
+
+			if sticker != nil {
+				tmpFile := fmt.Sprintf("whatsapp_failed_files/sticker_%d.webp", time.Now().UnixNano())
+				upload, err := downloadReceivedMedia(client, v.Message, tmpFile, v.Info.Chat.String())
+				if err != nil {
+					logger.Errorf("❌ Failed to download sticker: %v", err)
+					return
+				}
+
+				err = publishMediaEvent(WALogMessageForQueue{
+					Type:            "sticker",
+					From:            sender,
+					To:              recipient,
+					Message:         "",
+					Time:            timestamp,
+					AdminPhone:      adminPhone,
+					MessageID:       messageId,
+					ParentMessageID: parentMessageId,
+					Status:          status,
+				}, eventSink, messageStore, upload)
+				if err != nil {
+					logger.Errorf("❌ Failed to send sticker message to event sinks: %v", err)
+				} else {
+					logger.Infof("✅ Sticker message sent to event sinks successfully")
 				}
 			}
 
 			if text != "" {
 				fmt.Printf("📥 Received from %s to %s: %s\n", sender, recipient, text)
 
-				// Send message to SQS queue
-				err = sendMessageToQueue(WALogMessageForQueue{
+				// Send message to event sinks
+				err = publishEvent(WALogMessageForQueue{
 					Type:            "text",
 					From:            sender,
 					To:              recipient,
@@ -1396,11 +2058,12 @@ func main() {
 					AdminPhone:      adminPhone,
 					MessageID:       messageId,
 					ParentMessageID: parentMessageId,
-				}, sqsClient, *result.QueueUrl)
+					Status:          status,
+				}, eventSink)
 				if err != nil {
-					logger.Errorf("❌ Failed to send message to SQS: %v", err)
+					logger.Errorf("❌ Failed to send message to event sinks: %v", err)
 				} else {
-					logger.Infof("✅ Message sent to SQS queue successfully")
+					logger.Infof("✅ Message sent to event sinks successfully")
 				}
 			}
 
@@ -1411,8 +2074,8 @@ func main() {
 				url := "https://maps.google.com/?q=" + fmt.Sprintf("%f", lat) + "," + fmt.Sprintf("%f", lon)
 
 				fmt.Println("📍 Location received from", sender, "to", recipient, url)
-				// Send location to SQS queue
-				err = sendMessageToQueue(WALogMessageForQueue{
+				// Send location to event sinks
+				err = publishEvent(WALogMessageForQueue{
 					Type:            "location",
 					From:            sender,
 					To:              recipient,
@@ -1422,11 +2085,12 @@ func main() {
 					AdminPhone:      adminPhone,
 					MessageID:       messageId,
 					ParentMessageID: parentMessageId,
-				}, sqsClient, *result.QueueUrl)
+					Status:          status,
+				}, eventSink)
 				if err != nil {
-					logger.Errorf("❌ Failed to send location message to SQS: %v", err)
+					logger.Errorf("❌ Failed to send location message to event sinks: %v", err)
 				} else {
-					logger.Infof("✅ Location message sent to SQS queue successfully")
+					logger.Infof("✅ Location message sent to event sinks successfully")
 				}
 			}
 
@@ -1437,8 +2101,8 @@ func main() {
 
 				fmt.Println("📇 Contact received from", sender, "to", recipient, contactName, contactNumber)
 
-				// Send contact to SQS queue
-				err = sendMessageToQueue(WALogMessageForQueue{
+				// Send contact to event sinks
+				err = publishEvent(WALogMessageForQueue{
 					Type:            "contact",
 					From:            sender,
 					To:              recipient,
@@ -1448,11 +2112,12 @@ func main() {
 					AdminPhone:      adminPhone,
 					MessageID:       messageId,
 					ParentMessageID: parentMessageId,
-				}, sqsClient, *result.QueueUrl)
+					Status:          status,
+				}, eventSink)
 				if err != nil {
-					logger.Errorf("❌ Failed to send contact message to SQS: %v", err)
+					logger.Errorf("❌ Failed to send contact message to event sinks: %v", err)
 				} else {
-					logger.Infof("✅ Contact message sent to SQS queue successfully")
+					logger.Infof("✅ Contact message sent to event sinks successfully")
 				}
 			}
 
@@ -1463,8 +2128,8 @@ func main() {
 
 					fmt.Println("📇 Contact received from", sender, "to", recipient, contactName, contactNumber)
 
-					// Send contact to SQS queue
-					err = sendMessageToQueue(WALogMessageForQueue{
+					// Send contact to event sinks
+					err = publishEvent(WALogMessageForQueue{
 						Type:            "contact",
 						From:            sender,
 						To:              recipient,
@@ -1474,11 +2139,12 @@ func main() {
 						AdminPhone:      adminPhone,
 						MessageID:       messageId,
 						ParentMessageID: parentMessageId,
-					}, sqsClient, *result.QueueUrl)
+						Status:          status,
+					}, eventSink)
 					if err != nil {
-						logger.Errorf("❌ Failed to send contact message to SQS: %v", err)
+						logger.Errorf("❌ Failed to send contact message to event sinks: %v", err)
 					} else {
-						logger.Infof("✅ Contact message sent to SQS queue successfully")
+						logger.Infof("✅ Contact message sent to event sinks successfully")
 					}
 				}
 			}
@@ -1490,7 +2156,7 @@ func main() {
 			// 	replyMessage = v.Message.GetExtendedTextMessage().GetText()
 
 			// 	if replyMessage != "" {
-			// 		err = sendMessageToQueue(WALogMessageForQueue{
+			// 		err = publishEvent(WALogMessageForQueue{
 			// 			Type:            "text",
 			// 			From:            sender,
 			// 			To:              recipient,
@@ -1500,18 +2166,18 @@ func main() {
 			// 			File:            "",
 			// 			MessageID:       messageId,
 			// 			ParentMessageID: parentMessageId,
-			// 		}, sqsClient, *result.QueueUrl)
+			// 		}, eventSink)
 			// 		if err != nil {
-			// 			logger.Errorf("❌ Failed to send reply message to SQS: %v", err)
+			// 			logger.Errorf("❌ Failed to send reply message to event sinks: %v", err)
 			// 		} else {
-			// 			logger.Infof("✅ Reply message sent to SQS queue successfully")
+			// 			logger.Infof("✅ Reply message sent to event sinks successfully")
 			// 		}
 			// 	}
 			// }
 
 		case *events.Receipt:
 			// Process regular messages
-			handleReceipt(client, messageStore, v, logger)
+			handleReceipt(client, messageStore, v, logger, eventSink)
 
 		case *events.HistorySync:
 			// Process history sync events
@@ -1580,7 +2246,7 @@ func main() {
 	fmt.Println("\n✓ Connected to WhatsApp! Type 'help' for commands.")
 
 	// Start REST API server
-	// startRESTServer(client, sqsClient, *result.QueueUrl, 6000)
+	// startRESTServer(client, messageStore, sqsClient, *result.QueueUrl, 6000)
 
 	// Create a channel to keep the main goroutine alive
 	exitChan := make(chan os.Signal, 1)
@@ -1592,8 +2258,17 @@ func main() {
 	<-exitChan
 
 	fmt.Println("Disconnecting...")
+	// Let a broadcast send in progress finish before disconnecting
+	broadcastWorker.Stop()
+
 	// Disconnect client
 	client.Disconnect()
+
+	// Let the log entries being delivered finish
+	if consumer != nil {
+		fmt.Println("Waiting for queued log entries in progress...")
+		consumer.Stop()
+	}
 }
 
 func parseVCard(vcard string) (name, number string) {
@@ -1601,7 +2276,7 @@ func parseVCard(vcard string) (name, number string) {
 	for _, line := range lines {
 		if strings.HasPrefix(line, "FN:") {
 			// The name is everything after "FN:"
-			name = strings.TrimSpace(strings.TrimPrefix(line, "FN:"))
+			name = vCardUnescaper.Replace(strings.TrimSpace(strings.TrimPrefix(line, "FN:")))
 		} else if strings.HasPrefix(line, "TEL;") {
 			// The number is everything after the last ":"
 			parts := strings.Split(line, ":")
@@ -1698,10 +2373,10 @@ func GetChatName(client *whatsmeow.Client, messageStore *MessageStore, jid types
 
 // Handle regular incoming messages
 func handleMessage(client *whatsmeow.Client, messageStore *MessageStore, msg *events.Message, logger waLog.Logger) {
-	// Extract text content
-	content := extractTextContent(msg.Message)
-	if content == "" {
-		return // Skip non-text messages
+	// Extract text content and media metadata
+	content, media := extractMessageContent(msg.Message)
+	if content == "" && media.MediaType == "" {
+		return // Skip messages we don't know how to store
 	}
 
 	// Save message to database
@@ -1718,29 +2393,91 @@ func handleMessage(client *whatsmeow.Client, messageStore *MessageStore, msg *ev
 	}
 
 	// Store message in database
-	err = messageStore.StoreMessage(
-		msg.Info.ID,
-		chatJID,
-		sender,
-		content,
-		msg.Info.Timestamp,
-		msg.Info.IsFromMe,
-	)
+	err = messageStore.StoreMessage(Message{
+		ID:        msg.Info.ID,
+		ChatJID:   chatJID,
+		Sender:    sender,
+		Content:   content,
+		Time:      msg.Info.Timestamp,
+		IsFromMe:  msg.Info.IsFromMe,
+		MediaInfo: media,
+
+		ParentMessageID: getContextInfo(msg.Message).GetStanzaID(),
+		Mentions:        getContextInfo(msg.Message).GetMentionedJID(),
+	})
 	if err != nil {
 		logger.Warnf("Failed to store message: %v", err)
 	} else {
+		// Keep the options of polls so their votes can be tallied
+		if poll := getPollCreation(msg.Message); poll != nil {
+			if err := messageStore.StorePoll(msg.Info.ID, chatJID, poll); err != nil {
+				logger.Warnf("Failed to store poll: %v", err)
+			}
+		}
+
 		// Log message reception
 		timestamp := msg.Info.Timestamp.Format("2006-01-02 15:04:05")
 		direction := "←"
 		if msg.Info.IsFromMe {
 			direction = "→"
 		}
-		fmt.Printf("[%s] %s %s: %s\n", timestamp, direction, sender, content)
+		if media.MediaType != "" {
+			fmt.Printf("[%s] %s %s: [%s] %s\n", timestamp, direction, sender, media.MediaType, content)
+		} else {
+			fmt.Printf("[%s] %s %s: %s\n", timestamp, direction, sender, content)
+		}
 	}
 }
 
-func handleReceipt(client *whatsmeow.Client, messageStore *MessageStore, receipt *events.Receipt, logger waLog.Logger) {
+func handleReceipt(client *whatsmeow.Client, messageStore *MessageStore, receipt *events.Receipt, logger waLog.Logger, eventSink EventSink) {
 	logger.Infof("receipt %v", receipt)
+
+	// Receipts of type "read-self" and "played-self" are sent when we read an
+	// incoming message on another device
+	var status string
+	switch receipt.Type {
+	case types.ReceiptTypeDelivered:
+		status = "delivered"
+	case types.ReceiptTypeRead, types.ReceiptTypeReadSelf:
+		status = "read"
+	case types.ReceiptTypePlayed, types.ReceiptTypePlayedSelf:
+		status = "played"
+	default:
+		return
+	}
+
+	chatJID := receipt.Chat.String()
+	participant := receipt.Sender.ToNonAD().String()
+
+	ids := make([]string, len(receipt.MessageIDs))
+	for i, id := range receipt.MessageIDs {
+		ids[i] = string(id)
+
+		isNew, err := messageStore.StoreReceipt(ids[i], chatJID, participant, status, receipt.Timestamp)
+		if err != nil {
+			logger.Warnf("Failed to store receipt: %v", err)
+			continue
+		}
+		if !isNew {
+			continue
+		}
+
+		err = publishEvent(WALogMessageForQueue{
+			Type:      "status",
+			From:      receipt.Sender.User,
+			To:        receipt.Chat.User,
+			Time:      receipt.Timestamp,
+			MessageID: ids[i],
+			Status:    strings.ToUpper(status),
+		}, eventSink)
+		if err != nil {
+			logger.Errorf("❌ Failed to send status update to event sinks: %v", err)
+		}
+	}
+
+	if err := messageStore.UpdateDeliveryStatus(ids, status); err != nil {
+		logger.Warnf("Failed to update delivery status: %v", err)
+	}
 }
 
 // Handle history sync events
@@ -1791,21 +2528,25 @@ func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, his
 					continue
 				}
 
-				// Extract text content
-				var content string
+				// Extract text content and media metadata, unwrapping ephemeral,
+				// view-once and document-with-caption containers first
+				var content, parentID string
+				var media MediaInfo
+				var mentions []string
+				var poll *waE2E.PollCreationMessage
 				if msg.Message.Message != nil {
-					if conv := msg.Message.Message.GetConversation(); conv != "" {
-						content = conv
-					} else if ext := msg.Message.Message.GetExtendedTextMessage(); ext != nil {
-						content = ext.GetText()
-					}
+					unwrapped := (&events.Message{RawMessage: msg.Message.Message}).UnwrapRaw()
+					content, media = extractMessageContent(unwrapped.Message)
+					parentID = getContextInfo(unwrapped.Message).GetStanzaID()
+					mentions = getContextInfo(unwrapped.Message).GetMentionedJID()
+					poll = getPollCreation(unwrapped.Message)
 				}
 
 				// Log the message content for debugging
 				logger.Infof("Message content: %v", content)
 
-				// Skip non-text messages
-				if content == "" {
+				// Skip messages we don't know how to store
+				if content == "" && media.MediaType == "" {
 					continue
 				}
 
@@ -1841,17 +2582,26 @@ func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, his
 					continue
 				}
 
-				err = messageStore.StoreMessage(
-					msgID,
-					chatJID,
-					sender,
-					content,
-					timestamp,
-					isFromMe,
-				)
+				err = messageStore.StoreMessage(Message{
+					ID:        msgID,
+					ChatJID:   chatJID,
+					Sender:    sender,
+					Content:   content,
+					Time:      timestamp,
+					IsFromMe:  isFromMe,
+					MediaInfo: media,
+
+					ParentMessageID: parentID,
+					Mentions:        mentions,
+				})
 				if err != nil {
 					logger.Warnf("Failed to store history message: %v", err)
 				} else {
+					if poll != nil {
+						if err := messageStore.StorePoll(msgID, chatJID, poll); err != nil {
+							logger.Warnf("Failed to store poll: %v", err)
+						}
+					}
 					syncedCount++
 					// Log successful message storage
 					logger.Infof("Stored message: [%s] %s -> %s: %s", timestamp.Format("2006-01-02 15:04:05"), sender, chatJID, content)
@@ -1860,7 +2610,7 @@ func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, his
 		}
 	}
 
-	fmt.Printf("History sync complete. Stored %d text messages.\n", syncedCount)
+	fmt.Printf("History sync complete. Stored %d messages.\n", syncedCount)
 }
 
 // Request history sync from the server
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetMessagesComparesTimesAcrossZones(t *testing.T) {
+	store := newTestStore(t)
+	chat := "123@s.whatsapp.net"
+	if err := store.StoreChat(chat, "Chat", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 10:00 UTC stored with a +02:00 offset, 11:00 UTC with a -05:00 offset.
+	// As text the first sorts after the second.
+	east := time.FixedZone("east", 2*60*60)
+	west := time.FixedZone("west", -5*60*60)
+	first := time.Date(2026, 1, 1, 12, 0, 0, 0, east)
+	second := time.Date(2026, 1, 1, 6, 0, 0, 0, west)
+	for id, ts := range map[string]time.Time{"first": first, "second": second} {
+		if err := store.StoreMessage(Message{ID: id, ChatJID: chat, Sender: "123", Content: id, Time: ts}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages, err := store.GetMessages(chat, MessageQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0].ID != "second" || messages[1].ID != "first" {
+		t.Fatalf("messages = %+v, want second then first", messages)
+	}
+
+	since := time.Date(2026, 1, 1, 19, 30, 0, 0, time.FixedZone("far east", 9*60*60)) // 10:30 UTC
+	messages, err = store.GetMessages(chat, MessageQuery{Since: since, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].ID != "second" {
+		t.Fatalf("since %v: messages = %+v, want second", since, messages)
+	}
+
+	messages, err = store.GetMessages(chat, MessageQuery{Until: since, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].ID != "first" {
+		t.Fatalf("until %v: messages = %+v, want first", since, messages)
+	}
+
+	messages, err = store.GetMessages(chat, MessageQuery{Cursor: &PageCursor{Time: second.In(east), ID: "second"}, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].ID != "first" {
+		t.Fatalf("after cursor: messages = %+v, want first", messages)
+	}
+}
+
+func TestNormalizeTimestamps(t *testing.T) {
+	store := newTestStore(t)
+	chat := "123@s.whatsapp.net"
+	if err := store.StoreChat(chat, "Chat", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows written by older versions carry the local offset
+	east := time.FixedZone("east", 2*60*60)
+	local := time.Date(2026, 1, 1, 12, 0, 0, 0, east)
+	if _, err := store.db.Exec("UPDATE chats SET last_message_time = ?", local); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.db.Exec(
+		"INSERT INTO messages (id, chat_jid, sender, content, timestamp, is_from_me) VALUES ('old', ?, '123', 'old', ?, 0)",
+		chat, local,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.normalizeTimestamps(); err != nil {
+		t.Fatal(err)
+	}
+
+	var messageTime, chatTime string
+	if err := store.db.QueryRow("SELECT CAST(timestamp AS TEXT) FROM messages WHERE id = 'old'").Scan(&messageTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.db.QueryRow("SELECT CAST(last_message_time AS TEXT) FROM chats").Scan(&chatTime); err != nil {
+		t.Fatal(err)
+	}
+	want := "2026-01-01 10:00:00+00:00"
+	if messageTime != want || chatTime != want {
+		t.Errorf("times = %q, %q, want %q", messageTime, chatTime, want)
+	}
+}
+
+func TestGetMessagesPagesWithinAMillisecond(t *testing.T) {
+	store := newTestStore(t)
+	chat := "123@s.whatsapp.net"
+	if err := store.StoreChat(chat, "Chat", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both in the same millisecond, with "a" newer but before "b" by ID
+	base := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	for id, ts := range map[string]time.Time{"a": base.Add(900 * time.Microsecond), "b": base.Add(100 * time.Microsecond)} {
+		if err := store.StoreMessage(Message{ID: id, ChatJID: chat, Sender: "123", Content: id, Time: ts}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []string
+	query := MessageQuery{Limit: 1}
+	for range 3 {
+		messages, err := store.GetMessages(chat, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) == 0 {
+			break
+		}
+		ids = append(ids, messages[0].ID)
+		last := messages[len(messages)-1]
+		if query.Cursor, err = parsePageCursor(PageCursor{Time: last.Time, ID: last.ID}.String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ids) != 2 || ids[0] != "a" || ids[1] != "b" {
+		t.Errorf("pages = %v, want [a b]", ids)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/libsignal/logger"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+// Voice notes must be Opus in an Ogg container for phones to play them inline
+const voiceNoteMimetype = "audio/ogg; codecs=opus"
+
+// AudioOptions describe how an audio message is presented
+type AudioOptions struct {
+	PTT      bool   // send as a push-to-talk voice note
+	Seconds  uint32 // duration shown before the audio is downloaded
+	Waveform []byte // up to 64 samples in the range 0-100
+}
+
+// VideoOptions describe how a video message is presented
+type VideoOptions struct {
+	Thumbnail []byte // JPEG preview shown before the video is downloaded
+	Width     uint32
+	Height    uint32
+	Seconds   uint32
+}
+
+// Function to send an audio message or voice note
+func sendWhatsAppAudioMessage(client *whatsmeow.Client, recipient string, audio []byte, mimeType string, opts AudioOptions, contextInfo *waE2E.ContextInfo) (bool, string, string, string) {
+	if !client.IsConnected() {
+		return false, "Not connected to WhatsApp", "", ""
+	}
+
+	recipientJID, err := parseChatJID(recipient)
+	if err != nil {
+		return false, fmt.Sprintf("Error parsing JID: %v", err), "", ""
+	}
+
+	resp, err := client.Upload(context.Background(), audio, whatsmeow.MediaAudio)
+	if err != nil {
+		return false, fmt.Sprintf("Error uploading audio: %v", err), "", ""
+	}
+
+	sendResp, err := client.SendMessage(context.Background(), recipientJID, audioMessage(resp, mimeType, opts, contextInfo))
+	if err != nil {
+		return false, fmt.Sprintf("Error sending audio message: %v", err), "", ""
+	}
+
+	return true, fmt.Sprintf("Audio message sent to %s", recipient), sendResp.ID, contextInfo.GetStanzaID()
+}
+
+// Build an audio message for audio already uploaded to WhatsApp
+func audioMessage(resp whatsmeow.UploadResponse, mimeType string, opts AudioOptions, contextInfo *waE2E.ContextInfo) *waE2E.Message {
+	audioMsg := &waE2E.AudioMessage{
+		Mimetype:      proto.String(mimeType),
+		PTT:           proto.Bool(opts.PTT),
+		URL:           &resp.URL,
+		DirectPath:    &resp.DirectPath,
+		MediaKey:      resp.MediaKey,
+		FileEncSHA256: resp.FileEncSHA256,
+		FileSHA256:    resp.FileSHA256,
+		FileLength:    &resp.FileLength,
+		ContextInfo:   contextInfo,
+	}
+	if opts.Seconds > 0 {
+		audioMsg.Seconds = proto.Uint32(opts.Seconds)
+	}
+	if len(opts.Waveform) > 0 {
+		audioMsg.Waveform = opts.Waveform
+	}
+	return &waE2E.Message{AudioMessage: audioMsg}
+}
+
+// Function to send a video message with an optional caption
+func sendWhatsAppVideoMessage(client *whatsmeow.Client, recipient string, message string, video []byte, mimeType string, opts VideoOptions, contextInfo *waE2E.ContextInfo) (bool, string, string, string) {
+	if !client.IsConnected() {
+		return false, "Not connected to WhatsApp", "", ""
+	}
+
+	recipientJID, err := parseChatJID(recipient)
+	if err != nil {
+		return false, fmt.Sprintf("Error parsing JID: %v", err), "", ""
+	}
+
+	resp, err := client.Upload(context.Background(), video, whatsmeow.MediaVideo)
+	if err != nil {
+		return false, fmt.Sprintf("Error uploading video: %v", err), "", ""
+	}
+
+	sendResp, err := client.SendMessage(context.Background(), recipientJID, videoMessage(resp, message, mimeType, opts, contextInfo))
+	if err != nil {
+		return false, fmt.Sprintf("Error sending video message: %v", err), "", ""
+	}
+
+	return true, fmt.Sprintf("Video message sent to %s", recipient), sendResp.ID, contextInfo.GetStanzaID()
+}
+
+// Build a video message for a video already uploaded to WhatsApp
+func videoMessage(resp whatsmeow.UploadResponse, message string, mimeType string, opts VideoOptions, contextInfo *waE2E.ContextInfo) *waE2E.Message {
+	videoMsg := &waE2E.VideoMessage{
+		Caption:       proto.String(message),
+		Mimetype:      proto.String(mimeType),
+		URL:           &resp.URL,
+		DirectPath:    &resp.DirectPath,
+		MediaKey:      resp.MediaKey,
+		FileEncSHA256: resp.FileEncSHA256,
+		FileSHA256:    resp.FileSHA256,
+		FileLength:    &resp.FileLength,
+		ContextInfo:   contextInfo,
+	}
+	if len(opts.Thumbnail) > 0 {
+		videoMsg.JPEGThumbnail = opts.Thumbnail
+	}
+	if opts.Width > 0 && opts.Height > 0 {
+		videoMsg.Width = proto.Uint32(opts.Width)
+		videoMsg.Height = proto.Uint32(opts.Height)
+	}
+	if opts.Seconds > 0 {
+		videoMsg.Seconds = proto.Uint32(opts.Seconds)
+	}
+	return &waE2E.Message{VideoMessage: videoMsg}
+}
+
+// Parse an optional unsigned integer form field
+func parseUintField(r *http.Request, name string) (uint32, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+	return uint32(n), nil
+}
+
+// Parse a waveform given as comma separated samples between 0 and 100
+func parseWaveform(value string) ([]byte, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parts := strings.Split(value, ",")
+	if len(parts) > 64 {
+		return nil, fmt.Errorf("waveform can have at most 64 samples")
+	}
+	waveform := make([]byte, 0, len(parts))
+	for _, part := range parts {
+		n, err := strconv.ParseUint(strings.TrimSpace(part), 10, 8)
+		if err != nil || n > 100 {
+			return nil, fmt.Errorf("waveform samples must be integers between 0 and 100")
+		}
+		waveform = append(waveform, byte(n))
+	}
+	return waveform, nil
+}
+
+// Report whether data is an Ogg stream carrying Opus audio: the first Ogg
+// page must hold the OpusHead packet
+func isOggOpus(data []byte) bool {
+	if len(data) < 27 || string(data[:4]) != "OggS" {
+		return false
+	}
+	payload := 27 + int(data[26]) // header and segment table
+	return len(data) >= payload+8 && string(data[payload:payload+8]) == "OpusHead"
+}
+
+// Pick the mimetype for an audio message. Phones only play Ogg/Opus as a
+// voice note, so other formats are refused when ptt is set.
+func audioMimetype(mimeType string, data []byte, ptt bool) (string, error) {
+	opus := isOggOpus(data)
+	switch {
+	case ptt && !opus:
+		return "", fmt.Errorf("voice notes must be Ogg/Opus audio")
+	case opus:
+		return voiceNoteMimetype, nil
+	case mimeType == "application/ogg":
+		return "audio/ogg", nil
+	}
+	return mimeType, nil
+}
+
+// Store a media message and publish it with its file that was just sent through the
+// REST API. Mirrors the logging done for images.
+func logSentMedia(client *whatsmeow.Client, messageStore *MessageStore, eventSink EventSink,
+	recipient, adminPhone, message, msgID, parentMsgID string, media MediaInfo, mentions []string, upload MediaUpload) error {
+	senderPhone := client.Store.ID.User
+	msgTime := time.Now()
+
+	sha := sha256.Sum256(upload.Data)
+	media.FileSHA256 = hex.EncodeToString(sha[:])
+	media.FileLength = uint64(len(upload.Data))
+	err := logSentMessage(messageStore, eventSink, recipient, Message{
+		ID:              msgID,
+		Sender:          senderPhone,
+		Content:         message,
+		Time:            msgTime,
+		MediaInfo:       media,
+		ParentMessageID: parentMsgID,
+		AdminPhone:      adminPhone,
+		Mentions:        mentions,
+	}, &upload, WALogMessageForQueue{
+		Type:            media.MediaType,
+		From:            senderPhone,
+		To:              recipient,
+		AdminPhone:      adminPhone,
+		Message:         message,
+		Time:            msgTime,
+		MessageID:       msgID,
+		ParentMessageID: parentMsgID,
+		Status:          "SENT",
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info("✅ Message sent to event sinks successfully")
+	return nil
+}
+
+// Handler for sending audio files and voice notes
+func sendAudioHandler(client *whatsmeow.Client, messageStore *MessageStore, eventSink EventSink) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		req, err := parseMediaRequest(r, "audio")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.Recipient == "" {
+			http.Error(w, "Recipient is required", http.StatusBadRequest)
+			return
+		}
+
+		contextInfo, err := mediaContextInfo(client, messageStore, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mimeType, err := audioMimetype(req.Mimetype, req.data, req.PTT)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		success, msg, msgID, parentMsgID := sendWhatsAppAudioMessage(client, req.Recipient, req.data, mimeType, AudioOptions{
+			PTT:      req.PTT,
+			Seconds:  req.Seconds,
+			Waveform: req.waveform,
+		}, contextInfo)
+		fmt.Println("Message sent", success, msg)
+		if !success {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponseWithLog{Success: false, Message: msg})
+			return
+		}
+
+		s3Key := fmt.Sprintf("whatsapp_failed_files/audio_%d.mp3", time.Now().UnixNano())
+		if strings.HasPrefix(mimeType, "audio/ogg") {
+			s3Key = fmt.Sprintf("whatsapp_failed_files/audio_%d.ogg", time.Now().UnixNano())
+		}
+		err = logSentMedia(client, messageStore, eventSink, req.Recipient, req.AdminPhone, "", msgID, parentMsgID,
+			MediaInfo{MediaType: "audio", Mimetype: mimeType, Filename: req.Filename}, contextInfo.GetMentionedJID(), MediaUpload{Key: s3Key, Data: req.data})
+		if err != nil {
+			// The message itself went out, so only the log copy is missing
+			logger.Error("Failed to log sent media:", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SendMessageResponseWithLog{Success: true, Message: msg, MessageID: msgID})
+	}
+}
+
+// Handler for sending videos with an optional caption and thumbnail
+func sendVideoHandler(client *whatsmeow.Client, messageStore *MessageStore, eventSink EventSink) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		req, err := parseMediaRequest(r, "video")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.Recipient == "" {
+			http.Error(w, "Recipient is required", http.StatusBadRequest)
+			return
+		}
+		if !strings.HasPrefix(req.Mimetype, "video/") {
+			http.Error(w, "File must be a video", http.StatusBadRequest)
+			return
+		}
+		if len(req.thumbnail) > 0 && http.DetectContentType(req.thumbnail) != "image/jpeg" {
+			http.Error(w, "Thumbnail must be a JPEG image", http.StatusBadRequest)
+			return
+		}
+
+		contextInfo, err := mediaContextInfo(client, messageStore, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		opts := VideoOptions{
+			Thumbnail: req.thumbnail,
+			Width:     req.Width,
+			Height:    req.Height,
+			Seconds:   req.Seconds,
+		}
+		success, msg, msgID, parentMsgID := sendWhatsAppVideoMessage(client, req.Recipient, req.Message, req.data, req.Mimetype, opts, contextInfo)
+		fmt.Println("Message sent", success, msg)
+		if !success {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponseWithLog{Success: false, Message: msg})
+			return
+		}
+
+		s3Key := fmt.Sprintf("whatsapp_failed_files/video_%d.mp4", time.Now().UnixNano())
+		err = logSentMedia(client, messageStore, eventSink, req.Recipient, req.AdminPhone, req.Message, msgID, parentMsgID,
+			MediaInfo{MediaType: "video", Mimetype: req.Mimetype, Filename: req.Filename, Caption: req.Message}, contextInfo.GetMentionedJID(), MediaUpload{Key: s3Key, Data: req.data})
+		if err != nil {
+			// The message itself went out, so only the log copy is missing
+			logger.Error("Failed to log sent media:", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SendMessageResponseWithLog{Success: true, Message: msg, MessageID: msgID})
+	}
+}
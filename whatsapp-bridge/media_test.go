@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+// Build the first page of an Ogg stream with one packet
+func oggPage(packet string) []byte {
+	page := []byte("OggS")
+	page = append(page, make([]byte, 22)...) // version to checksum
+	page = append(page, 1, byte(len(packet)))
+	return append(page, packet...)
+}
+
+func TestAudioMimetype(t *testing.T) {
+	opus := oggPage("OpusHead\x01\x02\x38\x01")
+	vorbis := oggPage("\x01vorbis\x00\x00\x00\x00")
+	mp3 := []byte("ID3\x03\x00\x00\x00\x00\x00\x00")
+
+	tests := []struct {
+		name     string
+		mimeType string
+		data     []byte
+		ptt      bool
+		want     string
+		wantErr  bool
+	}{
+		{"opus voice note", "application/ogg", opus, true, voiceNoteMimetype, false},
+		{"opus audio", "application/ogg", opus, false, voiceNoteMimetype, false},
+		{"mp3 voice note", "audio/mpeg", mp3, true, "", true},
+		{"vorbis voice note", "application/ogg", vorbis, true, "", true},
+		{"truncated ogg voice note", "application/ogg", opus[:20], true, "", true},
+		{"mp3 audio", "audio/mpeg", mp3, false, "audio/mpeg", false},
+		{"vorbis audio", "application/ogg", vorbis, false, "audio/ogg", false},
+	}
+	for _, tt := range tests {
+		got, err := audioMimetype(tt.mimeType, tt.data, tt.ptt)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: audioMimetype = %q, %v; want %q, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
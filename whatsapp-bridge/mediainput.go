@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+)
+
+const (
+	defaultMediaMaxBytes     = 100 << 20
+	defaultMediaFetchTimeout = 60 * time.Second
+)
+
+// SendMediaRequest is the body of a media send request. It is read either
+// from multipart/form-data with a file field, or from JSON with exactly one
+// of media_url and media_base64.
+type SendMediaRequest struct {
+	Recipient       string   `json:"recipient"`
+	Message         string   `json:"message"`
+	AdminPhone      string   `json:"admin_phone"`
+	ParentMessageID string   `json:"wa_parent_message_id"`
+	RequireParent   bool     `json:"require_parent"`
+	Mentions        []string `json:"mentions"`
+
+	MediaURL    string `json:"media_url"`
+	MediaBase64 string `json:"media_base64"` // plain base64 or a data: URL
+	Filename    string `json:"filename"`
+	Mimetype    string `json:"mimetype"`
+
+	// Audio and video presentation
+	PTT             bool   `json:"ptt"`
+	Seconds         uint32 `json:"seconds"`
+	Waveform        []int  `json:"waveform"`
+	Width           uint32 `json:"width"`
+	Height          uint32 `json:"height"`
+	ThumbnailBase64 string `json:"thumbnail_base64"`
+
+	data      []byte
+	waveform  []byte
+	thumbnail []byte
+}
+
+// Size and time limits for media, from MEDIA_MAX_BYTES and MEDIA_FETCH_TIMEOUT
+func mediaLimits() (int64, time.Duration) {
+	maxBytes := int64(defaultMediaMaxBytes)
+	if n, err := strconv.ParseInt(os.Getenv("MEDIA_MAX_BYTES"), 10, 64); err == nil && n > 0 {
+		maxBytes = n
+	}
+	timeout := defaultMediaFetchTimeout
+	if d, err := time.ParseDuration(os.Getenv("MEDIA_FETCH_TIMEOUT")); err == nil && d > 0 {
+		timeout = d
+	}
+	return maxBytes, timeout
+}
+
+// Reserved ranges media_url may not reach, on top of loopback, private,
+// link-local and multicast addresses: "this network", carrier-grade NAT,
+// IETF protocol assignments, benchmarking, class E, NAT64 and documentation
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// Report whether ip is a public unicast address
+func isPublicIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsValid() || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Refuse connections to non-public addresses. It runs after DNS resolution,
+// for every connection, so redirects and rebinding cannot get around it.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !isPublicIP(addrPort.Addr()) {
+		return fmt.Errorf("may not connect to the non-public address %s", addrPort.Addr())
+	}
+	return nil
+}
+
+// Create a client for URLs supplied by API callers. It only connects to
+// public addresses, ignores proxy settings, which would hide the address
+// dialled, and follows at most five http(s) redirects. A timeout of zero
+// leaves only the connection and response header timeouts.
+func newPublicOnlyClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: 30 * time.Second,
+				Control: dialPublicOnly,
+			}).DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return fmt.Errorf("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to a non-http URL")
+			}
+			return nil
+		},
+	}
+}
+
+// mediaFetchClient fetches media_url
+var mediaFetchClient = newPublicOnlyClient(0)
+
+// Download media from an http(s) URL within the configured limits. Returns
+// the data, the Content-Type sent by the server and a filename taken from
+// Content-Disposition or the URL path.
+func fetchMedia(ctx context.Context, mediaURL string) ([]byte, string, string, error) {
+	u, err := url.Parse(mediaURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, "", "", fmt.Errorf("media_url must be an http or https URL")
+	}
+
+	maxBytes, timeout := mediaLimits()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	resp, err := mediaFetchClient.Do(req)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to fetch media_url: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, "", "", fmt.Errorf("failed to fetch media_url: %s", resp.Status)
+	}
+	if resp.ContentLength > maxBytes {
+		return nil, "", "", fmt.Errorf("media is larger than %d bytes", maxBytes)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to read media_url: %v", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, "", "", fmt.Errorf("media is larger than %d bytes", maxBytes)
+	}
+
+	filename := path.Base(u.Path)
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		filename = params["filename"]
+	}
+	if filename == "/" || filename == "." {
+		filename = ""
+	}
+	return data, resp.Header.Get("Content-Type"), filename, nil
+}
+
+// Decode base64 media, accepting data: URLs. Returns the data and the
+// mimetype declared by a data: URL, if any.
+func decodeMediaBase64(value string) ([]byte, string, error) {
+	declared := ""
+	if rest, ok := strings.CutPrefix(value, "data:"); ok {
+		header, payload, found := strings.Cut(rest, ",")
+		if !found || !strings.HasSuffix(header, ";base64") {
+			return nil, "", fmt.Errorf("media_base64 data URL must be base64 encoded")
+		}
+		declared = strings.TrimSuffix(header, ";base64")
+		value = payload
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, "", fmt.Errorf("media_base64 is not valid base64: %v", err)
+	}
+	return data, declared, nil
+}
+
+// Load media given in JSON as either a URL or base64. Returns the data, the
+// mimetype declared alongside it and a filename when one is known.
+func loadMedia(ctx context.Context, mediaURL, mediaBase64 string) ([]byte, string, string, error) {
+	switch {
+	case mediaURL != "" && mediaBase64 != "":
+		return nil, "", "", fmt.Errorf("only one of media_url and media_base64 may be given")
+	case mediaURL != "":
+		return fetchMedia(ctx, mediaURL)
+	case mediaBase64 != "":
+		data, declaredType, err := decodeMediaBase64(mediaBase64)
+		if err != nil {
+			return nil, "", "", err
+		}
+		if maxBytes, _ := mediaLimits(); int64(len(data)) > maxBytes {
+			return nil, "", "", fmt.Errorf("media is larger than %d bytes", maxBytes)
+		}
+		return data, declaredType, "", nil
+	default:
+		return nil, "", "", fmt.Errorf("media_url or media_base64 is required")
+	}
+}
+
+// Decide the mimetype and filename of media the same way for every input
+// form. An explicit mimetype wins, then the type declared by the transport
+// (part header, HTTP response or data: URL), then the filename extension and
+// finally the content itself. Missing filenames are made up from the type.
+func detectMediaType(data []byte, explicitType, declaredType, filename, defaultName string) (string, string) {
+	mimeType := explicitType
+	if mimeType == "" {
+		if mediaType, _, err := mime.ParseMediaType(declaredType); err == nil && mediaType != "application/octet-stream" {
+			mimeType = declaredType
+		}
+	}
+	if mimeType == "" && filename != "" {
+		mimeType = mime.TypeByExtension(path.Ext(filename))
+	}
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+
+	if filename == "" {
+		filename = defaultName
+		if mediaType, _, err := mime.ParseMediaType(mimeType); err == nil {
+			if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+				filename += exts[0]
+			}
+		}
+	}
+	return mimeType, filename
+}
+
+// Read a media send request from multipart/form-data or JSON. defaultName
+// is used to name files that arrive without a filename.
+func parseMediaRequest(r *http.Request, defaultName string) (*SendMediaRequest, error) {
+	maxBytes, _ := mediaLimits()
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	req := &SendMediaRequest{}
+	declaredType := ""
+
+	if contentType == "application/json" {
+		// Base64 is a third larger than the media it carries, plus room for the other fields
+		body := http.MaxBytesReader(nil, r.Body, maxBytes/3*4+(1<<20))
+		if err := json.NewDecoder(body).Decode(req); err != nil {
+			return nil, fmt.Errorf("error parsing request body: %v", err)
+		}
+
+		var filename string
+		var err error
+		req.data, declaredType, filename, err = loadMedia(r.Context(), req.MediaURL, req.MediaBase64)
+		if err != nil {
+			return nil, err
+		}
+		if req.Filename == "" {
+			req.Filename = filename
+		}
+
+		if len(req.Waveform) > 0 {
+			samples := make([]string, len(req.Waveform))
+			for i, sample := range req.Waveform {
+				samples[i] = strconv.Itoa(sample)
+			}
+			if req.waveform, err = parseWaveform(strings.Join(samples, ",")); err != nil {
+				return nil, err
+			}
+		}
+		if req.ThumbnailBase64 != "" {
+			if req.thumbnail, _, err = decodeMediaBase64(req.ThumbnailBase64); err != nil {
+				return nil, fmt.Errorf("invalid thumbnail_base64: %v", err)
+			}
+		}
+	} else {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving file")
+		}
+		defer file.Close()
+
+		if header.Size > maxBytes {
+			return nil, fmt.Errorf("media is larger than %d bytes", maxBytes)
+		}
+		if req.data, err = io.ReadAll(file); err != nil {
+			return nil, fmt.Errorf("error reading file")
+		}
+		declaredType = header.Header.Get("Content-Type")
+
+		req.Recipient = r.FormValue("recipient")
+		req.Message = r.FormValue("message")
+		req.AdminPhone = r.FormValue("admin_phone")
+		req.ParentMessageID = r.FormValue("wa_parent_message_id")
+		req.RequireParent, _ = strconv.ParseBool(r.FormValue("require_parent"))
+		req.Mentions = parseMentionsField(r)
+		req.Filename = r.FormValue("filename")
+		if req.Filename == "" {
+			req.Filename = header.Filename
+		}
+		req.Mimetype = r.FormValue("mimetype")
+
+		req.PTT, _ = strconv.ParseBool(r.FormValue("ptt"))
+		if req.Seconds, err = parseUintField(r, "seconds"); err != nil {
+			return nil, err
+		}
+		if req.Width, err = parseUintField(r, "width"); err != nil {
+			return nil, err
+		}
+		if req.Height, err = parseUintField(r, "height"); err != nil {
+			return nil, err
+		}
+		if req.waveform, err = parseWaveform(r.FormValue("waveform")); err != nil {
+			return nil, err
+		}
+		if thumbFile, _, err := r.FormFile("thumbnail"); err == nil {
+			req.thumbnail, err = io.ReadAll(thumbFile)
+			thumbFile.Close()
+			if err != nil {
+				return nil, fmt.Errorf("error reading thumbnail")
+			}
+		}
+	}
+
+	if len(req.data) == 0 {
+		return nil, fmt.Errorf("media is empty")
+	}
+	req.Mimetype, req.Filename = detectMediaType(req.data, req.Mimetype, declaredType, req.Filename, defaultName)
+	return req, nil
+}
+
+// Build the reply and mention context of a media send request
+func mediaContextInfo(client *whatsmeow.Client, messageStore *MessageStore, req *SendMediaRequest) (*waE2E.ContextInfo, error) {
+	contextInfo, err := buildReplyContext(client, messageStore, req.Recipient, req.ParentMessageID, req.RequireParent)
+	if err != nil {
+		return nil, err
+	}
+	return addMentions(client, contextInfo, req.Recipient, req.Mentions)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Collect the mentions form field of a multipart request. Values may be
+// repeated or given as a single comma separated list.
+func parseMentionsField(r *http.Request) []string {
+	var mentions []string
+	for _, value := range r.Form["mentions"] {
+		for _, mention := range strings.Split(value, ",") {
+			if mention = strings.TrimSpace(mention); mention != "" {
+				mentions = append(mentions, mention)
+			}
+		}
+	}
+	return mentions
+}
+
+// Resolve mentioned phone numbers or JIDs against the participants of the
+// recipient group and add them to the context info. The group's own JID for
+// each participant is used so that mentions work in LID-addressed groups.
+// Returns the context info unchanged if there are no mentions.
+func addMentions(client *whatsmeow.Client, contextInfo *waE2E.ContextInfo, recipient string, mentions []string) (*waE2E.ContextInfo, error) {
+	if len(mentions) == 0 {
+		return contextInfo, nil
+	}
+
+	groupJID, err := parseChatJID(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient: %v", err)
+	}
+	if groupJID.Server != types.GroupServer {
+		return nil, fmt.Errorf("mentions are only supported in group chats")
+	}
+
+	groupInfo, err := client.GetGroupInfo(groupJID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group info: %v", err)
+	}
+
+	var mentionedJIDs []string
+	for _, mention := range mentions {
+		jid, err := parseUserJID(mention)
+		if err == nil && jid.User == "" {
+			err = fmt.Errorf("no phone number or user")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid mention '%s': %v", mention, err)
+		}
+
+		found := false
+		for _, participant := range groupInfo.Participants {
+			if participantMatches(participant, jid.User) {
+				mentionedJIDs = append(mentionedJIDs, participant.JID.ToNonAD().String())
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s is not a participant of %s", mention, groupJID)
+		}
+	}
+
+	if contextInfo == nil {
+		contextInfo = &waE2E.ContextInfo{}
+	}
+	contextInfo.MentionedJID = mentionedJIDs
+	return contextInfo, nil
+}
+
+// Report whether user is the participant's user, phone number or LID. Fields
+// the group did not fill in never match.
+func participantMatches(participant types.GroupParticipant, user string) bool {
+	if user == "" {
+		return false
+	}
+	for _, jid := range []types.JID{participant.JID, participant.PhoneNumber, participant.LID} {
+		if jid.User != "" && jid.User == user {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler for listing messages that mention this account, across all chats
+func listMentionsHandler(client *whatsmeow.Client, messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Method not allowed"})
+			return
+		}
+
+		if client.Store.ID == nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Client not logged in"})
+			return
+		}
+
+		query, err := parseMessageQuery(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: err.Error()})
+			return
+		}
+
+		// We may be mentioned by phone number or by LID
+		query.Mentions = []string{client.Store.ID.ToNonAD().String()}
+		if !client.Store.LID.IsEmpty() {
+			query.Mentions = append(query.Mentions, client.Store.LID.ToNonAD().String())
+		}
+
+		chatJID := ""
+		if value := r.URL.Query().Get("chat_jid"); value != "" {
+			jid, err := parseChatJID(value)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Invalid chat_jid: %v", err)})
+				return
+			}
+			chatJID = jid.String()
+		}
+
+		messages, err := messageStore.GetMessages(chatJID, query)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to get messages: %v", err)})
+			return
+		}
+
+		resp := ListMessagesResponse{Messages: messages}
+		if len(messages) == query.Limit {
+			last := messages[len(messages)-1]
+			resp.NextCursor = PageCursor{Time: last.Time, ID: last.ID}.String()
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+func TestParticipantMatches(t *testing.T) {
+	lidOnly := types.GroupParticipant{JID: types.NewJID("1234", types.HiddenUserServer), LID: types.NewJID("1234", types.HiddenUserServer)}
+	withPhone := types.GroupParticipant{
+		JID:         types.NewJID("5678", types.HiddenUserServer),
+		PhoneNumber: types.NewJID("15551234567", types.DefaultUserServer),
+	}
+
+	tests := []struct {
+		participant types.GroupParticipant
+		user        string
+		want        bool
+	}{
+		{lidOnly, "1234", true},
+		{withPhone, "15551234567", true},
+		{withPhone, "5678", true},
+		{lidOnly, "15551234567", false},
+		// A participant without a phone number must not match an empty user
+		{lidOnly, "", false},
+		{withPhone, "", false},
+	}
+	for _, tt := range tests {
+		if got := participantMatches(tt.participant, tt.user); got != tt.want {
+			t.Errorf("participantMatches(%v, %q) = %v, want %v", tt.participant.JID, tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestParseMentionsFieldSkipsBlanks(t *testing.T) {
+	r := &http.Request{Form: url.Values{"mentions": {"+1 555 0100, ,15550101", " "}}}
+	got := parseMentionsField(r)
+	if strings.Join(got, "|") != "+1 555 0100|15550101" {
+		t.Errorf("parseMentionsField = %q", got)
+	}
+}
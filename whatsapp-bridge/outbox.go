@@ -0,0 +1,718 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"go.mau.fi/libsignal/logger"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	outboxMediaDir           = "store/outbox"
+	localMediaDir            = "store/media"
+	defaultOutboxMaxAttempts = 10
+	defaultOutboxBackoffBase = 5 * time.Second
+	defaultOutboxBackoffMax  = time.Hour
+)
+
+// OutboxEntry is an event waiting to be delivered to the event sinks. Entries
+// are removed once delivered; those that ran out of attempts stay as "dead"
+// until they are retried or purged.
+type OutboxEntry struct {
+	ID            int64                `json:"id"`
+	Status        string               `json:"status"` // "pending", "dead"
+	Attempts      int                  `json:"attempts"`
+	LastError     string               `json:"last_error,omitempty"`
+	NextAttemptAt time.Time            `json:"next_attempt_at"`
+	MediaKey      string               `json:"media_key,omitempty"` // S3 key of a file not stored yet
+	Event         WALogMessageForQueue `json:"event"`
+	CreatedAt     time.Time            `json:"created_at"`
+	UpdatedAt     time.Time            `json:"updated_at"`
+	mediaPath     string
+	chatJID       string
+	mediaSource   []byte
+}
+
+// MediaUpload is a file to store before its event is delivered
+type MediaUpload struct {
+	Key      string // S3 key; its base name names the local copy without S3
+	ChatJID  string // chat of the message, to store the media URL
+	Data     []byte
+	Location string // where the file is when it was already stored, e.g. for another recipient
+	Uploaded bool   // whether Location is in S3
+	Source   []byte // received message to download the file from when Data could not be fetched
+}
+
+// Store an event in the outbox. A media file is written next to the
+// database first and removed again if the entry cannot be stored.
+func (store *MessageStore) EnqueueOutbox(event WALogMessageForQueue, upload *MediaUpload) (int64, error) {
+	id, _, err := enqueueOutbox(store.db, event, upload)
+	return id, err
+}
+
+// EnqueueOutbox on the database or a transaction. Also returns the path of
+// the media file written, which the caller removes if the transaction is
+// rolled back.
+func enqueueOutbox(db execer, event WALogMessageForQueue, upload *MediaUpload) (int64, string, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var mediaPath, mediaKey, chatJID string
+	var mediaSource []byte
+	if upload != nil && upload.Data == nil && upload.Source != nil {
+		mediaKey, chatJID, mediaSource = upload.Key, upload.ChatJID, upload.Source
+	} else if upload != nil {
+		name, err := newJobID()
+		if err != nil {
+			return 0, "", err
+		}
+		if err := os.MkdirAll(outboxMediaDir, 0755); err != nil {
+			return 0, "", err
+		}
+		mediaPath = filepath.Join(outboxMediaDir, name)
+		if err := os.WriteFile(mediaPath, upload.Data, 0644); err != nil {
+			return 0, "", err
+		}
+		mediaKey, chatJID = upload.Key, upload.ChatJID
+	}
+
+	now := time.Now().UTC()
+	result, err := db.Exec(
+		`INSERT INTO outbox (event, media_path, media_key, chat_jid, media_source, status, attempts, last_error, next_attempt_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, 'pending', 0, '', ?, ?, ?)`,
+		string(body), mediaPath, mediaKey, chatJID, mediaSource, now, now, now,
+	)
+	if err != nil {
+		if mediaPath != "" {
+			os.Remove(mediaPath)
+		}
+		return 0, "", err
+	}
+	id, err := result.LastInsertId()
+	return id, mediaPath, err
+}
+
+const outboxColumns = `id, status, attempts, last_error, next_attempt_at, media_key, event, created_at, updated_at, media_path, chat_jid, media_source`
+
+// Scan an outbox row
+func scanOutboxEntry(row interface{ Scan(...interface{}) error }, e *OutboxEntry) error {
+	var event string
+	err := row.Scan(&e.ID, &e.Status, &e.Attempts, &e.LastError, &e.NextAttemptAt, &e.MediaKey, &event,
+		&e.CreatedAt, &e.UpdatedAt, &e.mediaPath, &e.chatJID, &e.mediaSource)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(event), &e.Event); err != nil {
+		return fmt.Errorf("invalid event in outbox entry %d: %v", e.ID, err)
+	}
+	if e.Event.File != "" {
+		e.MediaKey = ""
+	}
+	return nil
+}
+
+// Get the pending entry due first. Returns sql.ErrNoRows if nothing is pending.
+func (store *MessageStore) nextOutboxEntry() (OutboxEntry, error) {
+	var e OutboxEntry
+	row := store.db.QueryRow(
+		`SELECT ` + outboxColumns + ` FROM outbox WHERE status = 'pending' ORDER BY next_attempt_at, id LIMIT 1`,
+	)
+	err := scanOutboxEntry(row, &e)
+	return e, err
+}
+
+// List outbox entries, optionally only those with the given status
+func (store *MessageStore) ListOutbox(status string, limit int) ([]OutboxEntry, error) {
+	query := `SELECT ` + outboxColumns + ` FROM outbox`
+	args := []interface{}{}
+	if status != "" {
+		query += ` WHERE status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY id LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []OutboxEntry{}
+	for rows.Next() {
+		var e OutboxEntry
+		if err := scanOutboxEntry(rows, &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Replace the event of an entry, e.g. once its file has been uploaded
+func (store *MessageStore) setOutboxEvent(id int64, event WALogMessageForQueue) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(`UPDATE outbox SET event = ?, updated_at = ? WHERE id = ?`, string(body), time.Now().UTC(), id)
+	return err
+}
+
+// Record a failed attempt. The entry is retried at nextAttempt, or marked
+// dead if dead is set.
+func (store *MessageStore) failOutboxEntry(id int64, attempts int, deliveryErr error, nextAttempt time.Time, dead bool) error {
+	status := "pending"
+	if dead {
+		status = "dead"
+	}
+	_, err := store.db.Exec(
+		`UPDATE outbox SET status = ?, attempts = ?, last_error = ?, next_attempt_at = ?, updated_at = ? WHERE id = ?`,
+		status, attempts, deliveryErr.Error(), nextAttempt.UTC(), time.Now().UTC(), id,
+	)
+	return err
+}
+
+// Remove a delivered entry and its media file
+func (store *MessageStore) deleteOutboxEntry(e OutboxEntry) error {
+	if _, err := store.db.Exec(`DELETE FROM outbox WHERE id = ?`, e.ID); err != nil {
+		return err
+	}
+	if e.mediaPath != "" {
+		os.Remove(e.mediaPath)
+	}
+	return nil
+}
+
+// Make dead entries pending again with a fresh set of attempts. With id 0
+// every dead entry is retried. Returns the number of entries retried.
+func (store *MessageStore) RetryOutbox(id int64) (int64, error) {
+	query := `UPDATE outbox SET status = 'pending', attempts = 0, next_attempt_at = ?, updated_at = ? WHERE status = 'dead'`
+	now := time.Now().UTC()
+	args := []interface{}{now, now}
+	if id != 0 {
+		query += ` AND id = ?`
+		args = append(args, id)
+	}
+	result, err := store.db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Delete dead entries and their media files. With id 0 every dead entry is
+// purged. Returns the number of entries purged.
+func (store *MessageStore) PurgeOutbox(id int64) (int64, error) {
+	query := `SELECT ` + outboxColumns + ` FROM outbox WHERE status = 'dead'`
+	args := []interface{}{}
+	if id != 0 {
+		query += ` AND id = ?`
+		args = append(args, id)
+	}
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	var dead []OutboxEntry
+	for rows.Next() {
+		var e OutboxEntry
+		if err := scanOutboxEntry(rows, &e); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		dead = append(dead, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, e := range dead {
+		if err := store.deleteOutboxEntry(e); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(dead)), nil
+}
+
+// Outbox is an EventSink that stores every event in the database before a
+// background worker delivers it to the configured sinks. Failed deliveries
+// are retried with exponential backoff, so a sink or S3 outage delays events
+// instead of losing them. Delivery is at least once: an event may reach a
+// sink twice when another sink failed.
+type Outbox struct {
+	messageStore *MessageStore
+	sink         EventSink
+	wake         chan struct{}
+	maxAttempts  int
+	backoffBase  time.Duration
+	backoffMax   time.Duration
+	download     func(context.Context, whatsmeow.DownloadableMessage) ([]byte, error)
+}
+
+// Wrap sink in an outbox configured by OUTBOX_MAX_ATTEMPTS,
+// OUTBOX_BACKOFF_BASE and OUTBOX_BACKOFF_MAX
+func newOutbox(messageStore *MessageStore, sink EventSink) *Outbox {
+	o := &Outbox{
+		messageStore: messageStore,
+		sink:         sink,
+		wake:         make(chan struct{}, 1),
+		maxAttempts:  defaultOutboxMaxAttempts,
+		backoffBase:  defaultOutboxBackoffBase,
+		backoffMax:   defaultOutboxBackoffMax,
+	}
+	if n, err := strconv.Atoi(os.Getenv("OUTBOX_MAX_ATTEMPTS")); err == nil && n > 0 {
+		o.maxAttempts = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("OUTBOX_BACKOFF_BASE")); err == nil && d > 0 {
+		o.backoffBase = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("OUTBOX_BACKOFF_MAX")); err == nil && d > 0 {
+		o.backoffMax = d
+	}
+	return o
+}
+
+func (o *Outbox) Send(ctx context.Context, event WALogMessageForQueue) error {
+	return o.SendWithMedia(event, nil)
+}
+
+// SendWithMedia stores an event whose file still has to be uploaded to S3
+func (o *Outbox) SendWithMedia(event WALogMessageForQueue, upload *MediaUpload) error {
+	if _, err := o.messageStore.EnqueueOutbox(event, upload); err != nil {
+		return fmt.Errorf("outbox: %w", err)
+	}
+	o.Notify()
+	return nil
+}
+
+// Store a message we sent and its events in one transaction. upload is the
+// file of the first event, if any.
+func (o *Outbox) sendWithMessage(recipient string, msg Message, upload *MediaUpload, events []WALogMessageForQueue) error {
+	tx, err := o.messageStore.db.Begin()
+	if err != nil {
+		return fmt.Errorf("outbox: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := storeSentMessage(tx, recipient, msg); err != nil {
+		return fmt.Errorf("error storing sent message: %w", err)
+	}
+	var written []string
+	removeWritten := func() {
+		for _, path := range written {
+			os.Remove(path)
+		}
+	}
+	for i, event := range events {
+		var eventUpload *MediaUpload
+		if i == 0 && upload != nil {
+			if upload.Location == "" {
+				eventUpload = upload
+			} else {
+				event.File = upload.Location
+				if upload.Uploaded && upload.ChatJID != "" {
+					if err := setMediaURL(tx, event.MessageID, upload.ChatJID, upload.Location); err != nil {
+						removeWritten()
+						return fmt.Errorf("outbox: %w", err)
+					}
+				}
+			}
+		}
+		_, mediaPath, err := enqueueOutbox(tx, event, eventUpload)
+		if err != nil {
+			removeWritten()
+			return fmt.Errorf("outbox: %w", err)
+		}
+		if mediaPath != "" {
+			written = append(written, mediaPath)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		removeWritten()
+		return fmt.Errorf("outbox: %w", err)
+	}
+	o.Notify()
+	return nil
+}
+
+func (o *Outbox) Close() error {
+	return o.sink.Close()
+}
+
+// Notify wakes the worker up after entries were added or retried
+func (o *Outbox) Notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers pending entries as they become due
+func (o *Outbox) Run() {
+	for {
+		e, err := o.messageStore.nextOutboxEntry()
+		if err == sql.ErrNoRows {
+			<-o.wake
+			continue
+		} else if err != nil {
+			logger.Error("❌ Failed to load outbox:", err)
+			time.Sleep(30 * time.Second)
+			continue
+		}
+
+		if wait := time.Until(e.NextAttemptAt); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-o.wake:
+				timer.Stop()
+			}
+			continue
+		}
+
+		if err := o.deliver(e); err != nil {
+			o.fail(e, err)
+		}
+	}
+}
+
+// Store the entry's file if needed, then send the event to the sinks
+func (o *Outbox) deliver(e OutboxEntry) error {
+	if (e.mediaPath != "" || e.mediaSource != nil) && e.Event.File == "" {
+		var data []byte
+		var err error
+		if e.mediaPath != "" {
+			data, err = os.ReadFile(e.mediaPath)
+			if err != nil {
+				return fmt.Errorf("error reading media file: %v", err)
+			}
+		} else {
+			data, err = o.downloadSource(e.mediaSource)
+			if err != nil {
+				return err
+			}
+			e.Event.FileLength = uint64(len(data))
+		}
+		location, uploaded, err := storeMediaFile(e.MediaKey, data, sinkNeedsMediaURL(o.sink))
+		if err != nil {
+			return err
+		}
+		e.Event.File = location
+		if uploaded && e.chatJID != "" {
+			if err := o.messageStore.SetMediaURL(e.Event.MessageID, e.chatJID, location); err != nil {
+				logger.Error("Failed to store media URL:", err)
+			}
+		}
+		// Keep the location so a failed delivery does not store the file again
+		if err := o.messageStore.setOutboxEvent(e.ID, e.Event); err != nil {
+			return err
+		}
+	}
+
+	if err := o.sink.Send(context.Background(), e.Event); err != nil {
+		return err
+	}
+	if err := o.messageStore.deleteOutboxEntry(e); err != nil {
+		logger.Error("❌ Failed to remove delivered outbox entry:", err)
+	}
+	return nil
+}
+
+// Download the file of a received message whose download failed when it
+// arrived
+func (o *Outbox) downloadSource(source []byte) ([]byte, error) {
+	if o.download == nil {
+		return nil, fmt.Errorf("no WhatsApp client to download media with")
+	}
+	var msg waE2E.Message
+	if err := proto.Unmarshal(source, &msg); err != nil {
+		return nil, fmt.Errorf("invalid media message: %v", err)
+	}
+	downloadable := mediaDownloadable(&msg)
+	if downloadable == nil {
+		return nil, fmt.Errorf("message has no media to download")
+	}
+	data, err := o.download(context.Background(), downloadable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download media: %v", err)
+	}
+	return data, nil
+}
+
+// Schedule the next attempt of a failed entry, or give up on it
+func (o *Outbox) fail(e OutboxEntry, deliveryErr error) {
+	attempts := e.Attempts + 1
+	dead := attempts >= o.maxAttempts
+	if dead {
+		logger.Error(fmt.Sprintf("❌ Outbox entry %d failed %d times, giving up: %v", e.ID, attempts, deliveryErr))
+	} else {
+		logger.Error(fmt.Sprintf("⚠️ Outbox entry %d failed (attempt %d): %v", e.ID, attempts, deliveryErr))
+	}
+	if err := o.messageStore.failOutboxEntry(e.ID, attempts, deliveryErr, time.Now().Add(o.backoff(attempts)), dead); err != nil {
+		logger.Error("❌ Failed to update outbox entry:", err)
+		time.Sleep(30 * time.Second)
+	}
+}
+
+// Wait before the next attempt: the base delay doubled after every failure,
+// up to the maximum
+func (o *Outbox) backoff(attempts int) time.Duration {
+	delay := o.backoffBase
+	for i := 1; i < attempts && delay < o.backoffMax; i++ {
+		delay *= 2
+	}
+	if delay > o.backoffMax {
+		delay = o.backoffMax
+	}
+	return delay
+}
+
+// Put a media file where its event can point to: S3 when AWS_S3_BUCKET_NAME
+// is set, store/media otherwise so the bridge works without AWS. Returns the
+// URL or path and whether the file went to S3. needURL is set for the SQS
+// sink, whose log API consumer fetches files by URL and cannot use a path.
+func storeMediaFile(key string, data []byte, needURL bool) (string, bool, error) {
+	if bucket := os.Getenv("AWS_S3_BUCKET_NAME"); bucket != "" {
+		url, err := uploadToS3(bucket, key, data)
+		if err != nil {
+			return "", false, fmt.Errorf("error uploading file to S3: %v", err)
+		}
+		return url, true, nil
+	}
+	if needURL {
+		return "", false, fmt.Errorf("AWS_S3_BUCKET_NAME is not set, but the SQS sink needs media in S3")
+	}
+
+	if err := os.MkdirAll(localMediaDir, 0755); err != nil {
+		return "", false, fmt.Errorf("error storing media file: %v", err)
+	}
+	path := filepath.Join(localMediaDir, filepath.Base(key))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", false, fmt.Errorf("error storing media file: %v", err)
+	}
+	return path, false, nil
+}
+
+// Publish a log entry whose file still has to be stored. Through the
+// outbox storing it is retried with the delivery; other sinks store it now.
+// A file already stored is not stored again.
+func publishMediaEvent(message WALogMessageForQueue, eventSink EventSink, messageStore *MessageStore, upload MediaUpload) error {
+	message.FileLength = uint64(len(upload.Data))
+	if outbox, ok := eventSink.(*Outbox); ok && upload.Location == "" {
+		if err := outbox.SendWithMedia(message, &upload); err != nil {
+			return fmt.Errorf("error publishing event: %w", err)
+		}
+		fmt.Println("✅ Event published successfully")
+		return nil
+	}
+
+	if upload.Data == nil && upload.Source != nil {
+		// Without the outbox there is nothing to retry the download
+		return publishEvent(message, eventSink)
+	}
+	if upload.Location == "" {
+		var err error
+		upload.Location, upload.Uploaded, err = storeMediaFile(upload.Key, upload.Data, sinkNeedsMediaURL(eventSink))
+		if err != nil {
+			return err
+		}
+	}
+	if upload.Uploaded && upload.ChatJID != "" {
+		messageStore.SetMediaURL(message.MessageID, upload.ChatJID, upload.Location)
+	}
+	message.File = upload.Location
+	return publishEvent(message, eventSink)
+}
+
+// Store a message we sent and publish its events. Through the outbox both go
+// in one transaction, so a crash cannot leave a stored message without its
+// events. upload is the file of the first event, if any.
+func logSentMessage(messageStore *MessageStore, eventSink EventSink, recipient string, msg Message, upload *MediaUpload, events ...WALogMessageForQueue) error {
+	if upload != nil {
+		events[0].FileLength = uint64(len(upload.Data))
+		if chatJID, err := parseChatJID(recipient); err == nil {
+			upload.ChatJID = chatJID.String()
+		}
+	}
+	if outbox, ok := eventSink.(*Outbox); ok {
+		if err := outbox.sendWithMessage(recipient, msg, upload, events); err != nil {
+			return err
+		}
+		fmt.Println("✅ Event published successfully")
+		return nil
+	}
+
+	if err := storeSentMessage(messageStore.db, recipient, msg); err != nil {
+		logger.Error("Failed to store sent message:", err)
+	}
+	for i, event := range events {
+		var err error
+		if i == 0 && upload != nil {
+			err = publishMediaEvent(event, eventSink, messageStore, *upload)
+		} else {
+			err = publishEvent(event, eventSink)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The media part of a message, if it has one
+func mediaDownloadable(msg *waE2E.Message) whatsmeow.DownloadableMessage {
+	switch {
+	case msg.ImageMessage != nil:
+		return msg.ImageMessage
+	case msg.VideoMessage != nil:
+		return msg.VideoMessage
+	case msg.AudioMessage != nil:
+		return msg.AudioMessage
+	case msg.DocumentMessage != nil:
+		return msg.DocumentMessage
+	case msg.StickerMessage != nil:
+		return msg.StickerMessage
+	}
+	return nil
+}
+
+// Download the file of a received media message. When that fails the upload
+// carries the message instead, so the outbox downloads the file when it
+// delivers the event and the event is retried rather than lost.
+func downloadReceivedMedia(client *whatsmeow.Client, msg *waE2E.Message, key, chatJID string) (MediaUpload, error) {
+	upload := MediaUpload{Key: key, ChatJID: chatJID}
+	data, err := client.Download(context.Background(), mediaDownloadable(msg))
+	if err == nil {
+		upload.Data = data
+		return upload, nil
+	}
+	logger.Error("⚠️ Failed to download media, the outbox will retry:", err)
+	source, marshalErr := proto.Marshal(msg)
+	if marshalErr != nil {
+		return upload, fmt.Errorf("%v, and the message cannot be kept to retry: %v", err, marshalErr)
+	}
+	upload.Source = source
+	return upload, nil
+}
+
+// Report whether events published to sink need their files in S3 rather than
+// on local disk
+func sinkNeedsMediaURL(sink EventSink) bool {
+	if outbox, ok := sink.(*Outbox); ok {
+		sink = outbox.sink
+	}
+	return findSQSSink(sink) != nil
+}
+
+// Write an outbox error as JSON
+func writeOutboxError(w http.ResponseWriter, err error) {
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to access outbox: %v", err)})
+}
+
+// Parse the {id} path value of an outbox request; 0 when there is none
+func outboxEntryID(r *http.Request) (int64, error) {
+	value := r.PathValue("id")
+	if value == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid outbox entry ID")
+	}
+	return id, nil
+}
+
+// Handler for listing outbox entries and purging dead ones. DELETE on
+// /api/outbox purges every dead entry, on /api/outbox/{id} only that one.
+func outboxHandler(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := outboxEntryID(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodGet && id == 0:
+			status := r.URL.Query().Get("status")
+			if status != "" && status != "pending" && status != "dead" {
+				http.Error(w, "status must be pending or dead", http.StatusBadRequest)
+				return
+			}
+			limit := 100
+			if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 1000 {
+				limit = n
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			entries, err := messageStore.ListOutbox(status, limit)
+			if err != nil {
+				writeOutboxError(w, err)
+				return
+			}
+			json.NewEncoder(w).Encode(entries)
+
+		case r.Method == http.MethodDelete:
+			w.Header().Set("Content-Type", "application/json")
+			n, err := messageStore.PurgeOutbox(id)
+			if err != nil {
+				writeOutboxError(w, err)
+				return
+			}
+			if id != 0 && n == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "No dead outbox entry with that ID"})
+				return
+			}
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: true, Message: fmt.Sprintf("Purged %d entries", n)})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// Handler for retrying dead outbox entries: all of them on
+// /api/outbox/retry, one on /api/outbox/{id}/retry
+func retryOutboxHandler(messageStore *MessageStore, outbox *Outbox) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := outboxEntryID(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		n, err := messageStore.RetryOutbox(id)
+		if err != nil {
+			writeOutboxError(w, err)
+			return
+		}
+		if id != 0 && n == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "No dead outbox entry with that ID"})
+			return
+		}
+		outbox.Notify()
+		json.NewEncoder(w).Encode(SendMessageResponse{Success: true, Message: fmt.Sprintf("Retrying %d entries", n)})
+	}
+}
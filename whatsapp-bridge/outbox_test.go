@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+// Open a message store in a temporary directory
+func newTestStore(t *testing.T) *MessageStore {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	store, err := NewMessageStore()
+	if err != nil {
+		t.Fatalf("NewMessageStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestOutboxDeliversMediaWithoutS3(t *testing.T) {
+	t.Setenv("AWS_S3_BUCKET_NAME", "")
+	store := newTestStore(t)
+	sink := newMemorySink(0)
+	outbox := newOutbox(store, sink)
+
+	err := outbox.SendWithMedia(WALogMessageForQueue{Type: "image", MessageID: "m1"},
+		&MediaUpload{Key: "whatsapp_failed_files/image_1.jpg", Data: []byte("jpeg")})
+	if err != nil {
+		t.Fatalf("SendWithMedia: %v", err)
+	}
+	e, err := store.nextOutboxEntry()
+	if err != nil {
+		t.Fatalf("nextOutboxEntry: %v", err)
+	}
+	if err := outbox.deliver(e); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+
+	events := sink.Events()
+	if len(events) != 1 {
+		t.Fatalf("sink got %d events, want 1", len(events))
+	}
+	if got, want := events[0].File, "store/media/image_1.jpg"; got != want {
+		t.Errorf("File = %q, want %q", got, want)
+	}
+	if data, err := os.ReadFile(events[0].File); err != nil || string(data) != "jpeg" {
+		t.Errorf("stored media = %q, %v", data, err)
+	}
+	if _, err := os.Stat(e.mediaPath); !os.IsNotExist(err) {
+		t.Errorf("outbox spool file still exists: %v", err)
+	}
+	if _, err := store.nextOutboxEntry(); err != sql.ErrNoRows {
+		t.Errorf("outbox not empty after delivery: %v", err)
+	}
+}
+
+func TestOutboxNeedsS3ForSQS(t *testing.T) {
+	t.Setenv("AWS_S3_BUCKET_NAME", "")
+	store := newTestStore(t)
+	outbox := newOutbox(store, MultiSink{newMemorySink(0), &SQSSink{}})
+
+	if err := outbox.SendWithMedia(WALogMessageForQueue{Type: "video"}, &MediaUpload{Key: "v.mp4", Data: []byte("mp4")}); err != nil {
+		t.Fatalf("SendWithMedia: %v", err)
+	}
+	e, err := store.nextOutboxEntry()
+	if err != nil {
+		t.Fatalf("nextOutboxEntry: %v", err)
+	}
+	if err := outbox.deliver(e); err == nil {
+		t.Fatal("deliver succeeded without a bucket for the SQS sink")
+	}
+}
+
+func TestPublishMediaEventReusesStoredFile(t *testing.T) {
+	store := newTestStore(t)
+	sink := newMemorySink(0)
+	outbox := newOutbox(store, sink)
+
+	// Every recipient of a broadcast points at the one stored copy
+	for _, id := range []string{"m1", "m2"} {
+		err := publishMediaEvent(WALogMessageForQueue{Type: "video", MessageID: id}, outbox, store,
+			MediaUpload{Key: "whatsapp_failed_files/broadcast_b1.mp4", Data: []byte("mp4"), Location: "store/media/broadcast_b1.mp4"})
+		if err != nil {
+			t.Fatalf("publishMediaEvent: %v", err)
+		}
+	}
+
+	entries, err := store.ListOutbox("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("outbox has %d entries, want 2", len(entries))
+	}
+	for _, e := range entries {
+		if e.mediaPath != "" || e.Event.File != "store/media/broadcast_b1.mp4" || e.Event.FileLength != 3 {
+			t.Errorf("entry %d: media path %q, file %q, length %d; want the stored copy", e.ID, e.mediaPath, e.Event.File, e.Event.FileLength)
+		}
+	}
+	if _, err := os.Stat(outboxMediaDir); !os.IsNotExist(err) {
+		t.Errorf("media was spooled to the outbox again: %v", err)
+	}
+}
+
+func TestLogSentMessageStoresMessageWithEvents(t *testing.T) {
+	store := newTestStore(t)
+	outbox := newOutbox(store, newMemorySink(0))
+	chat := "123@s.whatsapp.net"
+
+	err := logSentMessage(store, outbox, chat, Message{ID: "m1", Sender: "456", Content: "hi", Time: time.Now()}, nil,
+		WALogMessageForQueue{Type: "contact", MessageID: "m1"}, WALogMessageForQueue{Type: "contact", MessageID: "m1"})
+	if err != nil {
+		t.Fatalf("logSentMessage: %v", err)
+	}
+	var count int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM messages WHERE id = 'm1' AND chat_jid = ?", chat).Scan(&count); err != nil || count != 1 {
+		t.Errorf("stored messages = %d, %v, want 1", count, err)
+	}
+	entries, err := store.ListOutbox("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("outbox has %d entries, want 2", len(entries))
+	}
+
+	// Nothing is queued when the message cannot be stored
+	if _, err := store.db.Exec("DROP TABLE messages"); err != nil {
+		t.Fatal(err)
+	}
+	err = logSentMessage(store, outbox, chat, Message{ID: "m2", Sender: "456", Content: "hi", Time: time.Now()}, nil,
+		WALogMessageForQueue{Type: "text", MessageID: "m2"})
+	if err == nil {
+		t.Fatal("logSentMessage succeeded without a messages table")
+	}
+	if entries, err := store.ListOutbox("", 10); err != nil || len(entries) != 2 {
+		t.Errorf("outbox has %d entries, %v, want the 2 from before", len(entries), err)
+	}
+}
+
+func TestOutboxRetriesFailedMediaDownload(t *testing.T) {
+	t.Setenv("AWS_S3_BUCKET_NAME", "")
+	store := newTestStore(t)
+	sink := newMemorySink(0)
+	outbox := newOutbox(store, sink)
+
+	source, err := proto.Marshal(&waE2E.Message{ImageMessage: &waE2E.ImageMessage{DirectPath: proto.String("/v/t62/1")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = publishMediaEvent(WALogMessageForQueue{Type: "image", MessageID: "m1"}, outbox, store,
+		MediaUpload{Key: "whatsapp_failed_files/image_1.jpg", Source: source})
+	if err != nil {
+		t.Fatalf("publishMediaEvent: %v", err)
+	}
+
+	downloadErr := errors.New("media server unavailable")
+	outbox.download = func(ctx context.Context, msg whatsmeow.DownloadableMessage) ([]byte, error) {
+		if downloadErr != nil {
+			return nil, downloadErr
+		}
+		if msg.GetDirectPath() != "/v/t62/1" {
+			t.Errorf("downloaded %q, want the received image", msg.GetDirectPath())
+		}
+		return []byte("jpeg"), nil
+	}
+	e, err := store.nextOutboxEntry()
+	if err != nil {
+		t.Fatalf("nextOutboxEntry: %v", err)
+	}
+	if err := outbox.deliver(e); err == nil {
+		t.Fatal("deliver succeeded while the download fails")
+	}
+
+	downloadErr = nil
+	if err := outbox.deliver(e); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	events := sink.Events()
+	if len(events) != 1 || events[0].File != "store/media/image_1.jpg" || events[0].FileLength != 4 {
+		t.Fatalf("sink got %+v, want the downloaded image", events)
+	}
+	if data, err := os.ReadFile(events[0].File); err != nil || string(data) != "jpeg" {
+		t.Errorf("stored media = %q, %v", data, err)
+	}
+}
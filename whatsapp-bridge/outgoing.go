@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/libsignal/logger"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+)
+
+// OutgoingMessage is a text or media message sent by a background job
+// rather than directly by a REST request
+type OutgoingMessage struct {
+	Message    string `json:"message"`
+	MediaType  string `json:"media_type,omitempty"` // "", "image", "document", "audio", "video", "sticker"
+	Mimetype   string `json:"mimetype,omitempty"`
+	Filename   string `json:"filename,omitempty"`
+	AdminPhone string `json:"admin_phone,omitempty"`
+	Media      []byte `json:"-"`
+}
+
+// Check an outgoing message and load its media from media_url or
+// media_base64, so a job fails when it is created rather than when it runs
+func (out *OutgoingMessage) prepare(ctx context.Context, mediaURL, mediaBase64 string) error {
+	if out.MediaType == "" {
+		if mediaURL != "" || mediaBase64 != "" {
+			return fmt.Errorf("media_type is required when sending media")
+		}
+		if out.Message == "" {
+			return fmt.Errorf("message is required")
+		}
+		return nil
+	}
+
+	switch out.MediaType {
+	case "image", "document", "audio", "video", "sticker":
+	default:
+		return fmt.Errorf("unsupported media type %q", out.MediaType)
+	}
+
+	data, declaredType, filename, err := loadMedia(ctx, mediaURL, mediaBase64)
+	if err != nil {
+		return err
+	}
+	if out.Filename == "" {
+		out.Filename = filename
+	}
+	out.Mimetype, out.Filename = detectMediaType(data, out.Mimetype, declaredType, out.Filename, out.MediaType)
+
+	switch out.MediaType {
+	case "image":
+		if !strings.HasPrefix(out.Mimetype, "image/") {
+			return fmt.Errorf("file must be an image")
+		}
+	case "video":
+		if !strings.HasPrefix(out.Mimetype, "video/") {
+			return fmt.Errorf("file must be a video")
+		}
+	case "audio":
+		if out.Mimetype, err = audioMimetype(out.Mimetype, data, false); err != nil {
+			return err
+		}
+	case "sticker":
+		if data, _, err = prepareSticker(data); err != nil {
+			return err
+		}
+		out.Mimetype = "image/webp"
+	}
+	out.Media = data
+	return nil
+}
+
+// S3 key for a copy of sent media, named the same way as the send endpoints
+func mediaS3Key(mediaType, mimeType string) string {
+	return fmt.Sprintf("whatsapp_failed_files/%s_%d%s", mediaType, time.Now().UnixNano(), mediaExtension(mediaType, mimeType))
+}
+
+// Extension of the stored copy of sent media
+func mediaExtension(mediaType, mimeType string) string {
+	ext := ".jpg"
+	switch mediaType {
+	case "document":
+		ext = ".pdf"
+	case "audio":
+		ext = ".mp3"
+		if strings.HasPrefix(mimeType, "audio/ogg") {
+			ext = ".ogg"
+		}
+	case "video":
+		ext = ".mp4"
+	case "sticker":
+		ext = ".webp"
+	}
+	return ext
+}
+
+// sharedMedia is the media of an outgoing message sent to many recipients,
+// such as a broadcast. It is uploaded to WhatsApp and stored for the event
+// sinks once, and every recipient's message and event reuse those copies.
+type sharedMedia struct {
+	key    string // stored media key of every recipient's event
+	upload *whatsmeow.UploadResponse
+	stored MediaUpload // Location is set once the file is stored
+}
+
+func newSharedMedia(key string) *sharedMedia {
+	return &sharedMedia{key: key}
+}
+
+// WhatsApp media type an outgoing media type is uploaded as
+var outgoingUploadTypes = map[string]whatsmeow.MediaType{
+	"image":    whatsmeow.MediaImage,
+	"document": whatsmeow.MediaDocument,
+	"audio":    whatsmeow.MediaAudio,
+	"video":    whatsmeow.MediaVideo,
+	"sticker":  whatsmeow.MediaImage, // stickers are uploaded as images
+}
+
+// Send an outgoing message to one recipient and store and queue it the same
+// way the REST send endpoints do. Media is uploaded and stored through
+// shared, which may be nil when there is only one recipient. Returns the
+// WhatsApp message ID.
+func sendOutgoingMessage(client *whatsmeow.Client, messageStore *MessageStore, eventSink EventSink, recipient string, out OutgoingMessage, shared *sharedMedia) (string, error) {
+	if out.MediaType == "" {
+		req := SendMessageRequest{Recipient: recipient, Message: out.Message, AdminPhone: out.AdminPhone}
+		success, msg, msgID := sendTextMessage(client, messageStore, eventSink, req, nil, WALogMessageForQueue{})
+		if !success {
+			return "", fmt.Errorf("%s", msg)
+		}
+		return msgID, nil
+	}
+
+	uploadType, ok := outgoingUploadTypes[out.MediaType]
+	if !ok {
+		return "", fmt.Errorf("unsupported media type %q", out.MediaType)
+	}
+	if !client.IsConnected() {
+		return "", fmt.Errorf("Not connected to WhatsApp")
+	}
+	recipientJID, err := parseChatJID(recipient)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing JID: %v", err)
+	}
+	single := shared == nil
+	if single {
+		shared = newSharedMedia(mediaS3Key(out.MediaType, out.Mimetype))
+	}
+	if shared.upload == nil {
+		resp, err := client.Upload(context.Background(), out.Media, uploadType)
+		if err != nil {
+			return "", fmt.Errorf("Error uploading %s: %v", out.MediaType, err)
+		}
+		shared.upload = &resp
+	}
+
+	caption := out.Message
+	var message *waE2E.Message
+	switch out.MediaType {
+	case "image":
+		message = imageMessage(*shared.upload, out.Message, out.Mimetype, nil)
+	case "document":
+		message = documentMessage(*shared.upload, out.Message, out.Filename, out.Mimetype, nil)
+	case "audio":
+		message, caption = audioMessage(*shared.upload, out.Mimetype, AudioOptions{}, nil), ""
+	case "video":
+		message = videoMessage(*shared.upload, out.Message, out.Mimetype, VideoOptions{}, nil)
+	case "sticker":
+		message, caption = stickerMessage(*shared.upload, isAnimatedWebP(out.Media), nil), ""
+	}
+	resp, err := client.SendMessage(context.Background(), recipientJID, message)
+	if err != nil {
+		return "", fmt.Errorf("Error sending %s message: %v", out.MediaType, err)
+	}
+
+	// Store the file once for every recipient. If that fails, this
+	// recipient's event stores its own copy through the outbox.
+	if !single && shared.stored.Location == "" {
+		location, uploaded, err := storeMediaFile(shared.key, out.Media, sinkNeedsMediaURL(eventSink))
+		if err != nil {
+			logger.Error("Failed to store shared media:", err)
+		} else {
+			shared.stored = MediaUpload{Location: location, Uploaded: uploaded}
+		}
+	}
+	upload := shared.stored
+	upload.Key, upload.Data = shared.key, out.Media
+	err = logSentMedia(client, messageStore, eventSink, recipient, out.AdminPhone, caption, resp.ID, "",
+		MediaInfo{MediaType: out.MediaType, Mimetype: out.Mimetype, Filename: out.Filename, Caption: caption}, nil, upload)
+	if err != nil {
+		// The message itself went out, so only the log copy is missing
+		logger.Error("Failed to log sent media:", err)
+	}
+	return resp.ID, nil
+}
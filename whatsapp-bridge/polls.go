@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/libsignal/logger"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// WhatsApp clients refuse polls with more options than this
+const maxPollOptions = 12
+
+// PollOptionTally is the live count for one option of a poll
+type PollOptionTally struct {
+	Name   string   `json:"name"`
+	Votes  int      `json:"votes"`
+	Voters []string `json:"voters"`
+}
+
+// PollVoter is the current selection of one voter
+type PollVoter struct {
+	Voter   string    `json:"voter"`
+	Options []string  `json:"options"`
+	Time    time.Time `json:"timestamp"`
+}
+
+// PollResults is returned by GET /api/polls/{id}
+type PollResults struct {
+	ID              string            `json:"id"`
+	ChatJID         string            `json:"chat_jid"`
+	Question        string            `json:"question"`
+	SelectableCount int               `json:"selectable_count"` // 0 means any number of options
+	Options         []PollOptionTally `json:"options"`
+	Voters          []PollVoter       `json:"voters"`
+}
+
+// Get the poll creation message of whichever version is set
+func getPollCreation(msg *waE2E.Message) *waE2E.PollCreationMessage {
+	if poll := msg.GetPollCreationMessage(); poll != nil {
+		return poll
+	} else if poll := msg.GetPollCreationMessageV2(); poll != nil {
+		return poll
+	}
+	return msg.GetPollCreationMessageV3()
+}
+
+// Hash an option name the way votes refer to it
+func pollOptionHash(name string) string {
+	hash := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(hash[:])
+}
+
+// Store the question and options of a poll so votes can be tallied
+func (store *MessageStore) StorePoll(messageID, chatJID string, poll *waE2E.PollCreationMessage) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"INSERT OR REPLACE INTO polls (message_id, chat_jid, question, selectable_count) VALUES (?, ?, ?, ?)",
+		messageID, chatJID, poll.GetName(), poll.GetSelectableOptionsCount(),
+	)
+	if err != nil {
+		return err
+	}
+	for i, option := range poll.GetOptions() {
+		_, err = tx.Exec(
+			"INSERT OR REPLACE INTO poll_options (message_id, chat_jid, option_index, name, option_hash) VALUES (?, ?, ?, ?, ?)",
+			messageID, chatJID, i, option.GetOptionName(), pollOptionHash(option.GetOptionName()),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Store a voter's current selection. Each vote replaces the voter's previous
+// one, and an empty selection means the vote was retracted. Votes older than
+// the stored one are ignored.
+func (store *MessageStore) StorePollVote(messageID, chatJID, voter string, selected [][]byte, timestamp time.Time) error {
+	hashes := make([]string, 0, len(selected))
+	for _, hash := range selected {
+		hashes = append(hashes, hex.EncodeToString(hash))
+	}
+
+	_, err := store.db.Exec(
+		`INSERT INTO poll_votes (message_id, chat_jid, voter, option_hashes, timestamp) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, chat_jid, voter) DO UPDATE SET option_hashes = excluded.option_hashes, timestamp = excluded.timestamp
+		WHERE excluded.timestamp >= poll_votes.timestamp`,
+		messageID, chatJID, voter, strings.Join(hashes, ","), timestamp.UTC(),
+	)
+	return err
+}
+
+// Get a poll with its current tallies. If chatJID is empty the poll is
+// looked up by message ID alone. Returns sql.ErrNoRows if it is not stored.
+func (store *MessageStore) GetPollResults(messageID, chatJID string) (PollResults, error) {
+	results := PollResults{ID: messageID, Options: []PollOptionTally{}, Voters: []PollVoter{}}
+
+	query := "SELECT chat_jid, question, selectable_count FROM polls WHERE message_id = ?"
+	args := []interface{}{messageID}
+	if chatJID != "" {
+		query += " AND chat_jid = ?"
+		args = append(args, chatJID)
+	}
+	err := store.db.QueryRow(query+" LIMIT 1", args...).Scan(&results.ChatJID, &results.Question, &results.SelectableCount)
+	if err != nil {
+		return results, err
+	}
+
+	rows, err := store.db.Query(
+		"SELECT name, option_hash FROM poll_options WHERE message_id = ? AND chat_jid = ? ORDER BY option_index",
+		messageID, results.ChatJID,
+	)
+	if err != nil {
+		return results, err
+	}
+	optionIndex := make(map[string]int)
+	for rows.Next() {
+		var name, hash string
+		if err := rows.Scan(&name, &hash); err != nil {
+			rows.Close()
+			return results, err
+		}
+		optionIndex[hash] = len(results.Options)
+		results.Options = append(results.Options, PollOptionTally{Name: name, Voters: []string{}})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return results, err
+	}
+
+	rows, err = store.db.Query(
+		"SELECT voter, option_hashes, timestamp FROM poll_votes WHERE message_id = ? AND chat_jid = ? AND option_hashes != '' ORDER BY timestamp",
+		messageID, results.ChatJID,
+	)
+	if err != nil {
+		return results, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hashes string
+		voter := PollVoter{Options: []string{}}
+		if err := rows.Scan(&voter.Voter, &hashes, &voter.Time); err != nil {
+			return results, err
+		}
+		for _, hash := range strings.Split(hashes, ",") {
+			i, ok := optionIndex[hash]
+			if !ok {
+				continue // Option we don't know about
+			}
+			results.Options[i].Votes++
+			results.Options[i].Voters = append(results.Options[i].Voters, voter.Voter)
+			voter.Options = append(voter.Options, results.Options[i].Name)
+		}
+		results.Voters = append(results.Voters, voter)
+	}
+
+	return results, rows.Err()
+}
+
+// Decrypt an incoming poll vote and record it against the poll
+func handlePollUpdate(client *whatsmeow.Client, messageStore *MessageStore, msg *events.Message, logger waLog.Logger) {
+	pollID := msg.Message.GetPollUpdateMessage().GetPollCreationMessageKey().GetID()
+	vote, err := client.DecryptPollVote(context.Background(), msg)
+	if err != nil {
+		logger.Warnf("Failed to decrypt vote for poll %s: %v", pollID, err)
+		return
+	}
+
+	err = messageStore.StorePollVote(pollID, msg.Info.Chat.String(), msg.Info.Sender.User, vote.GetSelectedOptions(), msg.Info.Timestamp)
+	if err != nil {
+		logger.Warnf("Failed to store poll vote: %v", err)
+		return
+	}
+	fmt.Printf("📊 %s voted on poll %s (%d options selected)\n", msg.Info.Sender.User, pollID, len(vote.GetSelectedOptions()))
+}
+
+// SendPollRequest represents the request body for the send poll API
+type SendPollRequest struct {
+	Recipient       string   `json:"recipient"`
+	Question        string   `json:"question"`
+	Options         []string `json:"options"`
+	MaxSelections   int      `json:"max_selections"` // 0 allows any number of options
+	AdminPhone      string   `json:"admin_phone"`
+	ParentMessageID string   `json:"wa_parent_message_id"`
+	RequireParent   bool     `json:"require_parent"`
+}
+
+// Function to send a poll. The poll is returned so it can be stored, since
+// its message secret is needed to decrypt votes.
+func sendWhatsAppPollMessage(client *whatsmeow.Client, recipient string, question string, options []string, maxSelections int, contextInfo *waE2E.ContextInfo) (bool, string, string, string, *waE2E.Message) {
+	if !client.IsConnected() {
+		return false, "Not connected to WhatsApp", "", "", nil
+	}
+
+	recipientJID, err := parseChatJID(recipient)
+	if err != nil {
+		return false, fmt.Sprintf("Error parsing JID: %v", err), "", "", nil
+	}
+
+	pollMsg := client.BuildPollCreation(question, options, maxSelections)
+	pollMsg.PollCreationMessage.ContextInfo = contextInfo
+
+	resp, err := client.SendMessage(context.Background(), recipientJID, pollMsg)
+	if err != nil {
+		return false, fmt.Sprintf("Error sending poll: %v", err), "", "", nil
+	}
+
+	return true, fmt.Sprintf("Poll sent to %s", recipient), resp.ID, contextInfo.GetStanzaID(), pollMsg
+}
+
+// Handler for sending a poll
+func sendPollHandler(client *whatsmeow.Client, messageStore *MessageStore, eventSink EventSink) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req SendPollRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Error parsing request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Recipient == "" || req.Question == "" {
+			http.Error(w, "Recipient and question are required", http.StatusBadRequest)
+			return
+		}
+		if len(req.Options) < 2 || len(req.Options) > maxPollOptions {
+			http.Error(w, fmt.Sprintf("A poll needs between 2 and %d options", maxPollOptions), http.StatusBadRequest)
+			return
+		}
+		seen := make(map[string]bool)
+		for _, option := range req.Options {
+			if option == "" || seen[option] {
+				http.Error(w, "Poll options must be non-empty and unique", http.StatusBadRequest)
+				return
+			}
+			seen[option] = true
+		}
+		if req.MaxSelections < 0 || req.MaxSelections > len(req.Options) {
+			http.Error(w, "max_selections must be between 0 and the number of options", http.StatusBadRequest)
+			return
+		}
+
+		contextInfo, err := buildReplyContext(client, messageStore, req.Recipient, req.ParentMessageID, req.RequireParent)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		success, msg, msgID, parentMsgID, pollMsg := sendWhatsAppPollMessage(client, req.Recipient, req.Question, req.Options, req.MaxSelections, contextInfo)
+		fmt.Println("Message sent", success, msg, msgID)
+
+		if success {
+			senderPhone := client.Store.ID.User
+			msgTime := time.Now()
+
+			content, media := extractMessageContent(pollMsg)
+			err := logSentMessage(messageStore, eventSink, req.Recipient, Message{
+				ID:              msgID,
+				Sender:          senderPhone,
+				Content:         content,
+				Time:            msgTime,
+				MediaInfo:       media,
+				ParentMessageID: parentMsgID,
+				AdminPhone:      req.AdminPhone,
+			}, nil, WALogMessageForQueue{
+				Type:            "poll",
+				From:            senderPhone,
+				To:              req.Recipient,
+				AdminPhone:      req.AdminPhone,
+				Message:         content,
+				Time:            msgTime,
+				MessageID:       msgID,
+				ParentMessageID: parentMsgID,
+				Status:          "SENT",
+			})
+			if err != nil {
+				logger.Error("Failed to send message to event sinks:", err)
+			} else {
+				logger.Info("Message sent to event sinks successfully")
+			}
+			if chatJID, err := parseChatJID(req.Recipient); err == nil {
+				if err := messageStore.StorePoll(msgID, chatJID.String(), pollMsg.GetPollCreationMessage()); err != nil {
+					logger.Error("Failed to store poll:", err)
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !success {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(SendMessageResponseWithLog{
+			Success: success,
+			Message: msg,
+		})
+	}
+}
+
+// Handler for reading the live results of a poll
+func pollResultsHandler(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Method not allowed"})
+			return
+		}
+
+		chatJID := ""
+		if value := r.URL.Query().Get("chat_jid"); value != "" {
+			jid, err := parseChatJID(value)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Invalid chat_jid: %v", err)})
+				return
+			}
+			chatJID = jid.String()
+		}
+
+		results, err := messageStore.GetPollResults(r.PathValue("id"), chatJID)
+		if err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Poll not found"})
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to get poll: %v", err)})
+			return
+		}
+
+		json.NewEncoder(w).Encode(results)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Build the context info for a reply to parentMessageID in the recipient's
+// chat. Phones only render the quote bubble when the quoted message and its
+// sender are included, so both are filled in from the stored parent. When
+// the parent is not stored the reply either fails (requireParent) or falls
+// back to a bare stanza ID. Returns nil if there is no parent.
+func buildReplyContext(client *whatsmeow.Client, messageStore *MessageStore, recipient, parentMessageID string, requireParent bool) (*waE2E.ContextInfo, error) {
+	if parentMessageID == "" {
+		return nil, nil
+	}
+
+	chatJID, err := parseChatJID(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient: %v", err)
+	}
+
+	parent, err := messageStore.GetMessage(parentMessageID, chatJID.String())
+	if err == sql.ErrNoRows {
+		if requireParent {
+			return nil, fmt.Errorf("parent message %s not found in chat %s", parentMessageID, chatJID)
+		}
+		return &waE2E.ContextInfo{StanzaID: proto.String(parentMessageID)}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to look up parent message: %v", err)
+	}
+
+	var participant types.JID
+	switch {
+	case parent.IsFromMe && client.Store.ID != nil:
+		participant = client.Store.ID.ToNonAD()
+	case chatJID.Server != types.GroupServer:
+		participant = chatJID
+	default:
+		participant, err = parseUserJID(parent.Sender)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sender of parent message: %v", err)
+		}
+	}
+
+	return &waE2E.ContextInfo{
+		StanzaID:      proto.String(parentMessageID),
+		Participant:   proto.String(participant.String()),
+		QuotedMessage: buildQuotedMessage(parent),
+	}, nil
+}
+
+// Rebuild enough of a stored message for the quote bubble. Media is quoted
+// without its download keys, which phones render as a preview placeholder.
+func buildQuotedMessage(msg Message) *waE2E.Message {
+	switch msg.MediaType {
+	case "image":
+		return &waE2E.Message{ImageMessage: &waE2E.ImageMessage{
+			Caption:  proto.String(msg.Caption),
+			Mimetype: proto.String(msg.Mimetype),
+		}}
+	case "video":
+		return &waE2E.Message{VideoMessage: &waE2E.VideoMessage{
+			Caption:  proto.String(msg.Caption),
+			Mimetype: proto.String(msg.Mimetype),
+		}}
+	case "audio":
+		return &waE2E.Message{AudioMessage: &waE2E.AudioMessage{
+			Mimetype: proto.String(msg.Mimetype),
+		}}
+	case "sticker":
+		return &waE2E.Message{StickerMessage: &waE2E.StickerMessage{
+			Mimetype: proto.String(msg.Mimetype),
+		}}
+	case "document":
+		return &waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{
+			FileName: proto.String(msg.Filename),
+			Title:    proto.String(msg.Filename),
+			Caption:  proto.String(msg.Caption),
+			Mimetype: proto.String(msg.Mimetype),
+		}}
+	case "location":
+		return &waE2E.Message{LocationMessage: &waE2E.LocationMessage{
+			DegreesLatitude:  proto.Float64(msg.Latitude),
+			DegreesLongitude: proto.Float64(msg.Longitude),
+		}}
+	case "contact":
+		name, _ := parseVCard(msg.VCard)
+		return &waE2E.Message{ContactMessage: &waE2E.ContactMessage{
+			DisplayName: proto.String(name),
+			Vcard:       proto.String(msg.VCard),
+		}}
+	default:
+		return &waE2E.Message{Conversation: proto.String(msg.Content)}
+	}
+}
@@ -0,0 +1,562 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	_ "time/tzdata" // schedules name IANA time zones, which slim images lack
+
+	"go.mau.fi/libsignal/logger"
+	"go.mau.fi/whatsmeow"
+)
+
+// Runs kept per schedule in API responses
+const scheduledRunsShown = 50
+
+// ScheduleRequest represents the request body for creating or replacing a
+// scheduled message. Exactly one of send_at and cron must be set.
+type ScheduleRequest struct {
+	Recipient   string `json:"recipient"`
+	Message     string `json:"message"`
+	MediaType   string `json:"media_type"` // empty for text, otherwise "image", "document", "audio", "video" or "sticker"
+	MediaURL    string `json:"media_url"`
+	MediaBase64 string `json:"media_base64"`
+	Filename    string `json:"filename"`
+	Mimetype    string `json:"mimetype"`
+	AdminPhone  string `json:"admin_phone"`
+	SendAt      string `json:"send_at"`  // RFC 3339, or local to timezone without an offset
+	Cron        string `json:"cron"`     // five field cron expression or a descriptor such as @daily
+	Timezone    string `json:"timezone"` // IANA name, defaults to UTC
+}
+
+// ScheduledRun is the outcome of one run of a scheduled message
+type ScheduledRun struct {
+	ScheduledFor time.Time `json:"scheduled_for"`
+	RanAt        time.Time `json:"ran_at"`
+	Status       string    `json:"status"` // "running", "sent", "failed"
+	MessageID    string    `json:"message_id,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// ScheduledMessage is a message sent once at a set time or repeatedly on a
+// cron schedule
+type ScheduledMessage struct {
+	ID        string `json:"id"`
+	Recipient string `json:"recipient"`
+	OutgoingMessage
+	SendAt    *time.Time     `json:"send_at,omitempty"`
+	Cron      string         `json:"cron,omitempty"`
+	Timezone  string         `json:"timezone"`
+	Status    string         `json:"status"` // "active", "completed", "cancelled"
+	NextRun   *time.Time     `json:"next_run,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Runs      []ScheduledRun `json:"runs,omitempty"`
+}
+
+// Work out the first run of a schedule after the given time. Returns nil when
+// a one-shot message has already been sent.
+func (s *ScheduledMessage) nextRunAfter(after time.Time) (*time.Time, error) {
+	if s.Cron == "" {
+		if s.SendAt == nil || !s.SendAt.After(after) {
+			return nil, nil
+		}
+		return s.SendAt, nil
+	}
+
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone: %v", err)
+	}
+	schedule, err := parseCron(s.Cron)
+	if err != nil {
+		return nil, err
+	}
+	next := schedule.Next(after.In(loc))
+	if next.IsZero() {
+		return nil, nil
+	}
+	// Stored times are compared as text, so keep them all in UTC
+	next = next.UTC()
+	return &next, nil
+}
+
+// Parse send_at as RFC 3339, or as a wall clock time in loc
+func parseSendAt(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("send_at must be RFC 3339 or YYYY-MM-DDTHH:MM")
+}
+
+// Check a schedule request and turn it into a scheduled message, loading
+// any media up front
+func buildScheduledMessage(ctx context.Context, req ScheduleRequest) (ScheduledMessage, error) {
+	if req.Recipient == "" {
+		return ScheduledMessage{}, fmt.Errorf("recipient is required")
+	}
+	if _, err := parseChatJID(req.Recipient); err != nil {
+		return ScheduledMessage{}, fmt.Errorf("invalid recipient: %v", err)
+	}
+	if (req.SendAt == "") == (req.Cron == "") {
+		return ScheduledMessage{}, fmt.Errorf("exactly one of send_at and cron is required")
+	}
+
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	loc, err := time.LoadLocation(req.Timezone)
+	if err != nil {
+		return ScheduledMessage{}, fmt.Errorf("invalid timezone: %v", err)
+	}
+
+	s := ScheduledMessage{
+		Recipient: req.Recipient,
+		Cron:      strings.TrimSpace(req.Cron),
+		Timezone:  req.Timezone,
+		Status:    "active",
+		OutgoingMessage: OutgoingMessage{
+			Message:    req.Message,
+			MediaType:  req.MediaType,
+			Mimetype:   req.Mimetype,
+			Filename:   req.Filename,
+			AdminPhone: req.AdminPhone,
+		},
+	}
+	if req.SendAt != "" {
+		sendAt, err := parseSendAt(req.SendAt, loc)
+		if err != nil {
+			return ScheduledMessage{}, err
+		}
+		sendAt = sendAt.UTC()
+		s.SendAt = &sendAt
+	}
+
+	if s.NextRun, err = s.nextRunAfter(time.Now()); err != nil {
+		return ScheduledMessage{}, err
+	}
+	if s.NextRun == nil {
+		if s.Cron != "" {
+			return ScheduledMessage{}, fmt.Errorf("cron expression never matches")
+		}
+		return ScheduledMessage{}, fmt.Errorf("send_at must be in the future")
+	}
+
+	if err := s.prepare(ctx, req.MediaURL, req.MediaBase64); err != nil {
+		return ScheduledMessage{}, err
+	}
+	return s, nil
+}
+
+// Store a new scheduled message
+func (store *MessageStore) CreateScheduledMessage(s ScheduledMessage) error {
+	_, err := store.db.Exec(
+		`INSERT INTO scheduled_messages (id, recipient, message, media_type, mimetype, filename, media, admin_phone,
+			send_at, cron, timezone, status, next_run, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.ID, s.Recipient, s.Message, s.MediaType, s.Mimetype, s.Filename, s.Media, s.AdminPhone,
+		s.SendAt, s.Cron, s.Timezone, s.Status, s.NextRun, s.CreatedAt, s.UpdatedAt,
+	)
+	return err
+}
+
+// Replace the message and timing of an active schedule. Returns
+// sql.ErrNoRows if there is no active schedule with that ID.
+func (store *MessageStore) UpdateScheduledMessage(s ScheduledMessage) error {
+	result, err := store.db.Exec(
+		`UPDATE scheduled_messages SET recipient = ?, message = ?, media_type = ?, mimetype = ?, filename = ?, media = ?,
+			admin_phone = ?, send_at = ?, cron = ?, timezone = ?, next_run = ?, updated_at = ?
+		WHERE id = ? AND status = 'active'`,
+		s.Recipient, s.Message, s.MediaType, s.Mimetype, s.Filename, s.Media,
+		s.AdminPhone, s.SendAt, s.Cron, s.Timezone, s.NextRun, s.UpdatedAt, s.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Cancel an active schedule. Returns sql.ErrNoRows if there is no active
+// schedule with that ID.
+func (store *MessageStore) CancelScheduledMessage(id string) error {
+	result, err := store.db.Exec(
+		`UPDATE scheduled_messages SET status = 'cancelled', next_run = NULL, updated_at = ?
+		WHERE id = ? AND status = 'active'`,
+		time.Now(), id,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+const scheduledMessageColumns = `id, recipient, message, media_type, mimetype, filename, admin_phone,
+	send_at, cron, timezone, status, next_run, created_at, updated_at`
+
+// Scan a scheduled message selected with scheduledMessageColumns
+func scanScheduledMessage(row interface{ Scan(...interface{}) error }, s *ScheduledMessage, extra ...interface{}) error {
+	var sendAt, nextRun sql.NullTime
+	dest := []interface{}{&s.ID, &s.Recipient, &s.Message, &s.MediaType, &s.Mimetype, &s.Filename, &s.AdminPhone,
+		&sendAt, &s.Cron, &s.Timezone, &s.Status, &nextRun, &s.CreatedAt, &s.UpdatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	if sendAt.Valid {
+		s.SendAt = &sendAt.Time
+	}
+	if nextRun.Valid {
+		s.NextRun = &nextRun.Time
+	}
+	return nil
+}
+
+// List scheduled messages, optionally only those with the given status
+func (store *MessageStore) ListScheduledMessages(status string) ([]ScheduledMessage, error) {
+	query := `SELECT ` + scheduledMessageColumns + ` FROM scheduled_messages`
+	var args []interface{}
+	if status != "" {
+		query += ` WHERE status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY next_run IS NULL, next_run, created_at`
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []ScheduledMessage{}
+	for rows.Next() {
+		var s ScheduledMessage
+		if err := scanScheduledMessage(rows, &s); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, rows.Err()
+}
+
+// Get a scheduled message with its most recent runs. Returns sql.ErrNoRows
+// if there is no such schedule.
+func (store *MessageStore) GetScheduledMessage(id string) (ScheduledMessage, error) {
+	var s ScheduledMessage
+	row := store.db.QueryRow(`SELECT `+scheduledMessageColumns+` FROM scheduled_messages WHERE id = ?`, id)
+	if err := scanScheduledMessage(row, &s); err != nil {
+		return ScheduledMessage{}, err
+	}
+
+	rows, err := store.db.Query(
+		`SELECT scheduled_for, ran_at, status, message_id, error FROM scheduled_runs
+		WHERE schedule_id = ? ORDER BY id DESC LIMIT ?`,
+		id, scheduledRunsShown,
+	)
+	if err != nil {
+		return ScheduledMessage{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var run ScheduledRun
+		if err := rows.Scan(&run.ScheduledFor, &run.RanAt, &run.Status, &run.MessageID, &run.Error); err != nil {
+			return ScheduledMessage{}, err
+		}
+		s.Runs = append(s.Runs, run)
+	}
+	return s, rows.Err()
+}
+
+// Get the active schedule that runs soonest, including its media. Returns
+// sql.ErrNoRows when nothing is scheduled.
+func (store *MessageStore) nextScheduledMessage() (ScheduledMessage, error) {
+	var s ScheduledMessage
+	row := store.db.QueryRow(
+		`SELECT ` + scheduledMessageColumns + `, media FROM scheduled_messages
+		WHERE status = 'active' AND next_run IS NOT NULL
+		ORDER BY next_run LIMIT 1`,
+	)
+	err := scanScheduledMessage(row, &s, &s.Media)
+	return s, err
+}
+
+// Record the start of a run and move the schedule on to its next run before
+// sending, so a restart mid-send never sends the same run twice
+func (store *MessageStore) startScheduledRun(id string, scheduledFor time.Time, nextRun *time.Time) (int64, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec(
+		`INSERT INTO scheduled_runs (schedule_id, scheduled_for, ran_at, status, message_id, error)
+		VALUES (?, ?, ?, 'running', '', '')`,
+		id, scheduledFor, now,
+	)
+	if err != nil {
+		return 0, err
+	}
+	runID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	status := "active"
+	if nextRun == nil {
+		status = "completed"
+	}
+	_, err = tx.Exec(
+		`UPDATE scheduled_messages SET next_run = ?, status = ?, updated_at = ? WHERE id = ?`,
+		nextRun, status, now, id,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return runID, tx.Commit()
+}
+
+// Record the outcome of a run
+func (store *MessageStore) finishScheduledRun(runID int64, messageID string, sendErr error) error {
+	status, errMsg := "sent", ""
+	if sendErr != nil {
+		status, errMsg = "failed", sendErr.Error()
+	}
+	_, err := store.db.Exec(
+		`UPDATE scheduled_runs SET status = ?, message_id = ?, error = ? WHERE id = ?`,
+		status, messageID, errMsg, runID,
+	)
+	return err
+}
+
+// Fail runs that were in flight when the bridge stopped
+func (store *MessageStore) recoverScheduledRuns() error {
+	_, err := store.db.Exec(
+		`UPDATE scheduled_runs SET status = 'failed', error = 'interrupted by a restart, delivery unknown'
+		WHERE status = 'running'`,
+	)
+	return err
+}
+
+// Scheduler sends scheduled messages when they fall due
+type Scheduler struct {
+	client       *whatsmeow.Client
+	messageStore *MessageStore
+	eventSink    EventSink
+	wake         chan struct{}
+}
+
+func newScheduler(client *whatsmeow.Client, messageStore *MessageStore, eventSink EventSink) *Scheduler {
+	return &Scheduler{
+		client:       client,
+		messageStore: messageStore,
+		eventSink:    eventSink,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Wake the scheduler after schedules change
+func (sc *Scheduler) Notify() {
+	select {
+	case sc.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Send scheduled messages until the process exits. Runs missed while the
+// bridge was down are sent once on startup rather than once per missed time.
+func (sc *Scheduler) Run() {
+	if err := sc.messageStore.recoverScheduledRuns(); err != nil {
+		logger.Error("❌ Failed to recover scheduled runs:", err)
+	}
+
+	for {
+		s, err := sc.messageStore.nextScheduledMessage()
+		if err == sql.ErrNoRows {
+			<-sc.wake
+			continue
+		} else if err != nil {
+			logger.Error("❌ Failed to load scheduled messages:", err)
+			time.Sleep(30 * time.Second)
+			continue
+		}
+
+		if wait := time.Until(*s.NextRun); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-sc.wake:
+				timer.Stop()
+			}
+			continue
+		}
+
+		// Wait for the connection rather than failing the run while it is down
+		if !sc.client.IsConnected() {
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		sc.runScheduledMessage(s)
+	}
+}
+
+// Send one due run of a schedule and record how it went
+func (sc *Scheduler) runScheduledMessage(s ScheduledMessage) {
+	scheduledFor := *s.NextRun
+	nextRun, err := s.nextRunAfter(time.Now())
+	if err != nil {
+		logger.Error("⚠️ Schedule", s.ID, "has no next run:", err)
+		nextRun = nil
+	}
+
+	runID, err := sc.messageStore.startScheduledRun(s.ID, scheduledFor, nextRun)
+	if err != nil {
+		logger.Error("❌ Failed to record scheduled run:", err)
+		time.Sleep(30 * time.Second)
+		return
+	}
+
+	msgID, sendErr := sendOutgoingMessage(sc.client, sc.messageStore, sc.eventSink, s.Recipient, s.OutgoingMessage, nil)
+	if sendErr != nil {
+		logger.Error("⚠️ Scheduled message", s.ID, "to", s.Recipient, "failed:", sendErr)
+	} else {
+		logger.Info("✅ Scheduled message", s.ID, "sent to", s.Recipient)
+	}
+	if err := sc.messageStore.finishScheduledRun(runID, msgID, sendErr); err != nil {
+		logger.Error("❌ Failed to record scheduled run result:", err)
+	}
+}
+
+// Write a schedule lookup error as JSON
+func writeScheduleError(w http.ResponseWriter, err error, notFound string) {
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: notFound})
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to get scheduled message: %v", err)})
+}
+
+// Handler for creating and listing scheduled messages
+func schedulesHandler(messageStore *MessageStore, scheduler *Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			schedules, err := messageStore.ListScheduledMessages(r.URL.Query().Get("status"))
+			w.Header().Set("Content-Type", "application/json")
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to list scheduled messages: %v", err)})
+				return
+			}
+			json.NewEncoder(w).Encode(schedules)
+
+		case http.MethodPost:
+			var req ScheduleRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Error parsing request body", http.StatusBadRequest)
+				return
+			}
+			s, err := buildScheduledMessage(r.Context(), req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if s.ID, err = newJobID(); err != nil {
+				http.Error(w, "Error creating scheduled message", http.StatusInternalServerError)
+				return
+			}
+			s.CreatedAt = time.Now()
+			s.UpdatedAt = s.CreatedAt
+
+			w.Header().Set("Content-Type", "application/json")
+			if err := messageStore.CreateScheduledMessage(s); err != nil {
+				logger.Error("Failed to store scheduled message:", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to store scheduled message: %v", err)})
+				return
+			}
+			scheduler.Notify()
+
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(s)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// Handler for reading, replacing and cancelling one scheduled message
+func scheduleHandler(messageStore *MessageStore, scheduler *Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			s, err := messageStore.GetScheduledMessage(id)
+			if err != nil {
+				writeScheduleError(w, err, "Scheduled message not found")
+				return
+			}
+			json.NewEncoder(w).Encode(s)
+
+		case http.MethodPut:
+			var req ScheduleRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Error parsing request body", http.StatusBadRequest)
+				return
+			}
+			s, err := buildScheduledMessage(r.Context(), req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			s.ID = id
+			s.UpdatedAt = time.Now()
+
+			w.Header().Set("Content-Type", "application/json")
+			if err := messageStore.UpdateScheduledMessage(s); err != nil {
+				writeScheduleError(w, err, "No active scheduled message with that ID")
+				return
+			}
+			scheduler.Notify()
+
+			s, err = messageStore.GetScheduledMessage(id)
+			if err != nil {
+				writeScheduleError(w, err, "Scheduled message not found")
+				return
+			}
+			json.NewEncoder(w).Encode(s)
+
+		case http.MethodDelete:
+			w.Header().Set("Content-Type", "application/json")
+			if err := messageStore.CancelScheduledMessage(id); err != nil {
+				writeScheduleError(w, err, "No active scheduled message with that ID")
+				return
+			}
+			scheduler.Notify()
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: true, Message: "Scheduled message cancelled"})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
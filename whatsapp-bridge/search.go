@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Number of neighbouring messages returned on each side of a search hit by default
+const defaultSearchContext = 2
+
+// SearchHit is a single ranked full-text search result
+type SearchHit struct {
+	Message  Message   `json:"message"`
+	ChatName string    `json:"chat_name"`
+	Snippet  string    `json:"snippet"`
+	Rank     float64   `json:"rank"`
+	Before   []Message `json:"before"`
+	After    []Message `json:"after"`
+}
+
+// SearchResponse is returned by GET /api/search
+type SearchResponse struct {
+	Hits       []SearchHit `json:"hits"`
+	NextOffset int         `json:"next_offset,omitempty"`
+}
+
+// Create the FTS5 index over message content and the triggers that keep it in
+// sync with the messages table. Databases that predate the index are
+// backfilled the first time it is created. The index is keyed on the implicit
+// rowid of messages, which VACUUM may renumber, so it is rebuilt whenever it
+// no longer matches the table.
+func (store *MessageStore) initFullTextSearch() error {
+	var existing int
+	err := store.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'messages_fts'").Scan(&existing)
+	if err != nil {
+		return err
+	}
+
+	_, err = store.db.Exec(`
+		CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+			content,
+			content='messages',
+			content_rowid='rowid'
+		);
+
+		CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+			INSERT INTO messages_fts(rowid, content) VALUES (new.rowid, new.content);
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+			INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.rowid, old.content);
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF content ON messages BEGIN
+			INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.rowid, old.content);
+			INSERT INTO messages_fts(rowid, content) VALUES (new.rowid, new.content);
+		END;
+	`)
+	if err != nil {
+		return err
+	}
+
+	if existing == 0 {
+		if _, err := store.db.Exec("INSERT INTO messages_fts(messages_fts) VALUES ('rebuild')"); err != nil {
+			return fmt.Errorf("failed to backfill search index: %v", err)
+		}
+		return nil
+	}
+
+	// With a rank of 1 the integrity check compares the index to the messages table
+	if _, err := store.db.Exec("INSERT INTO messages_fts(messages_fts, rank) VALUES ('integrity-check', 1)"); err != nil {
+		fmt.Println("⚠️ Search index out of step with messages, rebuilding:", err)
+		if _, err := store.db.Exec("INSERT INTO messages_fts(messages_fts) VALUES ('rebuild')"); err != nil {
+			return fmt.Errorf("failed to rebuild search index: %v", err)
+		}
+	}
+	return nil
+}
+
+// Turn free text into an FTS5 query that matches every term, so that user
+// input containing FTS operators or quotes cannot produce a syntax error
+func buildMatchQuery(text string) string {
+	var terms []string
+	for _, term := range strings.Fields(text) {
+		terms = append(terms, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// Search stored messages, best matches first
+func (store *MessageStore) SearchMessages(text, chatJID string, limit, offset int) ([]SearchHit, error) {
+	query := `
+		SELECT ` + messageSelectList("m") + `,
+			COALESCE(c.name, ''),
+			snippet(messages_fts, 0, '**', '**', '…', 12),
+			bm25(messages_fts)
+		FROM messages_fts
+		JOIN messages m ON m.rowid = messages_fts.rowid
+		LEFT JOIN chats c ON c.jid = m.chat_jid
+		WHERE messages_fts MATCH ?`
+	args := []interface{}{buildMatchQuery(text)}
+	if chatJID != "" {
+		query += " AND m.chat_jid = ?"
+		args = append(args, chatJID)
+	}
+	query += " ORDER BY bm25(messages_fts) LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hits := []SearchHit{}
+	for rows.Next() {
+		var hit SearchHit
+		if err := scanMessage(rows, &hit.Message, &hit.ChatName, &hit.Snippet, &hit.Rank); err != nil {
+			return nil, err
+		}
+		hits = append(hits, hit)
+	}
+
+	return hits, rows.Err()
+}
+
+// Get up to n messages on each side of the given message in its chat, both in
+// chronological order
+func (store *MessageStore) GetMessageContext(msg Message, n int) (before []Message, after []Message, err error) {
+	before, err = store.GetMessages(msg.ChatJID, MessageQuery{
+		Cursor: &PageCursor{Time: msg.Time, ID: msg.ID},
+		Limit:  n,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	slices.Reverse(before)
+
+	rows, err := store.db.Query(
+		`SELECT `+messageSelectList("")+` FROM messages
+		WHERE chat_jid = ? AND (timestamp > ? OR (timestamp = ? AND id > ?))
+		ORDER BY timestamp ASC, id ASC LIMIT ?`,
+		msg.ChatJID, msg.Time.UTC(), msg.Time.UTC(), msg.ID, n,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	after = []Message{}
+	for rows.Next() {
+		var m Message
+		if err := scanMessage(rows, &m); err != nil {
+			return nil, nil, err
+		}
+		after = append(after, m)
+	}
+
+	return before, after, rows.Err()
+}
+
+// Handler for full-text search over stored messages
+func searchMessagesHandler(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Method not allowed"})
+			return
+		}
+
+		if !messageStore.searchEnabled {
+			w.WriteHeader(http.StatusNotImplemented)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Full-text search is unavailable; build the bridge with -tags sqlite_fts5"})
+			return
+		}
+
+		text := strings.TrimSpace(r.URL.Query().Get("q"))
+		if text == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "q is required"})
+			return
+		}
+
+		limit, err := parseLimit(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: err.Error()})
+			return
+		}
+
+		offset := 0
+		if value := r.URL.Query().Get("offset"); value != "" {
+			offset, err = strconv.Atoi(value)
+			if err != nil || offset < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "offset must be a non-negative integer"})
+				return
+			}
+		}
+
+		contextSize := defaultSearchContext
+		if value := r.URL.Query().Get("context"); value != "" {
+			contextSize, err = strconv.Atoi(value)
+			if err != nil || contextSize < 0 || contextSize > 20 {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "context must be between 0 and 20"})
+				return
+			}
+		}
+
+		chatJID := ""
+		if value := r.URL.Query().Get("chat_jid"); value != "" {
+			jid, err := parseChatJID(value)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Invalid chat_jid: %v", err)})
+				return
+			}
+			chatJID = jid.String()
+		}
+
+		hits, err := messageStore.SearchMessages(text, chatJID, limit, offset)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to search messages: %v", err)})
+			return
+		}
+
+		for i := range hits {
+			hits[i].Before, hits[i].After = []Message{}, []Message{}
+			if contextSize == 0 {
+				continue
+			}
+			before, after, err := messageStore.GetMessageContext(hits[i].Message, contextSize)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to get message context: %v", err)})
+				return
+			}
+			hits[i].Before, hits[i].After = before, after
+		}
+
+		resp := SearchResponse{Hits: hits}
+		if len(hits) == limit {
+			resp.NextOffset = offset + limit
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSearchIndexRebuiltWhenRowidsChange(t *testing.T) {
+	store := newTestStore(t)
+	if !store.searchEnabled {
+		t.Skip("SQLite built without FTS5 (go test -tags sqlite_fts5)")
+	}
+	chat := "123@s.whatsapp.net"
+	if err := store.StoreChat(chat, "Chat", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.StoreMessage(Message{ID: "a", ChatJID: chat, Sender: "123", Content: "hello world", Time: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Renumber the rows the way VACUUM may, which the triggers do not see
+	if _, err := store.db.Exec("UPDATE messages SET rowid = rowid + 100"); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err := NewMessageStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	hits, err := store.SearchMessages("hello", "", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].Message.ID != "a" {
+		t.Fatalf("hits = %+v, want message a", hits)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+	"google.golang.org/protobuf/proto"
+)
+
+// Stickers are square WebP images of this size
+const stickerSize = 512
+
+// Report whether a WebP file is animated, from the flags of its VP8X chunk
+func isAnimatedWebP(data []byte) bool {
+	return len(data) > 20 && string(data[12:16]) == "VP8X" && data[20]&0x02 != 0
+}
+
+// Turn a PNG or WebP image into a sticker. WebP files that are already
+// 512x512 are sent unchanged so animations survive; anything else is scaled
+// to fit, centred on a transparent canvas and encoded as lossless WebP.
+func prepareSticker(data []byte) ([]byte, bool, error) {
+	var src image.Image
+	var err error
+	switch http.DetectContentType(data) {
+	case "image/webp":
+		cfg, err := webp.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid WebP image: %v", err)
+		}
+		animated := isAnimatedWebP(data)
+		if cfg.Width == stickerSize && cfg.Height == stickerSize {
+			return data, animated, nil
+		}
+		if animated {
+			return nil, false, fmt.Errorf("animated stickers must already be %dx%d", stickerSize, stickerSize)
+		}
+		src, err = webp.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid WebP image: %v", err)
+		}
+	case "image/png":
+		src, err = png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid PNG image: %v", err)
+		}
+	default:
+		return nil, false, fmt.Errorf("sticker must be a PNG or WebP image")
+	}
+
+	b := src.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return nil, false, fmt.Errorf("sticker image is empty")
+	}
+	width, height := stickerSize, stickerSize
+	if b.Dx() > b.Dy() {
+		height = max(1, b.Dy()*stickerSize/b.Dx())
+	} else {
+		width = max(1, b.Dx()*stickerSize/b.Dy())
+	}
+	offset := image.Pt((stickerSize-width)/2, (stickerSize-height)/2)
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, stickerSize, stickerSize))
+	draw.CatmullRom.Scale(canvas, image.Rectangle{Min: offset, Max: offset.Add(image.Pt(width, height))}, src, b, draw.Over, nil)
+
+	sticker, err := encodeWebP(canvas)
+	if err != nil {
+		return nil, false, err
+	}
+	return sticker, false, nil
+}
+
+// Function to send a sticker. The sticker must already be a 512x512 WebP.
+func sendWhatsAppStickerMessage(client *whatsmeow.Client, recipient string, sticker []byte, animated bool, contextInfo *waE2E.ContextInfo) (bool, string, string, string) {
+	if !client.IsConnected() {
+		return false, "Not connected to WhatsApp", "", ""
+	}
+
+	recipientJID, err := parseChatJID(recipient)
+	if err != nil {
+		return false, fmt.Sprintf("Error parsing JID: %v", err), "", ""
+	}
+
+	// Stickers are uploaded as images
+	resp, err := client.Upload(context.Background(), sticker, whatsmeow.MediaImage)
+	if err != nil {
+		return false, fmt.Sprintf("Error uploading sticker: %v", err), "", ""
+	}
+
+	sendResp, err := client.SendMessage(context.Background(), recipientJID, stickerMessage(resp, animated, contextInfo))
+	if err != nil {
+		return false, fmt.Sprintf("Error sending sticker: %v", err), "", ""
+	}
+
+	return true, fmt.Sprintf("Sticker sent to %s", recipient), sendResp.ID, contextInfo.GetStanzaID()
+}
+
+// Build a sticker message for a sticker already uploaded to WhatsApp
+func stickerMessage(resp whatsmeow.UploadResponse, animated bool, contextInfo *waE2E.ContextInfo) *waE2E.Message {
+	return &waE2E.Message{
+		StickerMessage: &waE2E.StickerMessage{
+			Mimetype:      proto.String("image/webp"),
+			Width:         proto.Uint32(stickerSize),
+			Height:        proto.Uint32(stickerSize),
+			IsAnimated:    proto.Bool(animated),
+			URL:           &resp.URL,
+			DirectPath:    &resp.DirectPath,
+			MediaKey:      resp.MediaKey,
+			FileEncSHA256: resp.FileEncSHA256,
+			FileSHA256:    resp.FileSHA256,
+			FileLength:    &resp.FileLength,
+			ContextInfo:   contextInfo,
+		},
+	}
+}
+
+// Handler for sending a PNG or WebP image as a sticker
+func sendStickerHandler(client *whatsmeow.Client, messageStore *MessageStore, eventSink EventSink) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		req, err := parseMediaRequest(r, "sticker")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.Recipient == "" {
+			http.Error(w, "Recipient is required", http.StatusBadRequest)
+			return
+		}
+
+		sticker, animated, err := prepareSticker(req.data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		contextInfo, err := mediaContextInfo(client, messageStore, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		success, msg, msgID, parentMsgID := sendWhatsAppStickerMessage(client, req.Recipient, sticker, animated, contextInfo)
+		fmt.Println("Message sent", success, msg)
+		if !success {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponseWithLog{Success: false, Message: msg})
+			return
+		}
+
+		s3Key := fmt.Sprintf("whatsapp_failed_files/sticker_%d.webp", time.Now().UnixNano())
+		err = logSentMedia(client, messageStore, eventSink, req.Recipient, req.AdminPhone, "", msgID, parentMsgID,
+			MediaInfo{MediaType: "sticker", Mimetype: "image/webp"}, contextInfo.GetMentionedJID(), MediaUpload{Key: s3Key, Data: sticker})
+		if err != nil {
+			// The message itself went out, so only the log copy is missing
+			fmt.Println("Error logging sent media:", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SendMessageResponseWithLog{Success: true, Message: msg, MessageID: msgID})
+	}
+}
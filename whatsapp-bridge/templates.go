@@ -0,0 +1,441 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+var (
+	templateNamePattern     = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+	templateVariablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// Template is a reusable message body in Go text/template syntax. Variables
+// are referenced as {{.name}}; optional ones as {{index . "name"}}, which
+// renders as nothing rather than an error when the variable is not given.
+type Template struct {
+	Name              string    `json:"name"`
+	Body              string    `json:"body"`
+	RequiredVariables []string  `json:"required_variables"`
+	Description       string    `json:"description,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// SendTemplateRequest represents the request body for the send template API
+type SendTemplateRequest struct {
+	Recipient       string                 `json:"recipient"`
+	Template        string                 `json:"template"`
+	Variables       map[string]interface{} `json:"variables"`
+	AdminPhone      string                 `json:"admin_phone"`
+	ParentMessageID string                 `json:"wa_parent_message_id"`
+	RequireParent   bool                   `json:"require_parent"`
+	Mentions        []string               `json:"mentions"`
+}
+
+// Check the name, body and variable names of a template
+func (t *Template) validate() error {
+	if !templateNamePattern.MatchString(t.Name) {
+		return fmt.Errorf("template name must be 1-64 letters, digits, '.', '_' or '-'")
+	}
+	if strings.TrimSpace(t.Body) == "" {
+		return fmt.Errorf("template body is required")
+	}
+	if _, err := template.New(t.Name).Parse(t.Body); err != nil {
+		return fmt.Errorf("invalid template body: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	variables := make([]string, 0, len(t.RequiredVariables))
+	for _, name := range t.RequiredVariables {
+		if !templateVariablePattern.MatchString(name) {
+			return fmt.Errorf("invalid variable name %q", name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			variables = append(variables, name)
+		}
+	}
+	t.RequiredVariables = variables
+	return nil
+}
+
+// Render the template with the given variables. Every required variable must
+// be set, and referring to a variable that was not given is an error.
+func (t *Template) Render(variables map[string]interface{}) (string, error) {
+	var missing []string
+	for _, name := range t.RequiredVariables {
+		if value, ok := variables[name]; !ok || value == nil || value == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return "", fmt.Errorf("missing required variables: %s", strings.Join(missing, ", "))
+	}
+
+	tmpl, err := template.New(t.Name).Option("missingkey=error").Parse(t.Body)
+	if err != nil {
+		return "", fmt.Errorf("invalid template body: %v", err)
+	}
+	// Missing optional variables and null values would print as "<no value>"
+	values := make(map[string]interface{}, len(variables))
+	for _, name := range optionalVariables(tmpl) {
+		values[name] = ""
+	}
+	for name, value := range variables {
+		if value == nil {
+			value = ""
+		}
+		values[name] = value
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, values); err != nil {
+		return "", fmt.Errorf("error rendering template: %v", err)
+	}
+	if strings.TrimSpace(out.String()) == "" {
+		return "", fmt.Errorf("template rendered an empty message")
+	}
+	return out.String(), nil
+}
+
+// Find the variables a template reads as {{index . "name"}} or, inside
+// with and range, {{index $ "name"}}
+func optionalVariables(tmpl *template.Template) []string {
+	var names []string
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n != nil {
+				for _, child := range n.Nodes {
+					walk(child)
+				}
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n != nil {
+				for _, cmd := range n.Cmds {
+					walk(cmd)
+				}
+			}
+		case *parse.CommandNode:
+			if len(n.Args) == 3 {
+				fn, isIdent := n.Args[0].(*parse.IdentifierNode)
+				name, isString := n.Args[2].(*parse.StringNode)
+				if isIdent && fn.Ident == "index" && isString && isVariables(n.Args[1]) {
+					names = append(names, name.Text)
+				}
+			}
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		}
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			walk(t.Tree.Root)
+		}
+	}
+	return names
+}
+
+// Report whether node is . or $, either of which may be the variables
+func isVariables(node parse.Node) bool {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		return true
+	case *parse.VariableNode:
+		return len(n.Ident) == 1 && n.Ident[0] == "$"
+	}
+	return false
+}
+
+// Store a new template. Returns false if a template with that name exists.
+func (store *MessageStore) CreateTemplate(t Template) (bool, error) {
+	result, err := store.db.Exec(
+		`INSERT INTO templates (name, body, required_variables, description, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO NOTHING`,
+		t.Name, t.Body, strings.Join(t.RequiredVariables, ","), t.Description, t.CreatedAt, t.UpdatedAt,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// Replace the body, variables and description of a template. Returns
+// sql.ErrNoRows if there is no such template.
+func (store *MessageStore) UpdateTemplate(t Template) error {
+	result, err := store.db.Exec(
+		`UPDATE templates SET body = ?, required_variables = ?, description = ?, updated_at = ? WHERE name = ?`,
+		t.Body, strings.Join(t.RequiredVariables, ","), t.Description, t.UpdatedAt, t.Name,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Delete a template. Returns sql.ErrNoRows if there is no such template.
+func (store *MessageStore) DeleteTemplate(name string) error {
+	result, err := store.db.Exec(`DELETE FROM templates WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Scan a template row
+func scanTemplate(row interface{ Scan(...interface{}) error }, t *Template) error {
+	var required string
+	if err := row.Scan(&t.Name, &t.Body, &required, &t.Description, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		return err
+	}
+	t.RequiredVariables = []string{}
+	if required != "" {
+		t.RequiredVariables = strings.Split(required, ",")
+	}
+	return nil
+}
+
+// Get a template by name. Returns sql.ErrNoRows if there is no such template.
+func (store *MessageStore) GetTemplate(name string) (Template, error) {
+	var t Template
+	row := store.db.QueryRow(
+		`SELECT name, body, required_variables, description, created_at, updated_at FROM templates WHERE name = ?`,
+		name,
+	)
+	err := scanTemplate(row, &t)
+	return t, err
+}
+
+// List all templates by name
+func (store *MessageStore) ListTemplates() ([]Template, error) {
+	rows, err := store.db.Query(
+		`SELECT name, body, required_variables, description, created_at, updated_at FROM templates ORDER BY name`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []Template{}
+	for rows.Next() {
+		var t Template
+		if err := scanTemplate(rows, &t); err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+// Write a template lookup error as JSON
+func writeTemplateError(w http.ResponseWriter, err error) {
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Template not found"})
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to access template: %v", err)})
+}
+
+// Handler for creating and listing templates
+func templatesHandler(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			templates, err := messageStore.ListTemplates()
+			if err != nil {
+				writeTemplateError(w, err)
+				return
+			}
+			json.NewEncoder(w).Encode(templates)
+
+		case http.MethodPost:
+			var t Template
+			if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+				http.Error(w, "Error parsing request body", http.StatusBadRequest)
+				return
+			}
+			if err := t.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			t.CreatedAt = time.Now()
+			t.UpdatedAt = t.CreatedAt
+
+			w.Header().Set("Content-Type", "application/json")
+			created, err := messageStore.CreateTemplate(t)
+			if err != nil {
+				writeTemplateError(w, err)
+				return
+			}
+			if !created {
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Template already exists"})
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(t)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// Handler for reading, replacing and deleting one template
+func templateHandler(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			t, err := messageStore.GetTemplate(name)
+			if err != nil {
+				writeTemplateError(w, err)
+				return
+			}
+			json.NewEncoder(w).Encode(t)
+
+		case http.MethodPut:
+			var t Template
+			if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+				http.Error(w, "Error parsing request body", http.StatusBadRequest)
+				return
+			}
+			t.Name = name
+			if err := t.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			t.UpdatedAt = time.Now()
+
+			w.Header().Set("Content-Type", "application/json")
+			if err := messageStore.UpdateTemplate(t); err != nil {
+				writeTemplateError(w, err)
+				return
+			}
+			t, err := messageStore.GetTemplate(name)
+			if err != nil {
+				writeTemplateError(w, err)
+				return
+			}
+			json.NewEncoder(w).Encode(t)
+
+		case http.MethodDelete:
+			w.Header().Set("Content-Type", "application/json")
+			if err := messageStore.DeleteTemplate(name); err != nil {
+				writeTemplateError(w, err)
+				return
+			}
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: true, Message: "Template deleted"})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// Handler for rendering a template and sending it as a text message
+func sendTemplateHandler(client *whatsmeow.Client, messageStore *MessageStore, eventSink EventSink) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req SendTemplateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Error parsing request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Recipient == "" || req.Template == "" {
+			http.Error(w, "Recipient and template are required", http.StatusBadRequest)
+			return
+		}
+
+		t, err := messageStore.GetTemplate(req.Template)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Template not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to load template: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		message, err := t.Render(req.Variables)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Quote the parent message when replying
+		contextInfo, err := buildReplyContext(client, messageStore, req.Recipient, req.ParentMessageID, req.RequireParent)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		contextInfo, err = addMentions(client, contextInfo, req.Recipient, req.Mentions)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		success, msg, _ := sendTextMessage(client, messageStore, eventSink, SendMessageRequest{
+			Recipient:  req.Recipient,
+			Message:    message,
+			AdminPhone: req.AdminPhone,
+		}, contextInfo, WALogMessageForQueue{
+			Template:          t.Name,
+			TemplateVariables: req.Variables,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		if !success {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(SendMessageResponseWithLog{
+			Success: success,
+			Message: msg,
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemplateRender(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		required  []string
+		variables map[string]interface{}
+		want      string
+		wantErr   string
+	}{
+		{
+			name:      "required variable",
+			body:      "Hi {{.name}}",
+			required:  []string{"name"},
+			variables: map[string]interface{}{"name": "Ana"},
+			want:      "Hi Ana",
+		},
+		{
+			name:      "optional variable given",
+			body:      `Order {{.order}}{{index . "note"}}`,
+			variables: map[string]interface{}{"order": 7, "note": " (gift)"},
+			want:      "Order 7 (gift)",
+		},
+		{
+			name:      "optional variable missing",
+			body:      `Order {{.order}} {{index . "note"}}.`,
+			variables: map[string]interface{}{"order": 7},
+			want:      "Order 7 .",
+		},
+		{
+			name: "optional variable in a condition",
+			body: `Hello{{if index . "name"}} {{index . "name"}}{{end}}!`,
+			want: "Hello!",
+		},
+		{
+			name:      "optional variable inside with and range",
+			body:      `{{with .order}}#{{.}}{{end}}{{range .items}}{{index $ "sep"}}{{.}}{{end}}`,
+			variables: map[string]interface{}{"order": 1, "items": []string{"a", "b"}},
+			want:      "#1ab",
+		},
+		{
+			name:      "null value",
+			body:      `Hi {{.name}}{{index . "title"}}`,
+			variables: map[string]interface{}{"name": "Ana", "title": nil},
+			want:      "Hi Ana",
+		},
+		{
+			name:     "required variable missing",
+			body:     "Hi {{.name}}",
+			required: []string{"name"},
+			wantErr:  "missing required variables: name",
+		},
+		{
+			name:    "unknown variable",
+			body:    "Hi {{.name}}",
+			wantErr: "error rendering template",
+		},
+		{
+			name:    "empty result",
+			body:    `{{index . "name"}}`,
+			wantErr: "empty message",
+		},
+	}
+	for _, tt := range tests {
+		tmpl := Template{Name: "test", Body: tt.body, RequiredVariables: tt.required}
+		if err := tmpl.validate(); err != nil {
+			t.Fatalf("%s: validate: %v", tt.name, err)
+		}
+		got, err := tmpl.Render(tt.variables)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: Render error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Render error = %v", tt.name, err)
+		} else if got != tt.want {
+			t.Errorf("%s: Render = %q, want %q", tt.name, got, tt.want)
+		}
+		if strings.Contains(got, "<no value>") {
+			t.Errorf("%s: rendered %q", tt.name, got)
+		}
+	}
+}
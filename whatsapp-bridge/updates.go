@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/libsignal/logger"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"google.golang.org/protobuf/proto"
+)
+
+// Store a reaction against its target message. An empty emoji means the
+// sender removed their reaction.
+func (store *MessageStore) StoreReaction(messageID, chatJID, sender, emoji string, timestamp time.Time) error {
+	if emoji == "" {
+		_, err := store.db.Exec(
+			"DELETE FROM reactions WHERE message_id = ? AND chat_jid = ? AND sender = ?",
+			messageID, chatJID, sender,
+		)
+		return err
+	}
+
+	_, err := store.db.Exec(
+		`INSERT INTO reactions (message_id, chat_jid, sender, emoji, timestamp) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, chat_jid, sender) DO UPDATE SET emoji = excluded.emoji, timestamp = excluded.timestamp`,
+		messageID, chatJID, sender, emoji, timestamp.UTC(),
+	)
+	return err
+}
+
+// Replace the content of a stored message, keeping every earlier version in
+// message_revisions. The first edit also records the original content as
+// revision 0.
+func (store *MessageStore) EditMessage(messageID, chatJID, content string, editedAt time.Time) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var original string
+	var sentAt time.Time
+	err = tx.QueryRow("SELECT content, timestamp FROM messages WHERE id = ? AND chat_jid = ?", messageID, chatJID).Scan(&original, &sentAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("message %s not found in %s", messageID, chatJID)
+	} else if err != nil {
+		return err
+	}
+
+	var revision int
+	err = tx.QueryRow("SELECT COALESCE(MAX(revision), -1) FROM message_revisions WHERE message_id = ? AND chat_jid = ?", messageID, chatJID).Scan(&revision)
+	if err != nil {
+		return err
+	}
+	if revision < 0 {
+		_, err = tx.Exec(
+			"INSERT INTO message_revisions (message_id, chat_jid, revision, content, timestamp) VALUES (?, ?, 0, ?, ?)",
+			messageID, chatJID, original, sentAt.UTC(),
+		)
+		if err != nil {
+			return err
+		}
+		revision = 0
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO message_revisions (message_id, chat_jid, revision, content, timestamp) VALUES (?, ?, ?, ?, ?)",
+		messageID, chatJID, revision+1, content, editedAt.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`UPDATE messages SET content = ?, caption = CASE media_type WHEN '' THEN caption ELSE ? END, is_edited = 1
+		WHERE id = ? AND chat_jid = ?`,
+		content, content, messageID, chatJID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Mark a message as deleted for everyone. The content is kept so that the
+// stored history still shows what was removed.
+func (store *MessageStore) MarkMessageDeleted(messageID, chatJID string) error {
+	_, err := store.db.Exec("UPDATE messages SET is_deleted = 1 WHERE id = ? AND chat_jid = ?", messageID, chatJID)
+	return err
+}
+
+// Handle an incoming reaction. queueMsg carries the sender, recipient and
+// message details already worked out by the event handler.
+func handleReaction(messageStore *MessageStore, msg *events.Message, queueMsg WALogMessageForQueue, logger waLog.Logger, eventSink EventSink) {
+	reaction := msg.Message.GetReactionMessage()
+	targetID := reaction.GetKey().GetID()
+	if targetID == "" {
+		return
+	}
+
+	err := messageStore.StoreReaction(targetID, msg.Info.Chat.String(), msg.Info.Sender.User, reaction.GetText(), msg.Info.Timestamp)
+	if err != nil {
+		logger.Warnf("Failed to store reaction: %v", err)
+	}
+
+	queueMsg.Type = "reaction"
+	queueMsg.Message = reaction.GetText()
+	queueMsg.ParentMessageID = targetID
+	if err := publishEvent(queueMsg, eventSink); err != nil {
+		logger.Errorf("❌ Failed to send reaction to event sinks: %v", err)
+	} else {
+		logger.Infof("✅ Reaction sent to event sinks successfully")
+	}
+}
+
+// Handle incoming edits and revokes ("delete for everyone"). Other protocol
+// messages are ignored.
+func handleProtocolMessage(messageStore *MessageStore, msg *events.Message, queueMsg WALogMessageForQueue, logger waLog.Logger, eventSink EventSink) {
+	protocolMsg := msg.Message.GetProtocolMessage()
+	targetID := protocolMsg.GetKey().GetID()
+	chatJID := msg.Info.Chat.String()
+	if targetID == "" {
+		return
+	}
+
+	switch protocolMsg.GetType() {
+	case waE2E.ProtocolMessage_MESSAGE_EDIT:
+		content, _ := extractMessageContent(protocolMsg.GetEditedMessage())
+		editedAt := msg.Info.Timestamp
+		if ms := protocolMsg.GetTimestampMS(); ms != 0 {
+			editedAt = time.UnixMilli(ms)
+		}
+		if err := messageStore.EditMessage(targetID, chatJID, content, editedAt); err != nil {
+			logger.Warnf("Failed to store edit: %v", err)
+		}
+		queueMsg.Type = "edit"
+		queueMsg.Message = content
+
+	case waE2E.ProtocolMessage_REVOKE:
+		if err := messageStore.MarkMessageDeleted(targetID, chatJID); err != nil {
+			logger.Warnf("Failed to mark message deleted: %v", err)
+		}
+		queueMsg.Type = "revoke"
+		queueMsg.Message = ""
+
+	default:
+		return
+	}
+
+	queueMsg.ParentMessageID = targetID
+	if err := publishEvent(queueMsg, eventSink); err != nil {
+		logger.Errorf("❌ Failed to send %s to event sinks: %v", queueMsg.Type, err)
+	} else {
+		logger.Infof("✅ %s sent to event sinks successfully", queueMsg.Type)
+	}
+}
+
+// ReactMessageRequest is the body of POST /api/react
+type ReactMessageRequest struct {
+	ChatJID    string `json:"chat_jid"`
+	MessageID  string `json:"message_id"`
+	SenderJID  string `json:"sender_jid"` // sender of the target message, needed in groups
+	Emoji      string `json:"emoji"`      // empty removes our reaction
+	AdminPhone string `json:"admin_phone"`
+}
+
+// Parse a user JID given either in full or as a bare phone number
+func parseUserJID(value string) (types.JID, error) {
+	if strings.Contains(value, "@") {
+		return types.ParseJID(value)
+	}
+	value = strings.NewReplacer("+", "", "-", "", " ", "").Replace(value)
+	return types.NewJID(value, types.DefaultUserServer), nil
+}
+
+// Work out who sent the message being reacted to. An explicit sender wins;
+// otherwise the stored copy of the message is used. Unknown messages in
+// direct chats are assumed to come from the other party.
+func resolveMessageSender(messageStore *MessageStore, chatJID types.JID, messageID, senderJID string) (types.JID, error) {
+	if senderJID != "" {
+		return parseUserJID(senderJID)
+	}
+
+	stored, err := messageStore.GetMessage(messageID, chatJID.String())
+	if err == nil {
+		if stored.IsFromMe {
+			return types.EmptyJID, nil
+		}
+		if chatJID.Server != types.GroupServer {
+			return chatJID, nil
+		}
+		return parseUserJID(stored.Sender)
+	} else if err != sql.ErrNoRows {
+		return types.EmptyJID, err
+	}
+
+	if chatJID.Server == types.GroupServer {
+		return types.EmptyJID, fmt.Errorf("sender_jid is required for group messages that are not stored locally")
+	}
+	return chatJID, nil
+}
+
+// Handler for reacting to a message with an emoji
+func reactMessageHandler(client *whatsmeow.Client, messageStore *MessageStore, eventSink EventSink) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Method not allowed"})
+			return
+		}
+
+		var req ReactMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Invalid request payload"})
+			return
+		}
+
+		if req.ChatJID == "" || req.MessageID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "chat_jid and message_id are required"})
+			return
+		}
+
+		if !client.IsConnected() || client.Store.ID == nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Not connected to WhatsApp"})
+			return
+		}
+
+		chatJID, err := parseChatJID(req.ChatJID)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Invalid chat_jid: %v", err)})
+			return
+		}
+
+		senderJID, err := resolveMessageSender(messageStore, chatJID, req.MessageID, req.SenderJID)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: err.Error()})
+			return
+		}
+
+		reaction := client.BuildReaction(chatJID, senderJID, types.MessageID(req.MessageID), req.Emoji)
+		resp, err := client.SendMessage(context.Background(), chatJID, reaction)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to send reaction: %v", err)})
+			return
+		}
+
+		senderPhone := client.Store.ID.User
+		err = messageStore.StoreReaction(req.MessageID, chatJID.String(), senderPhone, req.Emoji, resp.Timestamp)
+		if err != nil {
+			logger.Error("Failed to store reaction:", err)
+		}
+
+		err = publishEvent(WALogMessageForQueue{
+			Type:            "reaction",
+			From:            senderPhone,
+			To:              req.ChatJID,
+			AdminPhone:      req.AdminPhone,
+			Message:         req.Emoji,
+			Time:            resp.Timestamp,
+			MessageID:       resp.ID,
+			ParentMessageID: req.MessageID,
+			Status:          "SENT",
+		}, eventSink)
+		if err != nil {
+			logger.Error("Failed to send reaction to event sinks:", err)
+		} else {
+			logger.Info("Reaction sent to event sinks successfully")
+		}
+
+		message := "Reaction sent"
+		if req.Emoji == "" {
+			message = "Reaction removed"
+		}
+		json.NewEncoder(w).Encode(SendMessageResponse{Success: true, Message: message})
+	}
+}
+
+// EditMessageRequest is the body of POST /api/edit-message
+type EditMessageRequest struct {
+	ChatJID    string `json:"chat_jid"`
+	MessageID  string `json:"message_id"`
+	Message    string `json:"message"`
+	AdminPhone string `json:"admin_phone"`
+}
+
+// Handler for editing one of our own text messages
+func editMessageHandler(client *whatsmeow.Client, messageStore *MessageStore, eventSink EventSink) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Method not allowed"})
+			return
+		}
+
+		var req EditMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Invalid request payload"})
+			return
+		}
+
+		if req.ChatJID == "" || req.MessageID == "" || req.Message == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "chat_jid, message_id and message are required"})
+			return
+		}
+
+		if !client.IsConnected() || client.Store.ID == nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Not connected to WhatsApp"})
+			return
+		}
+
+		chatJID, err := parseChatJID(req.ChatJID)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Invalid chat_jid: %v", err)})
+			return
+		}
+
+		// The stored copy tells us whether the message is ours and when it was sent
+		original, err := messageStore.GetMessage(req.MessageID, chatJID.String())
+		if err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Message not found in local history"})
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to look up message: %v", err)})
+			return
+		}
+
+		if !original.IsFromMe {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Only messages sent by this account can be edited"})
+			return
+		}
+		if original.MediaType != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Only text messages can be edited"})
+			return
+		}
+		if original.IsDeleted {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: "Deleted messages cannot be edited"})
+			return
+		}
+		if age := time.Since(original.Time); age > whatsmeow.EditWindow {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(SendMessageResponse{
+				Success: false,
+				Message: fmt.Sprintf("Message was sent %s ago; WhatsApp only allows edits within %s", age.Round(time.Second), whatsmeow.EditWindow),
+			})
+			return
+		}
+
+		edit := client.BuildEdit(chatJID, types.MessageID(req.MessageID), &waE2E.Message{
+			Conversation: proto.String(req.Message),
+		})
+		resp, err := client.SendMessage(context.Background(), chatJID, edit)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: fmt.Sprintf("Failed to edit message: %v", err)})
+			return
+		}
+
+		err = messageStore.EditMessage(req.MessageID, chatJID.String(), req.Message, resp.Timestamp)
+		if err != nil {
+			logger.Error("Failed to store edit:", err)
+		}
+
+		err = publishEvent(WALogMessageForQueue{
+			Type:            "edit",
+			From:            client.Store.ID.User,
+			To:              req.ChatJID,
+			AdminPhone:      req.AdminPhone,
+			Message:         req.Message,
+			Time:            resp.Timestamp,
+			MessageID:       resp.ID,
+			ParentMessageID: req.MessageID,
+			Status:          "SENT",
+		}, eventSink)
+		if err != nil {
+			logger.Error("Failed to send edit to event sinks:", err)
+		} else {
+			logger.Info("Edit sent to event sinks successfully")
+		}
+
+		json.NewEncoder(w).Encode(SendMessageResponse{Success: true, Message: "Message edited successfully"})
+	}
+}
@@ -0,0 +1,612 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"sort"
+)
+
+// A small lossless WebP (VP8L) encoder, enough to produce stickers without
+// cgo. It applies the subtract-green and predictor transforms, greedy LZ77
+// backward references and one set of Huffman codes for the whole image. See RFC 9649
+// for the bitstream format.
+
+const (
+	vp8lSignature     = 0x2f
+	vp8lMaxDimension  = 1 << 14
+	vp8lNumLiterals   = 256
+	vp8lNumLengths    = 24
+	vp8lNumDistances  = 40
+	vp8lMaxCodeLength = 15
+	vp8lMaxMatch      = 4096
+	vp8lMinMatch      = 3
+	vp8lDistanceCodes = 120 // short 2D distance codes that precede linear distances
+	vp8lMaxDistance   = 1<<20 - vp8lDistanceCodes
+	vp8lHashBits      = 16
+	vp8lChainDepth    = 32
+	vp8lPredictorBits = 5 // predictor tiles are 32x32 pixels
+)
+
+// Order in which code length code lengths are written
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// vp8lBitWriter packs values least significant bit first
+type vp8lBitWriter struct {
+	buf   []byte
+	bits  uint64
+	nBits uint
+}
+
+func (w *vp8lBitWriter) write(value uint32, n uint) {
+	w.bits |= uint64(value) << w.nBits
+	w.nBits += n
+	for w.nBits >= 8 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits >>= 8
+		w.nBits -= 8
+	}
+}
+
+func (w *vp8lBitWriter) bytes() []byte {
+	if w.nBits > 0 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits, w.nBits = 0, 0
+	}
+	return w.buf
+}
+
+// huffmanLengths computes code lengths no longer than maxLength for the
+// given symbol counts. Counts are halved until the tree fits.
+func huffmanLengths(counts []int, maxLength int) []uint8 {
+	counts = append([]int(nil), counts...)
+	for {
+		lengths, longest := huffmanTreeLengths(counts)
+		if longest <= maxLength {
+			return lengths
+		}
+		for i, c := range counts {
+			if c > 0 {
+				counts[i] = (c + 1) / 2
+			}
+		}
+	}
+}
+
+// huffmanTreeLengths builds an unrestricted Huffman tree and returns the
+// depth of every used symbol and the maximum depth
+func huffmanTreeLengths(counts []int) ([]uint8, int) {
+	lengths := make([]uint8, len(counts))
+	var symbols []int
+	for symbol, c := range counts {
+		if c > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+	if len(symbols) == 1 {
+		lengths[symbols[0]] = 1
+		return lengths, 1
+	}
+	if len(symbols) == 0 {
+		return lengths, 0
+	}
+	sort.SliceStable(symbols, func(i, j int) bool { return counts[symbols[i]] < counts[symbols[j]] })
+
+	// Two-queue construction: leaves are 0..n-1 in weight order and internal
+	// nodes are appended after them, so a parent always has a higher index
+	n := len(symbols)
+	weights := make([]int, 2*n-1)
+	parents := make([]int, 2*n-1)
+	for i, symbol := range symbols {
+		weights[i] = counts[symbol]
+	}
+	leaf, internal, next := 0, n, n
+	pick := func() int {
+		if leaf < n && (internal >= next || weights[leaf] <= weights[internal]) {
+			leaf++
+			return leaf - 1
+		}
+		internal++
+		return internal - 1
+	}
+	for next < 2*n-1 {
+		a, b := pick(), pick()
+		weights[next] = weights[a] + weights[b]
+		parents[a], parents[b] = next, next
+		next++
+	}
+
+	depths := make([]int, 2*n-1)
+	longest := 0
+	for i := 2*n - 3; i >= 0; i-- {
+		depths[i] = depths[parents[i]] + 1
+		if i < n {
+			lengths[symbols[i]] = uint8(depths[i])
+			if depths[i] > longest {
+				longest = depths[i]
+			}
+		}
+	}
+	return lengths, longest
+}
+
+// vp8lPrefixCode is a canonical Huffman code with bit-reversed codes ready
+// to be written least significant bit first
+type vp8lPrefixCode struct {
+	lengths []uint8
+	codes   []uint32
+	single  int // symbol of a one-symbol simple code, or -1
+}
+
+func newPrefixCode(counts []int, maxLength int) *vp8lPrefixCode {
+	var used []int
+	for symbol, c := range counts {
+		if c > 0 {
+			used = append(used, symbol)
+		}
+	}
+
+	// A lone symbol below 256 can use a simple code that costs no bits
+	if len(used) == 0 || (len(used) == 1 && used[0] < vp8lNumLiterals) {
+		single := 0
+		if len(used) == 1 {
+			single = used[0]
+		}
+		return &vp8lPrefixCode{lengths: make([]uint8, len(counts)), codes: make([]uint32, len(counts)), single: single}
+	}
+
+	// Otherwise make sure there are two symbols so the tree is complete
+	if len(used) == 1 {
+		counts = append([]int(nil), counts...)
+		counts[0] = 1
+	}
+
+	lengths := huffmanLengths(counts, maxLength)
+	return &vp8lPrefixCode{lengths: lengths, codes: canonicalCodes(lengths), single: -1}
+}
+
+// canonicalCodes assigns codes in order of length and then symbol, as in
+// DEFLATE, and reverses them for the LSB-first bit writer
+func canonicalCodes(lengths []uint8) []uint32 {
+	var lengthCount [vp8lMaxCodeLength + 1]uint32
+	for _, l := range lengths {
+		if l > 0 {
+			lengthCount[l]++
+		}
+	}
+	var nextCode [vp8lMaxCodeLength + 2]uint32
+	code := uint32(0)
+	for l := 1; l <= vp8lMaxCodeLength; l++ {
+		code = (code + lengthCount[l-1]) << 1
+		nextCode[l] = code
+	}
+
+	codes := make([]uint32, len(lengths))
+	for symbol, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		codes[symbol] = bits.Reverse32(nextCode[l]) >> (32 - l)
+		nextCode[l]++
+	}
+	return codes
+}
+
+func (c *vp8lPrefixCode) writeSymbol(w *vp8lBitWriter, symbol int) {
+	w.write(c.codes[symbol], uint(c.lengths[symbol]))
+}
+
+// Write the code's description to the bitstream
+func (c *vp8lPrefixCode) writeTo(w *vp8lBitWriter) {
+	if c.single >= 0 {
+		w.write(1, 1) // simple code
+		w.write(0, 1) // one symbol
+		if c.single < 2 {
+			w.write(0, 1)
+			w.write(uint32(c.single), 1)
+		} else {
+			w.write(1, 1)
+			w.write(uint32(c.single), 8)
+		}
+		return
+	}
+	w.write(0, 1) // normal code
+
+	// Run-length encode the code lengths, using 17 and 18 for runs of zeros
+	type token struct{ symbol, extra, extraBits int }
+	var tokens []token
+	for i := 0; i < len(c.lengths); {
+		if c.lengths[i] != 0 {
+			tokens = append(tokens, token{symbol: int(c.lengths[i])})
+			i++
+			continue
+		}
+		run := 0
+		for i+run < len(c.lengths) && c.lengths[i+run] == 0 {
+			run++
+		}
+		i += run
+		for run > 0 {
+			switch {
+			case run >= 11:
+				n := min(run, 138)
+				tokens = append(tokens, token{18, n - 11, 7})
+				run -= n
+			case run >= 3:
+				tokens = append(tokens, token{17, run - 3, 3})
+				run = 0
+			default:
+				tokens = append(tokens, token{symbol: 0})
+				run--
+			}
+		}
+	}
+
+	counts := make([]int, len(vp8lCodeLengthOrder))
+	for _, t := range tokens {
+		counts[t.symbol]++
+	}
+	used := 0
+	for _, n := range counts {
+		if n > 0 {
+			used++
+		}
+	}
+	if used == 1 {
+		if counts[0] == 0 {
+			counts[0] = 1
+		} else {
+			counts[1] = 1
+		}
+	}
+	codeLengthLengths := huffmanLengths(counts, 7)
+	codeLengthCodes := canonicalCodes(codeLengthLengths)
+
+	numCodes := len(vp8lCodeLengthOrder)
+	for numCodes > 4 && codeLengthLengths[vp8lCodeLengthOrder[numCodes-1]] == 0 {
+		numCodes--
+	}
+	w.write(uint32(numCodes-4), 4)
+	for _, symbol := range vp8lCodeLengthOrder[:numCodes] {
+		w.write(uint32(codeLengthLengths[symbol]), 3)
+	}
+
+	w.write(0, 1) // lengths are given for the whole alphabet
+	for _, t := range tokens {
+		w.write(codeLengthCodes[t.symbol], uint(codeLengthLengths[t.symbol]))
+		if t.extraBits > 0 {
+			w.write(uint32(t.extra), uint(t.extraBits))
+		}
+	}
+}
+
+// vp8lPrefixEncode splits a length or distance into a prefix symbol and
+// extra bits
+func vp8lPrefixEncode(value int) (symbol int, extraBits uint, extra uint32) {
+	d := value - 1
+	if d < 4 {
+		return d, 0, 0
+	}
+	highest := bits.Len(uint(d)) - 1
+	second := (d >> (highest - 1)) & 1
+	extraBits = uint(highest - 1)
+	return 2*highest + second, extraBits, uint32(d) & (1<<extraBits - 1)
+}
+
+// vp8lToken is either a literal pixel or a backward reference
+type vp8lToken struct {
+	argb     uint32
+	length   int // 0 for a literal
+	distance int // distance code, including the 120 short codes
+}
+
+// Find backward references with a hash chain over pairs of pixels
+func vp8lBackwardRefs(pix []uint32, width int) []vp8lToken {
+	n := len(pix)
+	head := make([]int32, 1<<vp8lHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, n)
+	hash := func(i int) uint32 {
+		return (pix[i]*0x1e35a7bd ^ pix[i+1]*0x9e3779b1) >> (32 - vp8lHashBits)
+	}
+	insert := func(i int) {
+		if i+1 < n {
+			h := hash(i)
+			prev[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+	matchLength := func(i, j int) int {
+		limit := min(vp8lMaxMatch, n-i)
+		l := 0
+		for l < limit && pix[i+l] == pix[j+l] {
+			l++
+		}
+		return l
+	}
+
+	var tokens []vp8lToken
+	for i := 0; i < n; {
+		bestLength, bestDistance := 0, 0
+		try := func(j int) {
+			if j < 0 || j >= i || i-j > vp8lMaxDistance {
+				return
+			}
+			if l := matchLength(i, j); l > bestLength {
+				bestLength, bestDistance = l, i-j
+			}
+		}
+
+		// The previous pixel and the pixel above have short codes
+		try(i - 1)
+		try(i - width)
+		if i+1 < n {
+			for j, depth := head[hash(i)], 0; j >= 0 && depth < vp8lChainDepth && bestLength < vp8lMaxMatch; j, depth = prev[j], depth+1 {
+				try(int(j))
+			}
+		}
+
+		if bestLength < vp8lMinMatch {
+			tokens = append(tokens, vp8lToken{argb: pix[i]})
+			insert(i)
+			i++
+			continue
+		}
+
+		distance := bestDistance + vp8lDistanceCodes
+		switch bestDistance {
+		case width:
+			distance = 1
+		case 1:
+			distance = 2
+		}
+		tokens = append(tokens, vp8lToken{length: bestLength, distance: distance})
+		for k := 0; k < bestLength; k++ {
+			insert(i + k)
+		}
+		i += bestLength
+	}
+	return tokens
+}
+
+// Per-channel helpers on ARGB pixels. Channels wrap modulo 256.
+func vp8lSub(a, b uint32) uint32 {
+	alphaGreen := 0x00ff00ff + (a & 0xff00ff00) - (b & 0xff00ff00)
+	redBlue := 0xff00ff00 + (a & 0x00ff00ff) - (b & 0x00ff00ff)
+	return (alphaGreen & 0xff00ff00) | (redBlue & 0x00ff00ff)
+}
+
+func vp8lAverage2(a, b uint32) uint32 {
+	return ((a^b)&0xfefefefe)>>1 + (a & b)
+}
+
+func vp8lChannels(p uint32) [4]int {
+	return [4]int{int(p >> 24), int(p >> 16 & 0xff), int(p >> 8 & 0xff), int(p & 0xff)}
+}
+
+func vp8lSelect(l, t, tl uint32) uint32 {
+	lc, tc, tlc := vp8lChannels(l), vp8lChannels(t), vp8lChannels(tl)
+	distL, distT := 0, 0
+	for i := range lc {
+		distL += abs(tlc[i] - tc[i])
+		distT += abs(tlc[i] - lc[i])
+	}
+	if distL < distT {
+		return l
+	}
+	return t
+}
+
+func vp8lClampAddSubtractFull(l, t, tl uint32) uint32 {
+	lc, tc, tlc := vp8lChannels(l), vp8lChannels(t), vp8lChannels(tl)
+	var p uint32
+	for i := range lc {
+		p = p<<8 | uint32(max(0, min(255, lc[i]+tc[i]-tlc[i])))
+	}
+	return p
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Predictor modes tried for each tile (RFC 9649 section 4.1)
+var vp8lPredictorModes = []uint32{1, 2, 7, 11, 12}
+
+func vp8lPredict(mode uint32, l, t, tl uint32) uint32 {
+	switch mode {
+	case 1:
+		return l
+	case 2:
+		return t
+	case 7:
+		return vp8lAverage2(l, t)
+	case 11:
+		return vp8lSelect(l, t, tl)
+	case 12:
+		return vp8lClampAddSubtractFull(l, t, tl)
+	}
+	return 0xff000000
+}
+
+// Apply the predictor transform, picking for each tile the mode with the
+// smallest residuals. Returns the residuals and the sub-image of modes.
+func vp8lApplyPredictor(pix []uint32, width, height int) (residuals, modes []uint32, tilesX int) {
+	tileSize := 1 << vp8lPredictorBits
+	tilesX = (width + tileSize - 1) / tileSize
+	tilesY := (height + tileSize - 1) / tileSize
+	modes = make([]uint32, tilesX*tilesY)
+	residuals = make([]uint32, len(pix))
+
+	// The first row predicts from the left and the first column from above,
+	// whatever the tile's mode
+	predict := func(x, y int, mode uint32) uint32 {
+		i := y*width + x
+		switch {
+		case x == 0 && y == 0:
+			return 0xff000000
+		case y == 0:
+			return pix[i-1]
+		case x == 0:
+			return pix[i-width]
+		}
+		return vp8lPredict(mode, pix[i-1], pix[i-width], pix[i-width-1])
+	}
+
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tilesX; tx++ {
+			x0, y0 := tx*tileSize, ty*tileSize
+			x1, y1 := min(x0+tileSize, width), min(y0+tileSize, height)
+
+			bestMode, bestCost := vp8lPredictorModes[0], -1
+			for _, mode := range vp8lPredictorModes {
+				cost := 0
+				for y := y0; y < y1; y++ {
+					for x := x0; x < x1; x++ {
+						for _, c := range vp8lChannels(vp8lSub(pix[y*width+x], predict(x, y, mode))) {
+							cost += abs(int(int8(c)))
+						}
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					bestMode, bestCost = mode, cost
+				}
+			}
+			modes[ty*tilesX+tx] = bestMode << 8 // the mode is read from the green channel
+
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					residuals[y*width+x] = vp8lSub(pix[y*width+x], predict(x, y, bestMode))
+				}
+			}
+		}
+	}
+	return residuals, modes, tilesX
+}
+
+// Write an entropy-coded image: the main image when topLevel is set, or a
+// transform's sub-image otherwise
+func vp8lWriteImage(w *vp8lBitWriter, pix []uint32, width int, topLevel bool) {
+	tokens := vp8lBackwardRefs(pix, width)
+
+	green := make([]int, vp8lNumLiterals+vp8lNumLengths)
+	red := make([]int, vp8lNumLiterals)
+	blue := make([]int, vp8lNumLiterals)
+	alpha := make([]int, vp8lNumLiterals)
+	dist := make([]int, vp8lNumDistances)
+	for _, t := range tokens {
+		if t.length == 0 {
+			green[t.argb>>8&0xff]++
+			red[t.argb>>16&0xff]++
+			blue[t.argb&0xff]++
+			alpha[t.argb>>24]++
+			continue
+		}
+		lengthSymbol, _, _ := vp8lPrefixEncode(t.length)
+		distSymbol, _, _ := vp8lPrefixEncode(t.distance)
+		green[vp8lNumLiterals+lengthSymbol]++
+		dist[distSymbol]++
+	}
+	codes := []*vp8lPrefixCode{
+		newPrefixCode(green, vp8lMaxCodeLength),
+		newPrefixCode(red, vp8lMaxCodeLength),
+		newPrefixCode(blue, vp8lMaxCodeLength),
+		newPrefixCode(alpha, vp8lMaxCodeLength),
+		newPrefixCode(dist, vp8lMaxCodeLength),
+	}
+
+	w.write(0, 1) // no color cache
+	if topLevel {
+		w.write(0, 1) // one set of prefix codes for the whole image
+	}
+	for _, code := range codes {
+		code.writeTo(w)
+	}
+
+	for _, t := range tokens {
+		if t.length == 0 {
+			codes[0].writeSymbol(w, int(t.argb>>8&0xff))
+			codes[1].writeSymbol(w, int(t.argb>>16&0xff))
+			codes[2].writeSymbol(w, int(t.argb&0xff))
+			codes[3].writeSymbol(w, int(t.argb>>24))
+			continue
+		}
+		symbol, extraBits, extra := vp8lPrefixEncode(t.length)
+		codes[0].writeSymbol(w, vp8lNumLiterals+symbol)
+		w.write(extra, extraBits)
+		symbol, extraBits, extra = vp8lPrefixEncode(t.distance)
+		codes[4].writeSymbol(w, symbol)
+		w.write(extra, extraBits)
+	}
+}
+
+// encodeWebP encodes img as a lossless WebP file
+func encodeWebP(img image.Image) ([]byte, error) {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > vp8lMaxDimension || height > vp8lMaxDimension {
+		return nil, fmt.Errorf("image size %dx%d is not supported by WebP", width, height)
+	}
+
+	// Collect ARGB pixels with the subtract-green transform applied. Fully
+	// transparent pixels are normalised so they compress as one run.
+	pix := make([]uint32, 0, width*height)
+	hasAlpha := false
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A != 0xff {
+				hasAlpha = true
+			}
+			if c.A == 0 {
+				pix = append(pix, 0)
+				continue
+			}
+			r, g, bl := uint32(c.R-c.G), uint32(c.G), uint32(c.B-c.G)
+			pix = append(pix, uint32(c.A)<<24|r<<16|g<<8|bl)
+		}
+	}
+	residuals, modes, tilesX := vp8lApplyPredictor(pix, width, height)
+
+	w := &vp8lBitWriter{}
+	w.write(vp8lSignature, 8)
+	w.write(uint32(width-1), 14)
+	w.write(uint32(height-1), 14)
+	if hasAlpha {
+		w.write(1, 1)
+	} else {
+		w.write(0, 1)
+	}
+	w.write(0, 3) // version
+
+	// Transforms are listed in the order they were applied
+	w.write(1, 1)
+	w.write(2, 2) // subtract green
+	w.write(1, 1)
+	w.write(0, 2) // predictor
+	w.write(vp8lPredictorBits-2, 3)
+	vp8lWriteImage(w, modes, tilesX, false)
+	w.write(0, 1) // no more transforms
+
+	vp8lWriteImage(w, residuals, width, true)
+
+	data := w.bytes()
+	padding := len(data) & 1
+	out := make([]byte, 0, 20+len(data)+padding)
+	out = append(out, "RIFF"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(12+len(data)+padding))
+	out = append(out, "WEBPVP8L"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(data)))
+	out = append(out, data...)
+	if padding == 1 {
+		out = append(out, 0)
+	}
+	return out, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// Build an NRGBA image of the given size from a pixel function
+func testImage(width, height int, pixel func(x, y int) color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, pixel(x, y))
+		}
+	}
+	return img
+}
+
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	noise := rand.New(rand.NewSource(1))
+	randomColor := func(int, int) color.NRGBA {
+		return color.NRGBA{uint8(noise.Intn(256)), uint8(noise.Intn(256)), uint8(noise.Intn(256)), 0xff}
+	}
+
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"single pixel", testImage(1, 1, func(int, int) color.NRGBA { return color.NRGBA{1, 2, 3, 0xff} })},
+		{"solid", testImage(64, 64, func(int, int) color.NRGBA { return color.NRGBA{200, 30, 90, 0xff} })},
+		{"gradient", testImage(100, 70, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x * 2), uint8(y * 3), uint8(x + y), 0xff}
+		})},
+		// Odd sizes leave partial predictor tiles on the right and bottom
+		{"odd size", testImage(37, 65, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x * y), uint8(x ^ y), uint8(x), 0xff}
+		})},
+		{"noise", testImage(50, 40, randomColor)},
+		{"translucent", testImage(48, 48, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x * 5), 0x80, uint8(y * 5), uint8(x * y)}
+		})},
+		// Long runs and repeated rows exercise backward references past the
+		// longest single match
+		{"repeated rows", testImage(512, 40, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x % 7 * 30), uint8(x % 5 * 40), uint8(x / 64), 0xff}
+		})},
+		{"wide stripes", testImage(600, 20, func(x, y int) color.NRGBA {
+			if (x/100)%2 == 0 {
+				return color.NRGBA{0, 0, 0, 0xff}
+			}
+			return color.NRGBA{0xff, 0xff, 0xff, 0xff}
+		})},
+		{"offset bounds", testImage(80, 80, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x), uint8(y), 0x40, 0xff}
+		}).SubImage(image.Rect(13, 21, 70, 77))},
+		{"sticker size", testImage(512, 512, func(x, y int) color.NRGBA {
+			if (x-256)*(x-256)+(y-256)*(y-256) > 200*200 {
+				return color.NRGBA{}
+			}
+			return color.NRGBA{uint8(x / 2), uint8(y / 2), 0xa0, 0xff}
+		})},
+	}
+	for _, tt := range tests {
+		data, err := encodeWebP(tt.img)
+		if err != nil {
+			t.Fatalf("%s: encodeWebP: %v", tt.name, err)
+		}
+		decoded, err := webp.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("%s: decoding the encoded image: %v", tt.name, err)
+			continue
+		}
+		assertSameImage(t, tt.name, tt.img, decoded)
+	}
+}
+
+func TestEncodeWebPHeader(t *testing.T) {
+	img := testImage(30, 20, func(x, y int) color.NRGBA { return color.NRGBA{uint8(x), uint8(y), 0, 0xff} })
+	data, err := encodeWebP(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := webp.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 30 || config.Height != 20 {
+		t.Errorf("DecodeConfig size = %dx%d, want 30x20", config.Width, config.Height)
+	}
+	if len(data)%2 != 0 {
+		t.Errorf("file length %d is odd, RIFF chunks are padded to even sizes", len(data))
+	}
+}
+
+func TestEncodeWebPRejectsSize(t *testing.T) {
+	for _, rect := range []image.Rectangle{
+		image.Rect(0, 0, 0, 10),
+		image.Rect(0, 0, vp8lMaxDimension+1, 1),
+	} {
+		if _, err := encodeWebP(image.NewNRGBA(rect)); err == nil {
+			t.Errorf("encodeWebP of %v succeeded, want an error", rect)
+		}
+	}
+}
+
+// Compare two images pixel by pixel. The encoder drops the colour of fully
+// transparent pixels, so only their alpha is compared.
+func assertSameImage(t *testing.T, name string, want, got image.Image) {
+	t.Helper()
+	wb, gb := want.Bounds(), got.Bounds()
+	if wb.Dx() != gb.Dx() || wb.Dy() != gb.Dy() {
+		t.Errorf("%s: decoded size %v, want %v", name, gb.Size(), wb.Size())
+		return
+	}
+	for y := 0; y < wb.Dy(); y++ {
+		for x := 0; x < wb.Dx(); x++ {
+			w := color.NRGBAModel.Convert(want.At(wb.Min.X+x, wb.Min.Y+y)).(color.NRGBA)
+			g := color.NRGBAModel.Convert(got.At(gb.Min.X+x, gb.Min.Y+y)).(color.NRGBA)
+			if w.A == 0 {
+				w = color.NRGBA{}
+				g.R, g.G, g.B = 0, 0, 0
+			}
+			if w != g {
+				t.Errorf("%s: pixel (%d, %d) = %v, want %v", name, x, y, g, w)
+				return
+			}
+		}
+	}
+}